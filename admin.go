@@ -0,0 +1,323 @@
+package rss2masto
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// FeedStatus is the runtime state of a feed reported by the admin API
+type FeedStatus struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Instance  string `json:"instance,omitempty"`
+	Paused    bool   `json:"paused"`
+	Health    string `json:"health"`                    // ok, failing, down or paused, see FeedHealth
+	WebSub    bool   `json:"websub"`                    // the feed has an active WebSub subscription
+	LastRun   int64  `json:"last_run"`                  // Unix timestamp of the last processed item
+	Count     int64  `json:"count"`                     // number of items posted since the start
+	SendTime  int64  `json:"send_time,omitempty"`       // Unix time the last post was sent
+	Followers int64  `json:"followers"`                 // follower count of the feed account
+	ETag      string `json:"etag,omitempty"`            // validator of the last response
+	Failures  int64  `json:"failures"`                  // consecutive failed fetches
+	NextRun   int64  `json:"next_run,omitempty"`        // estimated Unix time of the next check, 0 if unknown
+	Posts24h  int    `json:"posts_24h"`                 // items posted in the last 24 hours
+	LastError string `json:"last_error,omitempty"`      // last fetch, parse or post error
+	ErrorTime int64  `json:"last_error_time,omitempty"` // Unix time of the last error
+}
+
+// issue is the last error of a feed
+type issue struct {
+	time    time.Time
+	message string
+}
+
+// setError records the last error of the feed
+func (f *Feed) setError(err error) {
+	f.lastError.Store(&issue{time: time.Now(), message: redact(err.Error())})
+}
+
+// LastError returns the last fetch, parse or post error of the feed and its time,
+// or "" if there was none since the start
+func (f *Feed) LastError() (string, time.Time) {
+	if e := f.lastError.Load(); e != nil {
+		return e.message, e.time
+	}
+	return "", time.Time{}
+}
+
+// Paused reports whether the feed is paused. Paused feeds are neither polled nor
+// processed when pushed.
+func (f *Feed) Paused() bool {
+	return f.paused.Load()
+}
+
+// SetPaused pauses or resumes the feed. The state is saved with the runtime state.
+func (f *Feed) SetPaused(paused bool) {
+	f.paused.Store(paused)
+}
+
+// FeedStatuses returns the runtime state of all feeds
+func (fm *FeedsMonitor) FeedStatuses() []FeedStatus {
+	feeds := fm.feeds()
+	list := make([]FeedStatus, 0, len(feeds))
+	for _, f := range feeds {
+		list = append(list, fm.feedStatus(f))
+	}
+	return list
+}
+
+// feedStatus returns the runtime state of a feed
+func (fm *FeedsMonitor) feedStatus(f *Feed) FeedStatus {
+	s := FeedStatus{
+		Name:      f.label(),
+		URL:       f.URL(),
+		Instance:  f.instanceName(),
+		Paused:    f.Paused(),
+		Health:    string(f.Health()),
+		WebSub:    fm.isSubscribed(f),
+		Followers: f.Followers.Load(),
+		ETag:      string(f.ETag()),
+		Failures:  f.Failures(),
+		NextRun:   fm.nextRun(f),
+		Posts24h:  f.stats.recentPosts(),
+	}
+	f.mu.Lock()
+	s.LastRun, s.Count = f.LastRun, f.Count
+	if !f.SendTime.IsZero() {
+		s.SendTime = f.SendTime.Unix()
+	}
+	f.mu.Unlock()
+	if msg, t := f.LastError(); msg != "" {
+		s.LastError, s.ErrorTime = msg, t.Unix()
+	}
+	return s
+}
+
+// nextRun estimates the time of the next check of a feed from the tick measured
+// between the last runs, or returns 0 before the second run and for paused feeds
+func (fm *FeedsMonitor) nextRun(f *Feed) int64 {
+	tick, last := fm.tick.Load(), fm.lastStart.Load()
+	if tick <= 0 || f.Paused() {
+		return 0
+	}
+	ticks := max(fm.pollInterval(f)-f.shedCounter.Load(), 1)
+	return time.Unix(0, last+ticks*tick).Unix()
+}
+
+// adminHandler serves the admin API, see Handler. Requests must carry
+// the configured token in an Authorization: Bearer header.
+func (fm *FeedsMonitor) adminHandler(ctx *fasthttp.RequestCtx) {
+	if !fm.authorized(ctx) {
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer realm="rss2masto"`)
+		adminError(ctx, fasthttp.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	path := strings.TrimPrefix(b2s(ctx.Path()), "/api")
+	switch {
+	case path == "/feeds":
+		if !allowMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+		adminJSON(ctx, fasthttp.StatusOK, fm.FeedStatuses())
+	case strings.HasPrefix(path, "/feeds/"):
+		fm.adminFeed(ctx, strings.TrimPrefix(path, "/feeds/"))
+	case path == "/hashdict":
+		adminHashDict(ctx)
+	case path == "/cache":
+		if !allowMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+		adminJSON(ctx, fasthttp.StatusOK, cacheStatus())
+	case path == "/reload":
+		if !allowMethod(ctx, fasthttp.MethodPost) {
+			return
+		}
+		result, err := fm.Reload()
+		if err != nil {
+			adminError(ctx, fasthttp.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		adminJSON(ctx, fasthttp.StatusOK, result)
+	default:
+		adminError(ctx, fasthttp.StatusNotFound, "not found")
+	}
+}
+
+// authorized reports whether the request carries the admin token, as a bearer token
+// or as the password of basic authentication (for browsers)
+func (fm *FeedsMonitor) authorized(ctx *fasthttp.RequestCtx) bool {
+	header := ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)
+	token, ok := bytes.CutPrefix(header, []byte("Bearer "))
+	if !ok {
+		encoded, basic := bytes.CutPrefix(header, []byte("Basic "))
+		if !basic {
+			return false
+		}
+		credentials, err := base64.StdEncoding.DecodeString(b2s(encoded))
+		if err != nil {
+			return false
+		}
+		_, token, _ = bytes.Cut(credentials, []byte{':'})
+	}
+	return subtle.ConstantTimeCompare(token, s2b(fm.Server.adminToken)) == 1
+}
+
+// adminFeedActions are the actions of /api/feeds/<name>/<action>
+var adminFeedActions = []string{"fetch", "pause", "resume", "engagement", "growth"}
+
+// adminFeed serves /api/feeds/<name>[/fetch|/pause|/resume|/engagement|/growth].
+// The path is URL-decoded, so names containing "/" work escaped or not; only
+// a known action is split off the name.
+func (fm *FeedsMonitor) adminFeed(ctx *fasthttp.RequestCtx, path string) {
+	name, action := path, ""
+	if i := strings.LastIndexByte(path, '/'); i >= 0 && slices.Contains(adminFeedActions, path[i+1:]) {
+		name, action = path[:i], path[i+1:]
+	}
+	f := fm.FeedByName(name)
+	if f == nil {
+		adminError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("unknown feed %q", name))
+		return
+	}
+
+	switch action {
+	case "":
+		if !allowMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+	case "fetch":
+		if !allowMethod(ctx, fasthttp.MethodPost) {
+			return
+		}
+		if f.Paused() {
+			adminError(ctx, fasthttp.StatusConflict, "feed paused")
+			return
+		}
+		if f.URL() == "" {
+			adminError(ctx, fasthttp.StatusConflict, "feed has no URL")
+			return
+		}
+		if !fm.fetchNow(f) {
+			adminError(ctx, fasthttp.StatusConflict, "feed is being fetched")
+			return
+		}
+		adminJSON(ctx, fasthttp.StatusAccepted, fm.feedStatus(f))
+		return
+	case "engagement":
+		if !allowMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+		since := engagementCadence[len(engagementCadence)-1].age
+		if d, err := time.ParseDuration(string(ctx.QueryArgs().Peek("since"))); err == nil {
+			since = d
+		}
+		top := ctx.QueryArgs().GetUintOrZero("top")
+		r, err := fm.Engagement(f, time.Now().Add(-since), cmp.Or(top, 10))
+		if err != nil {
+			adminError(ctx, fasthttp.StatusServiceUnavailable, err.Error())
+			return
+		}
+		adminJSON(ctx, fasthttp.StatusOK, r)
+		return
+	case "growth":
+		if !allowMethod(ctx, fasthttp.MethodGet) {
+			return
+		}
+		period := 7 * 24 * time.Hour
+		if d, err := time.ParseDuration(string(ctx.QueryArgs().Peek("period"))); err == nil && d > 0 {
+			period = d
+		}
+		r, err := fm.Growth(f, period)
+		if err != nil {
+			adminError(ctx, fasthttp.StatusServiceUnavailable, err.Error())
+			return
+		}
+		adminJSON(ctx, fasthttp.StatusOK, r)
+		return
+	case "pause", "resume":
+		if !allowMethod(ctx, fasthttp.MethodPost) {
+			return
+		}
+		f.SetPaused(action == "pause")
+		fm.feedLog(f).Info("Feed "+action+"d via admin API", "remote_addr", ctx.RemoteIP().String())
+		fm.updateHealth(f)
+	default:
+		adminError(ctx, fasthttp.StatusNotFound, "not found")
+		return
+	}
+	adminJSON(ctx, fasthttp.StatusOK, fm.feedStatus(f))
+}
+
+// adminHashDict serves /api/hashdict: GET returns the dictionary in file format,
+// POST replaces it with the request body, or reloads HashDictFile if the body is empty
+func adminHashDict(ctx *fasthttp.RequestCtx) {
+	switch {
+	case ctx.IsGet():
+		ctx.SetContentType("text/plain; charset=utf-8")
+		ctx.SetBody(ViewHashDict())
+	case ctx.IsPost():
+		var data []byte
+		if body := ctx.PostBody(); len(bytes.TrimSpace(body)) > 0 {
+			data = append([]byte(nil), body...)
+		}
+		ReloadHashDict(data)
+		adminJSON(ctx, fasthttp.StatusOK, map[string]int{"entries": bytes.Count(ViewHashDict(), []byte{'\n'})})
+	default:
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, "GET, POST")
+		adminError(ctx, fasthttp.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// cacheStatus returns the cache statistics reported by the admin API
+func cacheStatus() map[string]any {
+	status := map[string]any{"offline": Cache == nil || Cache.offline}
+	if Cache == nil {
+		return status
+	}
+	stats := Cache.Stats()
+	status["hits"], status["misses"] = stats.Hits, stats.Misses
+	if pool := Cache.PoolStats(); pool != nil {
+		status["pool"] = map[string]uint32{
+			"hits":        pool.Hits,
+			"misses":      pool.Misses,
+			"timeouts":    pool.Timeouts,
+			"total_conns": pool.TotalConns,
+			"idle_conns":  pool.IdleConns,
+			"stale_conns": pool.StaleConns,
+		}
+	}
+	return status
+}
+
+// allowMethod reports whether the request uses method, answering 405 if it doesn't
+func allowMethod(ctx *fasthttp.RequestCtx, method string) bool {
+	if b2s(ctx.Method()) == method {
+		return true
+	}
+	ctx.Response.Header.Set(fasthttp.HeaderAllow, method)
+	adminError(ctx, fasthttp.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// adminJSON writes a JSON response
+func adminJSON(ctx *fasthttp.RequestCtx, status int, v any) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	if err := jsoniter.ConfigDefault.NewEncoder(ctx).Encode(v); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// adminError writes a JSON error response
+func adminError(ctx *fasthttp.RequestCtx, status int, msg string) {
+	adminJSON(ctx, status, map[string]string{"error": msg})
+}
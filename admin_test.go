@@ -0,0 +1,208 @@
+package rss2masto
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// adminRequest serves a request of the admin API and returns the response
+func adminRequest(fm *FeedsMonitor, method, uri, token, body string) *fasthttp.Response {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if token != "" {
+		ctx.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	ctx.Request.SetBodyString(body)
+	fm.Handler()(&ctx)
+	resp := &fasthttp.Response{}
+	ctx.Response.CopyTo(resp)
+	return resp
+}
+
+func newAdminMonitor(t *testing.T) *FeedsMonitor {
+	t.Helper()
+	fm := loadInstancesConfig(t, `server:
+  admin_token: admin-secret
+instance:
+  url: https://mastodon.example
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      token: t
+    - name: Sport
+      url: https://example.com/sport.xml
+      token: t
+`)
+	if err := fm.resolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fm.Instance.Feeds {
+		fm.setFeedDefaults(f)
+	}
+	return fm
+}
+
+func TestAdminHandler_Auth(t *testing.T) {
+	fm := newAdminMonitor(t)
+	for _, token := range []string{"", "wrong"} {
+		resp := adminRequest(fm, "GET", "/api/feeds", token, "")
+		if resp.StatusCode() != fasthttp.StatusUnauthorized || len(resp.Header.Peek("WWW-Authenticate")) == 0 {
+			t.Errorf("token %q: status %d", token, resp.StatusCode())
+		}
+	}
+
+	fm.Server.AdminToken, fm.Server.adminToken = "", ""
+	if resp := adminRequest(fm, "GET", "/api/feeds", "", ""); resp.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("disabled admin API status = %d", resp.StatusCode())
+	}
+}
+
+func TestAdminHandler_Feeds(t *testing.T) {
+	fm := newAdminMonitor(t)
+	news := fm.Instance.Feeds[0]
+	news.Followers.Store(12)
+	news.SetETag([]byte(`"v1"`))
+	news.setError(errors.New("fetch returned status: 500"))
+
+	resp := adminRequest(fm, "GET", "/api/feeds", "admin-secret", "")
+	var list []FeedStatus
+	if err := jsoniter.Unmarshal(resp.Body(), &list); err != nil || resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("GET /api/feeds: %d %s", resp.StatusCode(), resp.Body())
+	}
+	if len(list) != 2 || list[0].Name != "News" || list[0].Followers != 12 || list[0].ETag != `"v1"` ||
+		list[0].LastError != "fetch returned status: 500" || list[0].ErrorTime == 0 {
+		t.Errorf("feeds = %+v", list)
+	}
+
+	// feeds are looked up by their exact name
+	if resp := adminRequest(fm, "POST", "/api/feeds/Spo/pause", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusNotFound || fm.Instance.Feeds[1].Paused() {
+		t.Errorf("pause by name prefix: %d", resp.StatusCode())
+	}
+	resp = adminRequest(fm, "POST", "/api/feeds/Sport/pause", "admin-secret", "")
+	var status FeedStatus
+	if err := jsoniter.Unmarshal(resp.Body(), &status); err != nil || status.Name != "Sport" || !status.Paused {
+		t.Fatalf("pause: %d %s", resp.StatusCode(), resp.Body())
+	}
+	if state := fm.collectState(); !state.Feeds["Sport"].Paused {
+		t.Error("pause not saved with the state")
+	}
+	if resp := adminRequest(fm, "POST", "/api/feeds/Sport/fetch", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusConflict {
+		t.Errorf("fetch of a paused feed: %d", resp.StatusCode())
+	}
+	adminRequest(fm, "POST", "/api/feeds/Sport/resume", "admin-secret", "")
+	if fm.Instance.Feeds[1].Paused() {
+		t.Error("feed not resumed")
+	}
+
+	for _, tt := range []struct {
+		method, uri string
+		want        int
+	}{
+		{"GET", "/api/feeds/Nope", fasthttp.StatusNotFound},
+		{"GET", "/api/feeds/News/unknown", fasthttp.StatusNotFound},
+		{"GET", "/api/feeds/News/pause", fasthttp.StatusMethodNotAllowed},
+		{"DELETE", "/api/feeds", fasthttp.StatusMethodNotAllowed},
+		{"GET", "/api/feeds/News", fasthttp.StatusOK},
+	} {
+		if resp := adminRequest(fm, tt.method, tt.uri, "admin-secret", ""); resp.StatusCode() != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.uri, resp.StatusCode(), tt.want)
+		}
+	}
+}
+
+func TestAdminHandler_SlashInName(t *testing.T) {
+	fm := newAdminMonitor(t)
+	fm.Instance.Feeds[1].Name = "News/Local"
+	for _, tt := range []struct{ method, uri string }{
+		{"POST", "/api/feeds/News/Local/pause"},
+		{"POST", "/api/feeds/News%2FLocal/resume"},
+		{"GET", "/api/feeds/News%2FLocal"},
+	} {
+		resp := adminRequest(fm, tt.method, tt.uri, "admin-secret", "")
+		var status FeedStatus
+		if err := jsoniter.Unmarshal(resp.Body(), &status); err != nil || status.Name != "News/Local" {
+			t.Errorf("%s %s: %d %s", tt.method, tt.uri, resp.StatusCode(), resp.Body())
+		}
+	}
+	if fm.Instance.Feeds[0].Paused() || fm.Instance.Feeds[1].Paused() {
+		t.Error("pause and resume reached the wrong feed")
+	}
+}
+
+func TestAdminHandler_Fetch(t *testing.T) {
+	fm := newAdminMonitor(t)
+	fetched := make(chan string, 2)
+	done := make(chan struct{})
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			fetched <- req.URI().String()
+			<-done
+			resp.SetStatusCode(fasthttp.StatusNotModified)
+			return nil
+		},
+	})
+	news := fm.Instance.Feeds[0]
+
+	resp := adminRequest(fm, "POST", "/api/feeds/News/fetch", "admin-secret", "")
+	if resp.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("fetch: %d %s", resp.StatusCode(), resp.Body())
+	}
+	select {
+	case uri := <-fetched:
+		if uri != "https://example.com/news.xml" {
+			t.Errorf("fetched %s", uri)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("feed not fetched")
+	}
+
+	// a feed is fetched once at a time, by the admin API or the scheduler
+	if resp := adminRequest(fm, "POST", "/api/feeds/News/fetch", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusConflict {
+		t.Errorf("fetch of a feed being fetched: %d", resp.StatusCode())
+	}
+	fm.GetFeed(news)
+	close(done)
+	for news.fetching.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if len(fetched) != 0 {
+		t.Errorf("%d more fetches", len(fetched))
+	}
+
+	news.URLs = nil
+	if resp := adminRequest(fm, "POST", "/api/feeds/News/fetch", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusConflict {
+		t.Errorf("fetch of a feed without URL: %d", resp.StatusCode())
+	}
+}
+
+func TestAdminHandler_HashDict(t *testing.T) {
+	original := ViewHashDict()
+	defer ReloadHashDict(original)
+	fm := newAdminMonitor(t)
+
+	resp := adminRequest(fm, "POST", "/api/hashdict", "admin-secret", "# test\nkrakow=Kraków\nlodz=Łódź\n")
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "{\"entries\":2}\n" {
+		t.Fatalf("POST /api/hashdict: %d %s", resp.StatusCode(), resp.Body())
+	}
+	resp = adminRequest(fm, "GET", "/api/hashdict", "admin-secret", "")
+	if string(resp.Body()) != "krakow=Kraków\nlodz=Łódź\n" {
+		t.Errorf("GET /api/hashdict = %q", resp.Body())
+	}
+}
+
+func TestAdminHandler_Cache(t *testing.T) {
+	fm := newAdminMonitor(t)
+	resp := adminRequest(fm, "GET", "/api/cache", "admin-secret", "")
+	var status map[string]any
+	if err := jsoniter.Unmarshal(resp.Body(), &status); err != nil || resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("GET /api/cache: %d %s", resp.StatusCode(), resp.Body())
+	}
+	if _, ok := status["hits"]; !ok {
+		t.Errorf("cache status = %v", status)
+	}
+}
@@ -0,0 +1,279 @@
+package rss2masto
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// AlertConfig configures the notifications sent when a feed or its account fails
+type AlertConfig struct {
+	Channels   []*AlertChannel `yaml:"channels,omitempty"`
+	Repeat     time.Duration   `yaml:"repeat,omitempty"`       // minimum time between notifications of the same problem, 6 hours if 0
+	MaxPerHour int             `yaml:"max_per_hour,omitempty"` // notifications of problems sent per hour, 20 if 0
+}
+
+// AlertChannel is a destination of alerts
+type AlertChannel struct {
+	Type     string   `yaml:"type"`               // webhook, smtp or mastodon
+	URL      string   `yaml:"url,omitempty"`      // webhook endpoint, or Mastodon instance (the instance block by default)
+	Secret   string   `yaml:"secret,omitempty"`   // webhook HMAC-SHA256 secret, ${VAR} references are expanded
+	Host     string   `yaml:"host,omitempty"`     // SMTP server, host:port
+	Username string   `yaml:"username,omitempty"` // SMTP user, no authentication if empty
+	Password string   `yaml:"password,omitempty"` // SMTP password, ${VAR} references are expanded
+	From     string   `yaml:"from,omitempty"`     // sender of the mails
+	To       []string `yaml:"to,omitempty"`       // mail recipients, or the accounts mentioned by the Mastodon direct message
+	Token    string   `yaml:"token,omitempty"`    // token of the Mastodon account sending the direct message, ${VAR} references are expanded
+
+	SecretFile   string `yaml:"secret_file,omitempty"`   // file containing the secret, overrides Secret
+	SecretEnv    string `yaml:"secret_env,omitempty"`    // environment variable containing the secret, overrides Secret
+	PasswordFile string `yaml:"password_file,omitempty"` // file containing the password, overrides Password
+	PasswordEnv  string `yaml:"password_env,omitempty"`  // environment variable containing the password, overrides Password
+	TokenFile    string `yaml:"token_file,omitempty"`    // file containing the token, overrides Token
+	TokenEnv     string `yaml:"token_env,omitempty"`     // environment variable containing the token, overrides Token
+
+	// resolved secrets, see FeedsMonitor.resolveSecrets
+	secret   string
+	password string
+	token    string
+}
+
+// Alert channel types supported in AlertChannel.Type
+const (
+	AlertWebhook  = "webhook"
+	AlertSMTP     = "smtp"
+	AlertMastodon = "mastodon"
+)
+
+const (
+	defaultAlertRepeat     = 6 * time.Hour
+	defaultAlertMaxPerHour = 20
+)
+
+// AlertKind is the kind of problem an alert reports
+type AlertKind string
+
+const (
+	AlertCredentials AlertKind = "credentials" // the token of the feed was rejected
+	AlertFetch       AlertKind = "fetch"       // the feed is down, see FeedDown
+	AlertPost        AlertKind = "post"        // posting to a publisher failed
+	AlertDeadLetter  AlertKind = "dead_letter" // an item whose post failed won't be retried, see giveUpPosts
+)
+
+// Alert is a notification of a problem, or of its recovery
+type Alert struct {
+	Kind      AlertKind `json:"kind"`
+	Feed      string    `json:"feed"`
+	Publisher string    `json:"publisher,omitempty"` // publisher of post alerts
+	Message   string    `json:"message,omitempty"`   // last error
+	Since     time.Time `json:"since"`               // time the problem was first seen
+	Resolved  bool      `json:"resolved"`            // the problem cleared
+}
+
+// key identifies the problem reported by the alert
+func (a *Alert) key() string {
+	return string(a.Kind) + "\x00" + a.Feed + "\x00" + a.Publisher
+}
+
+// Subject returns a one-line summary of the alert
+func (a *Alert) Subject() string {
+	var problem string
+	switch a.Kind {
+	case AlertCredentials:
+		problem = "credentials"
+	case AlertFetch:
+		problem = "feed"
+	case AlertPost:
+		problem = "posting to " + a.Publisher
+	case AlertDeadLetter:
+		// nothing recovers, the next posts are retried as usual
+		return fmt.Sprintf("[rss2masto] %s: post to %s given up", a.Feed, a.Publisher)
+	}
+	if a.Resolved {
+		return fmt.Sprintf("[rss2masto] %s: %s recovered", a.Feed, problem)
+	}
+	return fmt.Sprintf("[rss2masto] %s: %s failing", a.Feed, problem)
+}
+
+// Text returns the subject and the details of the alert
+func (a *Alert) Text() string {
+	since := a.Since.UTC().Format(time.RFC1123)
+	if a.Resolved {
+		return fmt.Sprintf("%s\n\nThe problem seen since %s cleared.", a.Subject(), since)
+	}
+	return fmt.Sprintf("%s\n\n%s\n\nSeen since %s.", a.Subject(), a.Message, since)
+}
+
+// alertState is a problem waiting for its recovery
+type alertState struct {
+	alert *Alert
+	sent  time.Time // last notification, zero if rate limited
+}
+
+// alerter deduplicates and rate limits alerts
+type alerter struct {
+	mu     sync.Mutex
+	active map[string]*alertState
+	sent   []time.Time // notifications of problems in the last hour
+	wg     sync.WaitGroup
+}
+
+// raise records a problem and returns the alert to send, or nil if it was
+// notified less than repeat ago or the hourly limit is reached
+func (al *alerter) raise(a *Alert, repeat time.Duration, maxPerHour int) *Alert {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	now := time.Now()
+	st := al.active[a.key()]
+	if st == nil {
+		if al.active == nil {
+			al.active = make(map[string]*alertState)
+		}
+		st = &alertState{alert: a}
+		al.active[a.key()] = st
+	}
+	st.alert.Message = a.Message
+	if !st.sent.IsZero() && now.Sub(st.sent) < repeat {
+		return nil
+	}
+	limit := now.Add(-time.Hour)
+	for len(al.sent) > 0 && al.sent[0].Before(limit) {
+		al.sent = al.sent[1:]
+	}
+	if len(al.sent) >= maxPerHour {
+		return nil
+	}
+	al.sent = append(al.sent, now)
+	st.sent = now
+	notice := *st.alert
+	return &notice
+}
+
+// resolve clears a problem and returns the recovery notice to send, if the problem was notified.
+// Recoveries aren't rate limited, as there are no more of them than notified problems.
+func (al *alerter) resolve(a *Alert) *Alert {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	st := al.active[a.key()]
+	if st == nil {
+		return nil
+	}
+	delete(al.active, a.key())
+	if st.sent.IsZero() {
+		return nil
+	}
+	recovery := *st.alert
+	recovery.Message, recovery.Resolved = "", true
+	return &recovery
+}
+
+// checkAlert raises an alert if err isn't nil, or clears the problem.
+// Notifications are sent in the background.
+func (fm *FeedsMonitor) checkAlert(kind AlertKind, f *Feed, publisher string, err error) {
+	fm.configMu.RLock()
+	cfg := fm.Alerts
+	fm.configMu.RUnlock()
+	if len(cfg.Channels) == 0 {
+		return
+	}
+
+	a := &Alert{Kind: kind, Feed: f.label(), Publisher: publisher, Since: time.Now()}
+	if err != nil {
+		a.Message = redact(err.Error())
+		a = fm.alerts.raise(a, cmp.Or(cfg.Repeat, defaultAlertRepeat), cmp.Or(cfg.MaxPerHour, defaultAlertMaxPerHour))
+	} else {
+		a = fm.alerts.resolve(a)
+	}
+	if a == nil {
+		return
+	}
+	fm.alerts.wg.Go(func() {
+		fm.sendAlert(cfg.Channels, a)
+	})
+}
+
+// sendAlert sends an alert to all channels, logging the failures
+func (fm *FeedsMonitor) sendAlert(channels []*AlertChannel, a *Alert) {
+	for _, ch := range channels {
+		if err := fm.sendAlertTo(ch, a); err != nil {
+			fm.log().Error("Error sending alert", errAttrs(err, "feed", a.Feed, "channel", ch.Type)...)
+		}
+	}
+}
+
+func (fm *FeedsMonitor) sendAlertTo(ch *AlertChannel, a *Alert) error {
+	client := fm.webClient()
+	switch ch.Type {
+	case AlertWebhook:
+		body, err := jsoniter.Marshal(struct {
+			*Alert
+			Subject string `json:"subject"`
+		}{a, a.Subject()})
+		if err != nil {
+			return err
+		}
+		var headers []string
+		if secret := cmp.Or(ch.secret, ch.Secret); secret != "" {
+			headers = append(headers, "X-Signature", webhookSignature(secret, body))
+		}
+		_, err = sendJSON(client, fasthttp.MethodPost, ch.URL, body, headers...)
+		return err
+	case AlertMastodon:
+		fm.configMu.RLock()
+		url := cmp.Or(ch.URL, fm.Instance.URL)
+		fm.configMu.RUnlock()
+		var mentions string
+		for _, to := range ch.To {
+			mentions += "@" + strings.TrimPrefix(to, "@") + " "
+		}
+		text := []rune(mentions + a.Text())
+		if len(text) > DefaultCharacterLimit {
+			text = append(text[:DefaultCharacterLimit-1], '…')
+		}
+		m := NewMastodonPublisher(url, cmp.Or(ch.token, ch.Token), DefaultCharacterLimit, client)
+		_, err := m.Publish(&Post{Text: string(text), Visibility: "direct"})
+		return err
+	case AlertSMTP:
+		return sendAlertMail(ch, a)
+	}
+	return fmt.Errorf("unknown alert channel type %q", ch.Type)
+}
+
+// smtpSendMail sends mails, replaced in tests
+var smtpSendMail = smtp.SendMail
+
+// sendAlertMail sends an alert by mail
+func sendAlertMail(ch *AlertChannel, a *Alert) error {
+	if len(ch.To) == 0 {
+		return errors.New("no mail recipient")
+	}
+	var auth smtp.Auth
+	if ch.Username != "" {
+		host, _, err := net.SplitHostPort(ch.Host)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", ch.Username, cmp.Or(ch.password, ch.Password), host)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", headerLine(ch.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", headerLine(strings.Join(ch.To, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerLine(a.Subject())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(a.Text(), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return smtpSendMail(ch.Host, auth, ch.From, ch.To, []byte(msg.String()))
+}
+
+// headerLine replaces the line breaks of a mail header value, which would start new headers
+var headerLine = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace
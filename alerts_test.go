@@ -0,0 +1,240 @@
+package rss2masto
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+func TestAlerter(t *testing.T) {
+	var al alerter
+	problem := func(feed string) *Alert {
+		return &Alert{Kind: AlertFetch, Feed: feed, Message: "down", Since: time.Now()}
+	}
+
+	if al.raise(problem("News"), time.Hour, 2) == nil {
+		t.Fatal("first alert not sent")
+	}
+	if al.raise(problem("News"), time.Hour, 2) != nil {
+		t.Error("repeated alert sent")
+	}
+	if al.raise(problem("News"), 0, 2) == nil {
+		t.Error("alert not repeated after the repeat interval")
+	}
+	// the hourly limit is reached
+	if al.raise(problem("Sport"), time.Hour, 2) != nil {
+		t.Error("rate limited alert sent")
+	}
+	if al.resolve(problem("Sport")) != nil {
+		t.Error("recovery of an alert that wasn't sent")
+	}
+	recovery := al.resolve(problem("News"))
+	if recovery == nil || !recovery.Resolved || recovery.Subject() != "[rss2masto] News: feed recovered" {
+		t.Errorf("resolve() = %+v", recovery)
+	}
+	if al.resolve(problem("News")) != nil {
+		t.Error("recovery sent twice")
+	}
+}
+
+func TestAlerts_Channels(t *testing.T) {
+	srv, requests := newRecordingServer(t, func(r *recordedRequest, w http.ResponseWriter) {
+		w.Write([]byte(`{"id":"1"}`))
+	})
+	var mails []string
+	smtpSendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, addr+" "+strings.Join(to, ",")+"\n"+string(msg))
+		return nil
+	}
+	defer func() { smtpSendMail = smtp.SendMail }()
+
+	fm := &FeedsMonitor{}
+	fm.Alerts.Channels = []*AlertChannel{
+		{Type: AlertWebhook, URL: srv.URL + "/alert", Secret: "s"},
+		{Type: AlertMastodon, URL: srv.URL, Token: "admin-token", To: []string{"@admin@example.com"}},
+		{Type: AlertSMTP, Host: "mail.example:25", From: "bot@example.com", To: []string{"ops@example.com"}},
+	}
+	f := NewTestFeed("News", "https://example.com/feed.xml")
+	fm.updateHealth(f)
+	f.failures.Store(downFailures)
+	f.setError(errors.New("fetch returned status: 502"))
+	fm.updateHealth(f)
+	fm.alerts.wg.Wait()
+
+	reqs := requests()
+	if len(reqs) != 2 || len(mails) != 1 {
+		t.Fatalf("%d requests, %d mails", len(reqs), len(mails))
+	}
+	for _, r := range reqs {
+		switch r.Path {
+		case "/alert":
+			if jsoniter.Get(r.Body, "kind").ToString() != "fetch" || jsoniter.Get(r.Body, "subject").ToString() != "[rss2masto] News: feed failing" ||
+				r.Header.Get("X-Signature") != webhookSignature("s", r.Body) {
+				t.Errorf("webhook alert: %s", r.Body)
+			}
+		case "/api/v1/statuses":
+			status := jsoniter.Get(r.Body, "status").ToString()
+			if r.Header.Get("Authorization") != "Bearer admin-token" || jsoniter.Get(r.Body, "visibility").ToString() != "direct" ||
+				!strings.HasPrefix(status, "@admin@example.com [rss2masto] News: feed failing") || !strings.Contains(status, "status: 502") {
+				t.Errorf("direct message: %s", r.Body)
+			}
+		default:
+			t.Errorf("unexpected request %s", r.Path)
+		}
+	}
+	if !strings.HasPrefix(mails[0], "mail.example:25 ops@example.com\nFrom: bot@example.com\r\n") || !strings.Contains(mails[0], "Subject: [rss2masto] News: feed failing\r\n") {
+		t.Errorf("mail: %s", mails[0])
+	}
+
+	// still down: deduplicated
+	f.failures.Add(1)
+	fm.updateHealth(f)
+	f.failures.Store(0)
+	fm.updateHealth(f)
+	fm.alerts.wg.Wait()
+	if len(mails) != 2 || !strings.Contains(mails[1], "Subject: [rss2masto] News: feed recovered\r\n") {
+		t.Errorf("mails after the recovery: %q", mails)
+	}
+}
+
+func TestSendAlertMail_Headers(t *testing.T) {
+	var mail string
+	smtpSendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mail = string(msg)
+		return nil
+	}
+	defer func() { smtpSendMail = smtp.SendMail }()
+
+	ch := &AlertChannel{Type: AlertSMTP, Host: "mail.example:25", From: "bot@example.com\r\nBcc: spam@example.com", To: []string{"ops@example.com"}}
+	a := &Alert{Kind: AlertFetch, Feed: "Actualités\r\nBcc: evil@example.com", Since: time.Now()}
+	if err := sendAlertMail(ch, a); err != nil {
+		t.Fatal(err)
+	}
+	header, _, _ := strings.Cut(mail, "\r\n\r\n")
+	if strings.Contains(header, "\r\nBcc:") {
+		t.Errorf("header injected:\n%s", header)
+	}
+	if !strings.Contains(header, "\r\nSubject: =?utf-8?q?[rss2masto]_Actualit=C3=A9s_Bcc:_evil@example.com:_feed_failing?=\r\n") {
+		t.Errorf("subject not encoded:\n%s", header)
+	}
+}
+
+func TestVerifyCredentials_Alerts(t *testing.T) {
+	srv, requests := newRecordingServer(t, func(r *recordedRequest, w http.ResponseWriter) {})
+	fm := &FeedsMonitor{}
+	fm.Alerts.Channels = []*AlertChannel{{Type: AlertWebhook, URL: srv.URL}}
+	f := NewTestFeed("News", "https://example.com/feed.xml")
+	f.Token = "news-token"
+
+	var status int
+	var clientErr error
+	mi := &MastodonInstance{URL: "https://mastodon.example", client: &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(status)
+			resp.SetBodyString(`{"error":"The access token is invalid"}`)
+			return clientErr
+		},
+	}}
+
+	// outages of the instance don't raise a credentials alert
+	status = fasthttp.StatusServiceUnavailable
+	if err := fm.verifyCredentials(f, mi); err == nil {
+		t.Error("verifyCredentials() accepted a 503 response")
+	}
+	status, clientErr = fasthttp.StatusOK, fasthttp.ErrTimeout
+	if err := fm.verifyCredentials(f, mi); err == nil {
+		t.Error("verifyCredentials() accepted a timeout")
+	}
+	fm.alerts.wg.Wait()
+	if n := len(requests()); n != 0 {
+		t.Fatalf("%d alerts for network errors, want none", n)
+	}
+
+	status, clientErr = fasthttp.StatusUnauthorized, nil
+	if err := fm.verifyCredentials(f, mi); err == nil {
+		t.Error("verifyCredentials() accepted a rejected token")
+	}
+	fm.alerts.wg.Wait()
+	reqs := requests()
+	if len(reqs) != 1 || jsoniter.Get(reqs[0].Body, "kind").ToString() != "credentials" {
+		t.Errorf("alerts for a rejected token: %d", len(reqs))
+	}
+}
+
+func TestDeadLetterAlert(t *testing.T) {
+	srv, requests := newRecordingServer(t, func(r *recordedRequest, w http.ResponseWriter) {})
+	fm := loadInstancesConfig(t, `instance:
+  url: https://mastodon.example
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: news-token
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initTargets(); err != nil {
+		t.Fatal(err)
+	}
+	for _, mi := range fm.instances {
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				if strings.Contains(string(req.Body()), "First") {
+					resp.SetStatusCode(fasthttp.StatusUnprocessableEntity)
+					resp.SetBodyString(`{"error":"Validation failed"}`)
+					return nil
+				}
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{"id":"1"}`)
+				return nil
+			},
+		}
+	}
+	fm.Alerts.Channels = []*AlertChannel{{Type: AlertWebhook, URL: srv.URL}}
+	f := fm.Instance.Feeds[0]
+	fm.setFeedDefaults(f)
+	f.LastRun = 0
+
+	deadLetters := func() []string {
+		fm.alerts.wg.Wait()
+		var subjects []string
+		for _, r := range requests() {
+			if jsoniter.Get(r.Body, "kind").ToString() == string(AlertDeadLetter) {
+				subjects = append(subjects, jsoniter.Get(r.Body, "subject").ToString()+": "+jsoniter.Get(r.Body, "message").ToString())
+			}
+		}
+		return subjects
+	}
+
+	id := time.Now().UnixNano()
+	first, second := time.Now().Add(-2*time.Minute), time.Now().Add(-time.Minute)
+	failing := &gofeed.Item{Title: "First", Link: "https://example.com/1", GUID: fmt.Sprintf("dead-letter-%d-1", id), PublishedParsed: &first}
+	posted := &gofeed.Item{Title: "Second", Link: "https://example.com/2", GUID: fmt.Sprintf("dead-letter-%d-2", id), PublishedParsed: &second}
+
+	debugMode = false
+	defer func() { debugMode = true }()
+
+	// the failed item is retried on the next run
+	fm.processFeed(f, &gofeed.Feed{Items: []*gofeed.Item{failing}})
+	if got := deadLetters(); len(got) != 0 {
+		t.Fatalf("dead letter alerts for an item still retried: %q", got)
+	}
+
+	// a newer item is posted: the last run moves past the failed item, which won't be retried
+	fm.processFeed(f, &gofeed.Feed{Items: []*gofeed.Item{posted, failing}})
+	got := deadLetters()
+	if len(got) != 1 || !strings.HasPrefix(got[0], `[rss2masto] News: post to mastodon given up: "First" was not posted`) {
+		t.Errorf("dead letter alerts = %q", got)
+	}
+	if len(f.failedPosts) != 0 {
+		t.Errorf("failed posts after giving up: %v", f.failedPosts)
+	}
+}
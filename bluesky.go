@@ -0,0 +1,251 @@
+package rss2masto
+
+import (
+	"cmp"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	DefaultBlueskyURL   = "https://bsky.social"
+	blueskyCharLimit    = 300
+	blueskyPostNSID     = "app.bsky.feed.post"
+	blueskyWebURLPrefix = "https://bsky.app/profile/"
+)
+
+// BlueskyPublisher publishes posts to an AT Protocol PDS (Bluesky) using an app password.
+// A session is created on first use and refreshed when its access token expires.
+type BlueskyPublisher struct {
+	URL        string // PDS base URL
+	Identifier string // handle or DID
+	Password   string // app password
+	Limit      int    // maximum post length
+	client     httpClient
+
+	mu      sync.Mutex
+	did     string
+	access  string
+	refresh string
+}
+
+type blueskyRecord struct {
+	Type      string         `json:"$type"`
+	Text      string         `json:"text"`
+	CreatedAt string         `json:"createdAt"`
+	Langs     []string       `json:"langs,omitempty"`
+	Facets    []blueskyFacet `json:"facets,omitempty"`
+	Embed     *blueskyEmbed  `json:"embed,omitempty"`
+}
+
+type blueskyFacet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []blueskyFeature `json:"features"`
+}
+
+type blueskyFeature struct {
+	Type string `json:"$type"`
+	URI  string `json:"uri,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+type blueskyEmbed struct {
+	Type     string `json:"$type"`
+	External struct {
+		URI         string `json:"uri"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	} `json:"external"`
+}
+
+// NewBlueskyPublisher creates a Bluesky publisher from its configuration
+func NewBlueskyPublisher(cfg *PublisherConfig, client httpClient) *BlueskyPublisher {
+	b := &BlueskyPublisher{
+		URL:        strings.TrimSuffix(cfg.URL, "/"),
+		Identifier: cfg.Identifier,
+		Password:   cmp.Or(cfg.password, cfg.Password),
+		Limit:      cfg.Limit,
+		client:     client,
+	}
+	if b.URL == "" {
+		b.URL = DefaultBlueskyURL
+	}
+	if b.Limit == 0 {
+		b.Limit = blueskyCharLimit
+	}
+	return b
+}
+
+// Publish creates an app.bsky.feed.post record with facets for the link and hashtags
+// and an external embed (link card) for the item link.
+// The returned ID is the at:// URI of the record.
+func (b *BlueskyPublisher) Publish(p *Post) (*Published, error) {
+	record := blueskyRecord{
+		Type:      blueskyPostNSID,
+		Text:      p.Text,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Facets:    blueskyFacets(p.Text, p.Link, p.Hashtags),
+	}
+	if len(p.Language) == 2 {
+		record.Langs = []string{p.Language}
+	}
+	if p.Link != "" {
+		record.Embed = &blueskyEmbed{Type: "app.bsky.embed.external"}
+		record.Embed.External.URI = p.Link
+		record.Embed.External.Title = p.Title
+		record.Embed.External.Description = p.Description
+	}
+
+	body, err := b.call("com.atproto.repo.createRecord", func(did string) any {
+		return map[string]any{
+			"repo":       did,
+			"collection": blueskyPostNSID,
+			"record":     record,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uri := jsoniter.Get(body, "uri").ToString()
+	published := &Published{ID: uri}
+	// at://<did>/app.bsky.feed.post/<rkey>
+	if repo, rkey, ok := splitATURI(uri); ok {
+		published.URL = blueskyWebURLPrefix + repo + "/post/" + rkey
+	}
+	return published, nil
+}
+
+// Edit is not supported, Bluesky posts are immutable
+func (b *BlueskyPublisher) Edit(id string, p *Post) error {
+	return ErrNotSupported
+}
+
+// Delete deletes the record with the at:// URI returned by Publish
+func (b *BlueskyPublisher) Delete(id string) error {
+	repo, rkey, ok := splitATURI(id)
+	if !ok {
+		return errors.New("invalid at:// URI: " + id)
+	}
+	_, err := b.call("com.atproto.repo.deleteRecord", func(string) any {
+		return map[string]any{
+			"repo":       repo,
+			"collection": blueskyPostNSID,
+			"rkey":       rkey,
+		}
+	})
+	return err
+}
+
+// Capabilities returns the post length limit (300 by default).
+// Link cards are embedded without thumbnails, images are not uploaded.
+func (b *BlueskyPublisher) Capabilities() Capabilities {
+	return Capabilities{
+		MaxLength: b.Limit,
+		Delete:    true,
+	}
+}
+
+// call performs an authenticated XRPC procedure. payload builds the request body
+// for the DID of the session. An expired session is refreshed (or recreated) once.
+func (b *BlueskyPublisher) call(method string, payload func(did string) any) ([]byte, error) {
+	did, access, err := b.session(false)
+	if err != nil {
+		return nil, err
+	}
+	body, err := sendJSON(b.client, fasthttp.MethodPost, b.URL+"/xrpc/"+method, payload(did), "Authorization", "Bearer "+access)
+	if !isExpiredToken(err) {
+		return body, err
+	}
+	if did, access, err = b.session(true); err != nil {
+		return nil, err
+	}
+	return sendJSON(b.client, fasthttp.MethodPost, b.URL+"/xrpc/"+method, payload(did), "Authorization", "Bearer "+access)
+}
+
+// session returns the DID and access token of the current session,
+// creating a session if there is none. With renew, the session is refreshed
+// using the refresh token, or created again if the refresh fails.
+func (b *BlueskyPublisher) session(renew bool) (did, access string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.access != "" && !renew {
+		return b.did, b.access, nil
+	}
+
+	var body []byte
+	if renew && b.refresh != "" {
+		body, err = sendJSON(b.client, fasthttp.MethodPost, b.URL+"/xrpc/com.atproto.server.refreshSession", nil, "Authorization", "Bearer "+b.refresh)
+	}
+	if body == nil {
+		body, err = sendJSON(b.client, fasthttp.MethodPost, b.URL+"/xrpc/com.atproto.server.createSession", map[string]string{
+			"identifier": b.Identifier,
+			"password":   b.Password,
+		})
+	}
+	if err != nil {
+		b.access, b.refresh = "", ""
+		return "", "", err
+	}
+
+	b.did = jsoniter.Get(body, "did").ToString()
+	b.access = jsoniter.Get(body, "accessJwt").ToString()
+	b.refresh = jsoniter.Get(body, "refreshJwt").ToString()
+	return b.did, b.access, nil
+}
+
+// isExpiredToken reports whether the PDS rejected the access token
+func isExpiredToken(err error) bool {
+	var he *httpError
+	if !errors.As(err, &he) {
+		return false
+	}
+	return he.Status == fasthttp.StatusUnauthorized ||
+		(he.Status == fasthttp.StatusBadRequest && strings.Contains(he.Body, "ExpiredToken"))
+}
+
+// blueskyFacets returns rich text facets for the link and the hashtags found in text.
+// Facet indexes are UTF-8 byte offsets.
+func blueskyFacets(text, link, hashtags string) []blueskyFacet {
+	var facets []blueskyFacet
+	add := func(start, end int, feature blueskyFeature) {
+		var f blueskyFacet
+		f.Index.ByteStart, f.Index.ByteEnd = start, end
+		f.Features = []blueskyFeature{feature}
+		facets = append(facets, f)
+	}
+
+	if link != "" {
+		if i := strings.LastIndex(text, link); i >= 0 {
+			add(i, i+len(link), blueskyFeature{Type: "app.bsky.richtext.facet#link", URI: link})
+		}
+	}
+	from := 0
+	for tag := range strings.FieldsSeq(hashtags) {
+		i := strings.Index(text[from:], tag)
+		if i < 0 || !strings.HasPrefix(tag, "#") {
+			continue
+		}
+		start := from + i
+		from = start + len(tag)
+		add(start, from, blueskyFeature{Type: "app.bsky.richtext.facet#tag", Tag: tag[1:]})
+	}
+	return facets
+}
+
+// splitATURI splits "at://<repo>/<collection>/<rkey>" into repo and rkey
+func splitATURI(uri string) (repo, rkey string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(uri, "at://"), "/")
+	if !strings.HasPrefix(uri, "at://") || len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
@@ -3,7 +3,6 @@ package rss2masto
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net"
 	"os"
 	"sync/atomic"
@@ -97,9 +96,9 @@ func (c *CacheClient) Close() {
 	if !c.offline {
 		err := c.client.Close()
 		if err != nil {
-			fmt.Printf("Error closing Redis connection: %v", err)
+			logger().Error("Error closing Redis connection", errAttrs(err)...)
 		} else {
-			fmt.Println("Redis connection Close()")
+			logger().Debug("Redis connection closed")
 		}
 	}
 }
@@ -133,6 +132,26 @@ func (c *CacheClient) GetKeys(keyPattern string, count ...int64) ([]string, erro
 	return keys, err
 }
 
+// ScanKeys returns all keys matching a pattern, iterating the SCAN cursor to the end
+func (c *CacheClient) ScanKeys(keyPattern string) ([]string, error) {
+	if c.offline {
+		return nil, errOffline
+	}
+	var keys []string
+	var cursor uint64
+	for {
+		page, next, err := c.client.Scan(c.ctx, cursor, keyPattern, 1000).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
 // GetEx gets a value from redis with an expiration
 func (c *CacheClient) GetEx(key string, expiration time.Duration) (string, error) {
 	if c.offline {
@@ -167,16 +186,25 @@ func (c *CacheClient) Exists(key string) bool {
 
 // ZAdd adds members to a sorted set stored at key, creating the sorted set if it doesn't exist
 func (c *CacheClient) ZAdd(key string, members []redis.Z) error {
+	if c.offline {
+		return errOffline
+	}
 	return c.client.ZAdd(c.ctx, key, members...).Err()
 }
 
 // ZRange returns the elements of the sorted set stored at key with a score between min and max (inclusive)
 func (c *CacheClient) ZRange(key string, start, stop int64) ([]string, error) {
+	if c.offline {
+		return nil, errOffline
+	}
 	return c.client.ZRange(c.ctx, key, start, stop).Result()
 }
 
 // ZRevRange returns the elements of the sorted set stored at key in reverse order with a score between start and stop (inclusive)
 func (c *CacheClient) ZRevRange(key string, start, stop int64) ([]string, error) {
+	if c.offline {
+		return nil, errOffline
+	}
 	return c.client.ZRangeArgs(c.ctx, redis.ZRangeArgs{
 		Key:   key,
 		Start: start,
@@ -187,6 +215,66 @@ func (c *CacheClient) ZRevRange(key string, start, stop int64) ([]string, error)
 	//return c.client.ZRevRange(c.ctx, key, start, stop).Result()
 }
 
+// ZRevRangeByScore returns up to count elements of the sorted set stored at key with a score
+// between min and max (inclusive, "-inf" and "+inf" allowed), highest scores first; all of them if count is 0
+func (c *CacheClient) ZRevRangeByScore(key, min, max string, count int64) ([]string, error) {
+	if c.offline {
+		return nil, errOffline
+	}
+	return c.client.ZRevRangeByScore(c.ctx, key, &redis.ZRangeBy{Min: min, Max: max, Count: count}).Result()
+}
+
+// ZRemRangeByScore removes the elements of the sorted set stored at key with a score between min and max
+func (c *CacheClient) ZRemRangeByScore(key, min, max string) error {
+	if c.offline {
+		return errOffline
+	}
+	return c.client.ZRemRangeByScore(c.ctx, key, min, max).Err()
+}
+
+// ZRemRangeByRank removes the elements of the sorted set stored at key with a rank between start and stop,
+// negative ranks counting from the highest score
+func (c *CacheClient) ZRemRangeByRank(key string, start, stop int64) error {
+	if c.offline {
+		return errOffline
+	}
+	return c.client.ZRemRangeByRank(c.ctx, key, start, stop).Err()
+}
+
+// ZMove adds the members of the sorted set stored at src to the one stored at dst, keeping the
+// higher score of common members, and deletes src. dst keeps the longer time to live of both.
+func (c *CacheClient) ZMove(src, dst string) error {
+	if c.offline {
+		return errOffline
+	}
+	ttl, err := c.client.PTTL(c.ctx, src).Result()
+	if err != nil || ttl == -2 {
+		// -2: src doesn't exist
+		return err
+	}
+	dstTTL, err := c.client.PTTL(c.ctx, dst).Result()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.TxPipelined(c.ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(c.ctx, dst, &redis.ZStore{Keys: []string{dst, src}, Aggregate: "MAX"})
+		pipe.Del(c.ctx, src)
+		if ttl > 0 {
+			pipe.PExpire(c.ctx, dst, max(ttl, dstTTL))
+		}
+		return nil
+	})
+	return err
+}
+
+// Expire sets the time to live of a key
+func (c *CacheClient) Expire(key string, expiration time.Duration) error {
+	if c.offline {
+		return errOffline
+	}
+	return c.client.Expire(c.ctx, key, expiration).Err()
+}
+
 // Load retrieves a value from the cache for the given key and stores it in the value interface
 func (c *CacheClient) Load(key string, value any) error {
 	return c.cache.Get(c.ctx, key, value)
@@ -233,6 +321,14 @@ func (c *CacheClient) Delete(key string) error {
 	return c.cache.Delete(c.ctx, key)
 }
 
+// Ping checks the connection to redis
+func (c *CacheClient) Ping() error {
+	if c.offline {
+		return errOffline
+	}
+	return c.client.Ping(c.ctx).Err()
+}
+
 // Stats returns the cache statistics
 func (c *CacheClient) Stats() *cache.Stats {
 	return &cache.Stats{
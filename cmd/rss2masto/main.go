@@ -0,0 +1,473 @@
+// Command rss2masto publishes RSS/Atom feed items as Mastodon posts.
+//
+// Usage:
+//
+//	rss2masto [-config feed.yaml] [-log-level info] [-log-format text] [command] [arguments]
+//
+// Commands:
+//
+//	run [-tick 1m] [-watch] [-engagement 10m] [-followers 1h]
+//	                                     monitor feeds (default), SIGHUP reloads the configuration
+//	opml-import [flags] <file.opml>      import feeds from an OPML file into the configuration
+//	opml-export [file.opml]              export feeds as OPML (to stdout by default)
+//	validate [-online]                   check the configuration and list all problems,
+//	                                     -online also fetches the feed URLs
+//	history [-since 24h] [-limit 20] [-json] [feed]
+//	                                     list the posts recorded in the history
+//	growth [-period 168h] [-json] [feed] report the follower growth of the feed accounts
+//	engagement [-since 168h] [-top 5] [-json] [feed]
+//	                                     report the engagement of the recent posts
+//
+// The REDIS_HOST environment variable must be set, see the package documentation.
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glaydus/rss2masto"
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	config := flag.String("config", "./feed.yaml", "path of the configuration file")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	rss2masto.SetConfigFile(*config)
+
+	cmd, args := "run", flag.Args()
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "run":
+		err = run(args)
+	case "opml-import":
+		err = opmlImport(args)
+	case "opml-export":
+		err = opmlExport(args)
+	case "validate":
+		err = validate(*config, args)
+	case "history":
+		err = history(args)
+	case "growth":
+		err = growth(args)
+	case "engagement":
+		err = engagement(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// setupLogger sets the default logger, used by the library, writing to stderr
+func setupLogger(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: rss2masto [flags] [command] [arguments]
+
+Commands:
+  run [-tick 1m] [-watch] [-engagement 10m] [-followers 1h]
+                                   monitor feeds (default), SIGHUP reloads the configuration
+  opml-import [flags] <file.opml>  import feeds from an OPML file into the configuration
+  opml-export [file.opml]          export feeds as OPML (to stdout by default)
+  validate [-online]               check the configuration and list all problems,
+                                   -online also fetches the feed URLs
+  history [-since 24h] [-limit 20] [-json] [feed]
+                                   list the posts recorded in the history
+  growth [-period 168h] [-json] [feed]
+                                   report the follower growth of the feed accounts
+  engagement [-since 168h] [-top 5] [-json] [feed]
+                                   report the engagement of the recent posts
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// run monitors the feeds, calling Start on every tick until interrupted.
+// SIGHUP reloads the configuration.
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	tick := fs.Duration("tick", time.Minute, "scheduler tick")
+	watch := fs.Bool("watch", false, "reload the configuration when the file changes")
+	poll := fs.Duration("engagement", 10*time.Minute, "interval of the engagement polling of recent posts, 0 to disable")
+	followers := fs.Duration("followers", time.Hour, "interval of the follower samples of the feed accounts, 0 to disable")
+	fs.Parse(args)
+
+	fm, err := rss2masto.NewFeedsMonitor()
+	if err != nil {
+		return err
+	}
+	defer rss2masto.Cache.Close()
+
+	if fm.Server.Listen != "" {
+		go func() {
+			slog.Error("Server stopped", "error", fm.ListenAndServe())
+			os.Exit(1)
+		}()
+	}
+
+	if *watch {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			if err := fm.WatchConfig(done); err != nil {
+				slog.Error("Error watching configuration", "error", err)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(*tick)
+	defer ticker.Stop()
+	if *poll > 0 {
+		polls := time.NewTicker(*poll)
+		defer polls.Stop()
+		go func() {
+			for range polls.C {
+				fm.UpdateEngagement()
+			}
+		}()
+	}
+	if *followers > 0 {
+		samples := time.NewTicker(*followers)
+		defer samples.Stop()
+		go func() {
+			for range samples.C {
+				fm.UpdateFollowers()
+			}
+		}()
+	}
+
+	fm.Start()
+	for {
+		select {
+		case <-ticker.C:
+			fm.Start()
+		case <-hup:
+			// Reload waits for a running cycle, don't block the ticker
+			go func() {
+				if _, err := fm.Reload(); err != nil {
+					slog.Error("Error reloading configuration", "error", err)
+				}
+			}()
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// validate prints the problems of the configuration, exiting with status 1 if there are errors
+func validate(config string, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	online := fs.Bool("online", false, "fetch the feed URLs and report homepages and unreachable feeds")
+	fs.Parse(args)
+
+	problems, err := rss2masto.ValidateConfig()
+	if err != nil {
+		return err
+	}
+	invalid := false
+	for _, p := range problems {
+		fmt.Printf("%s:%s\n", config, p)
+		invalid = invalid || p.Severity == rss2masto.SeverityError
+	}
+	if invalid {
+		os.Exit(1)
+	}
+	warnings := len(problems)
+	if *online {
+		warnings += checkFeedURLs(config)
+	}
+	if warnings == 0 {
+		fmt.Println("Configuration OK")
+	}
+	return nil
+}
+
+// checkFeedURLs fetches the feed URLs of the configuration and prints a warning for
+// each homepage and unreachable URL, returning the number of warnings
+func checkFeedURLs(config string) int {
+	data, err := os.ReadFile(config)
+	if err != nil {
+		fmt.Printf("%s: warning: %v\n", config, err)
+		return 1
+	}
+	var cfg struct {
+		Instance struct {
+			Feeds []*rss2masto.Feed `yaml:"feed"`
+		} `yaml:"instance"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		fmt.Printf("%s: warning: %v\n", config, err)
+		return 1
+	}
+	parser := rss2masto.NewParser(nil)
+	warnings := 0
+	for i, f := range cfg.Instance.Feeds {
+		if f.Source == rss2masto.SourceScrape {
+			// scraped feeds are pages
+			continue
+		}
+		for j, u := range f.URLs {
+			path := fmt.Sprintf("instance.feed[%d].url", i)
+			if len(f.URLs) > 1 {
+				path = fmt.Sprintf("%s[%d]", path, j)
+			}
+			links, err := parser.DiscoverFeed(u)
+			switch {
+			case err != nil:
+				fmt.Printf("%s: warning: %s: %v\n", config, path, err)
+			case links[0] != u:
+				fmt.Printf("%s: warning: %s: %s is a page, its feed is %s\n", config, path, u, links[0])
+			default:
+				continue
+			}
+			warnings++
+		}
+	}
+	return warnings
+}
+
+// opmlImport imports an OPML file and saves the configuration
+func opmlImport(args []string) error {
+	fs := flag.NewFlagSet("opml-import", flag.ExitOnError)
+	var opts rss2masto.OPMLOptions
+	fs.StringVar(&opts.Token, "token", "", "Mastodon token assigned to imported feeds, written to the configuration in plaintext")
+	fs.StringVar(&opts.TokenEnv, "token-env", "", "environment variable with the Mastodon token of imported feeds")
+	fs.StringVar(&opts.TokenFile, "token-file", "", "file with the Mastodon token of imported feeds")
+	fs.BoolVar(&opts.Replace, "replace", false, "replace the configured feeds instead of adding to them")
+	fs.StringVar(&opts.CategoryAs, "category", "hashtag", "map outline categories to hashtag or prefix")
+	fs.Int64Var(&opts.Interval, "interval", 0, "check interval of imported feeds")
+	fs.StringVar(&opts.Visibility, "visibility", "", "visibility of imported feeds")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: rss2masto opml-import [flags] <file.opml>")
+	}
+	if opts.Token != "" && !strings.HasPrefix(opts.Token, "${") {
+		slog.Warn("The token is written to the configuration in plaintext, use -token-env or -token-file")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fm, err := rss2masto.LoadConfig()
+	if err != nil {
+		return err
+	}
+	n, err := fm.ImportOPML(f, opts)
+	if err != nil {
+		return err
+	}
+	if err := fm.SaveConfig(); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d feeds, %d feeds configured\n", n, len(fm.Instance.Feeds))
+	return nil
+}
+
+// opmlExport writes the configured feeds as OPML
+func opmlExport(args []string) error {
+	fm, err := rss2masto.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) > 0 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return fm.ExportOPML(w)
+}
+
+// history lists the posts recorded in the history of a feed, or of all feeds
+func history(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	since := fs.Duration("since", 24*time.Hour, "list posts of this period, 0 for the whole history")
+	limit := fs.Int("limit", 20, "maximum number of posts, 0 for no limit")
+	asJSON := fs.Bool("json", false, "write the posts as JSON lines")
+	fs.Parse(args)
+
+	fm, err := rss2masto.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := fm.ResolveSecrets(); err != nil {
+		return err
+	}
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+
+	var entries []rss2masto.HistoryEntry
+	if name := fs.Arg(0); name != "" {
+		f := fm.FeedByName(name)
+		if f == nil {
+			return fmt.Errorf("unknown feed %q", name)
+		}
+		entries, err = fm.History(f, from, *limit)
+	} else {
+		entries, err = fm.HistoryAll(from, *limit)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tFEED\tPUBLISHER\tTITLE\tURL")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Feed, e.Publisher, e.Title, cmp.Or(e.URL, e.Link))
+	}
+	return w.Flush()
+}
+
+// growth reports the follower growth of a feed account, or of all feed accounts
+func growth(args []string) error {
+	fs := flag.NewFlagSet("growth", flag.ExitOnError)
+	period := fs.Duration("period", 7*24*time.Hour, "reported period, e.g. 24h for daily growth")
+	asJSON := fs.Bool("json", false, "write the reports as JSON lines")
+	fs.Parse(args)
+
+	fm, err := rss2masto.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := fm.ResolveSecrets(); err != nil {
+		return err
+	}
+	feeds := fm.Instance.Feeds
+	if name := fs.Arg(0); name != "" {
+		f := fm.FeedByName(name)
+		if f == nil {
+			return fmt.Errorf("unknown feed %q", name)
+		}
+		feeds = []*rss2masto.Feed{f}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if !*asJSON {
+		fmt.Fprintln(w, "FEED\tFOLLOWERS\tCHANGE\tSTATUSES\tCHANGE\tPOSTS\tFAVOURITES\tREBLOGS\tREPLIES\t")
+	}
+	for _, f := range feeds {
+		r, err := fm.Growth(f, *period)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%+d\t%d\t%+d\t%d\t%d\t%d\t%d\t\n", r.Feed, r.Followers, r.FollowersChange,
+			r.Statuses, r.StatusesChange, r.Posts, r.Favourites, r.Reblogs, r.Replies)
+	}
+	return w.Flush()
+}
+
+// engagement reports the engagement of the recent posts of a feed account, or of all feed accounts
+func engagement(args []string) error {
+	fs := flag.NewFlagSet("engagement", flag.ExitOnError)
+	since := fs.Duration("since", 7*24*time.Hour, "report the posts of this period")
+	top := fs.Int("top", 5, "number of top posts listed per feed")
+	asJSON := fs.Bool("json", false, "write the reports as JSON lines")
+	fs.Parse(args)
+
+	fm, err := rss2masto.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := fm.ResolveSecrets(); err != nil {
+		return err
+	}
+	feeds := fm.Instance.Feeds
+	if name := fs.Arg(0); name != "" {
+		f := fm.FeedByName(name)
+		if f == nil {
+			return fmt.Errorf("unknown feed %q", name)
+		}
+		feeds = []*rss2masto.Feed{f}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, f := range feeds {
+		r, err := fm.Engagement(f, time.Now().Add(-*since), *top)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s: %d posts, %d favourites, %d reblogs, %d replies, median %g\n",
+			r.Feed, r.Posts, r.Favourites, r.Reblogs, r.Replies, r.Median)
+		for _, p := range r.Top {
+			fmt.Printf("  %5d  %s  %s\n", p.Total(), p.Title, cmp.Or(p.URL, p.Link))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package rss2masto
+
+import (
+	"cmp"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+//go:embed dashboard/*.html
+var dashboardFS embed.FS
+
+var dashboardTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+}).ParseFS(dashboardFS, "dashboard/*.html"))
+
+// Preview is the post a feed would publish next
+type Preview struct {
+	Post      *Post
+	Publisher string    // name of the publisher the post is rendered for
+	Date      time.Time // date of the item
+	Pending   bool      // the item wasn't posted yet; if false the feed has no new item and its latest one is shown
+}
+
+// PreviewPost fetches a feed and renders the next item it would post, for its first publisher.
+// The fetch is unconditional and leaves the state of the feed unchanged.
+func (fm *FeedsMonitor) PreviewPost(f *Feed) (*Preview, error) {
+	fm.configMu.RLock()
+	publishers := fm.feedPublishers(f)
+	instance := fm.feedInstance(f)
+	fm.configMu.RUnlock()
+	if len(publishers) == 0 {
+		return nil, errors.New("the feed has no publisher")
+	}
+
+	// a copy without validators, so FetchAndParse doesn't change the feed or call its hooks
+	tmp := &Feed{Name: f.label(), URLs: f.urlList(), Source: f.Source, Scrape: f.Scrape, preview: true}
+	tmp.EmptyEtag()
+	feed := fm.Parser.FetchAndParse(tmp)
+	if feed == nil {
+		msg, _ := tmp.LastError()
+		return nil, fmt.Errorf("fetch failed: %s", cmp.Or(msg, "no content"))
+	}
+	if len(feed.Items) == 0 {
+		return nil, errors.New("the feed has no items")
+	}
+	sortItems(feed)
+
+	f.mu.Lock()
+	lastRun := f.LastRun
+	f.mu.Unlock()
+
+	np := publishers[0]
+	limit := time.Now().Add(earlierDuration).Unix()
+	item, pending := feed.Items[0], false
+	for i := len(feed.Items) - 1; i >= 0; i-- {
+		it := feed.Items[i]
+		if t := itemTime(it); t < limit || t < lastRun {
+			continue
+		}
+		if !Cache.KeyExists(np.idempotencyKey(f, it.GUID)) {
+			item, pending = it, true
+			break
+		}
+	}
+
+	re := f.regexps()
+	item.Link = re.cleanLink(item.Link)
+	post, err := makePost(f, item, np, makeHashtags(item, f, re.tag), postLanguage(f, feed, instance), re)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return &Preview{
+		Post:      post,
+		Publisher: np.name,
+		Date:      time.Unix(itemTime(item), 0).In(fm.Location()),
+		Pending:   pending,
+	}, nil
+}
+
+// dashboardFeed is a row of the dashboard
+type dashboardFeed struct {
+	FeedStatus
+	Trend     string // points of the follower sparkline
+	Change    int64  // follower change over the trend
+	NextRun   string
+	SendTime  string
+	ErrorTime string
+}
+
+// dashboardHandler serves the dashboard (/dashboard) and the post previews
+// (/dashboard/preview/<name>). With an admin token, browsers are asked for it
+// as the password of basic authentication.
+func (fm *FeedsMonitor) dashboardHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() && !ctx.IsHead() {
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	if fm.Server.adminToken != "" && !fm.authorized(ctx) {
+		ctx.Error("Unauthorized", fasthttp.StatusUnauthorized)
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Basic realm="rss2masto"`)
+		return
+	}
+
+	path := b2s(ctx.Path())
+	var err error
+	ctx.SetContentType("text/html; charset=utf-8")
+	switch {
+	case path == "/dashboard" || path == "/dashboard/":
+		err = dashboardTemplates.ExecuteTemplate(ctx, "index.html", fm.dashboardData())
+	case strings.HasPrefix(path, "/dashboard/preview/"):
+		name := strings.TrimPrefix(path, "/dashboard/preview/")
+		f := fm.FeedByName(name)
+		if f == nil {
+			ctx.Error("Not Found", fasthttp.StatusNotFound)
+			return
+		}
+		data := map[string]any{"Feed": f.label()}
+		if preview, err := fm.PreviewPost(f); err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Preview"] = preview
+			data["Date"] = preview.Date.Format(time.DateTime)
+		}
+		err = dashboardTemplates.ExecuteTemplate(ctx, "preview.html", data)
+	default:
+		ctx.Error("Not Found", fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fm.log().Error("Dashboard template error", classAttrs(errClassTemplate, err)...)
+	}
+}
+
+// dashboardData returns the data of the dashboard page
+func (fm *FeedsMonitor) dashboardData() map[string]any {
+	loc := fm.Location()
+	format := func(t int64) string {
+		if t == 0 {
+			return ""
+		}
+		return time.Unix(t, 0).In(loc).Format(time.DateTime)
+	}
+
+	var feeds []dashboardFeed
+	counts := make(map[string]int)
+	for _, f := range fm.feeds() {
+		status := fm.feedStatus(f)
+		row := dashboardFeed{
+			FeedStatus: status,
+			NextRun:    format(status.NextRun),
+			SendTime:   format(status.SendTime),
+			ErrorTime:  format(status.ErrorTime),
+		}
+		trend := f.stats.followerTrend()
+		if len(trend) > 1 {
+			row.Trend = sparkline(trend, 100, 20)
+			row.Change = trend[len(trend)-1].Value - trend[0].Value
+		}
+		counts[row.Health]++
+		feeds = append(feeds, row)
+	}
+	return map[string]any{
+		"Feeds":     feeds,
+		"Counts":    counts,
+		"LastCheck": fm.LastCheckStr(),
+		"LastMonit": format(fm.LastMonit()),
+		"Now":       time.Now().In(loc).Format(time.DateTime),
+	}
+}
+
+// sparkline returns the points of an SVG polyline of width w and height h drawing the samples
+func sparkline(samples []sample, w, h int) string {
+	first, last := samples[0].Time, samples[len(samples)-1].Time
+	low, high := samples[0].Value, samples[0].Value
+	for _, s := range samples {
+		low, high = min(low, s.Value), max(high, s.Value)
+	}
+	var sb strings.Builder
+	for i, s := range samples {
+		x, y := 0.0, float64(h)/2
+		if last > first {
+			x = float64(s.Time-first) / float64(last-first) * float64(w)
+		}
+		if high > low {
+			y = float64(h) - float64(s.Value-low)/float64(high-low)*float64(h)
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(strconv.FormatFloat(x, 'f', 1, 64))
+		sb.WriteByte(',')
+		sb.WriteString(strconv.FormatFloat(y, 'f', 1, 64))
+	}
+	return sb.String()
+}
@@ -0,0 +1,183 @@
+package rss2masto
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// dashboardRequest serves a dashboard request with the given Authorization header
+func dashboardRequest(fm *FeedsMonitor, uri, auth string) *fasthttp.Response {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI(uri)
+	if auth != "" {
+		ctx.Request.Header.Set("Authorization", auth)
+	}
+	fm.Handler()(&ctx)
+	resp := &fasthttp.Response{}
+	ctx.Response.CopyTo(resp)
+	return resp
+}
+
+func TestDashboardHandler(t *testing.T) {
+	fm := newAdminMonitor(t)
+	fm.Server.Dashboard = true
+	news, sport := fm.Instance.Feeds[0], fm.Instance.Feeds[1]
+	news.failures.Store(3)
+	news.setError(&httpError{Status: 502})
+	sport.SetPaused(true)
+	now := time.Now()
+	news.stats.addFollowers(now.Add(-2*time.Hour), 10)
+	news.stats.addFollowers(now, 15)
+
+	if resp := dashboardRequest(fm, "/dashboard", ""); resp.StatusCode() != fasthttp.StatusUnauthorized ||
+		!strings.HasPrefix(string(resp.Header.Peek("WWW-Authenticate")), "Basic") {
+		t.Fatalf("dashboard without credentials: %d", resp.StatusCode())
+	}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:admin-secret"))
+	resp := dashboardRequest(fm, "/dashboard", basic)
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("dashboard: %d %s", resp.StatusCode(), resp.Body())
+	}
+	body := string(resp.Body())
+	for _, want := range []string{
+		`<a href="/dashboard/preview/News"`,
+		`<span class="badge down">down</span>`,
+		`<span class="badge paused">paused</span>`,
+		"returned status: 502",
+		`<polyline points="0.0,20.0 100.0,0.0"/>`,
+		`<span class="up">+5</span>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard without %q", want)
+		}
+	}
+	if strings.Contains(body, "http://") || strings.Contains(body, "src=") {
+		t.Error("dashboard references external assets")
+	}
+
+	fm.Server.Dashboard = false
+	if resp := dashboardRequest(fm, "/dashboard", basic); resp.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("disabled dashboard status = %d", resp.StatusCode())
+	}
+}
+
+func TestDashboardPreview(t *testing.T) {
+	fm := newAdminMonitor(t)
+	fm.Server.Dashboard = true
+	fm.Server.AdminToken, fm.Server.adminToken = "", ""
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	pub := time.Now().UTC().Format(time.RFC1123Z)
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			if len(req.Header.Peek("If-None-Match")) > 0 {
+				t.Error("preview fetch is conditional")
+			}
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.Header.Set("ETag", `"new"`)
+			resp.SetBodyString(`<?xml version="1.0"?><rss version="2.0"><channel><title>News</title>
+<item><title>Next &amp; new</title><link>https://example.com/next?source=rss</link><guid>preview-` + pub + `</guid><pubDate>` + pub + `</pubDate></item>
+</channel></rss>`)
+			return nil
+		},
+	})
+	hooks := &recordingHooks{}
+	fm.SetHooks(hooks)
+	news := fm.Instance.Feeds[0]
+	news.SetETag([]byte(`"old"`))
+	news.HashTag = "News"
+
+	resp := dashboardRequest(fm, "/dashboard/preview/News", "")
+	body := string(resp.Body())
+	if resp.StatusCode() != fasthttp.StatusOK || !strings.Contains(body, "Next post") ||
+		!strings.Contains(body, "Next &amp; new\n\n#News\n\nhttps://example.com/next</div>") {
+		t.Errorf("preview: %d %s", resp.StatusCode(), body)
+	}
+	if string(news.ETag()) != `"old"` || news.Failures() != 0 {
+		t.Error("preview changed the feed state")
+	}
+	if len(hooks.events) != 0 {
+		t.Errorf("preview called hooks: %v", hooks.events)
+	}
+	if resp := dashboardRequest(fm, "/dashboard/preview/Nope", ""); resp.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("preview of an unknown feed: %d", resp.StatusCode())
+	}
+}
+
+func TestFeedStats_Timeline(t *testing.T) {
+	var s feedStats
+	now := time.Now()
+	s.addPost(now.Add(-25 * time.Hour))
+	s.addPost(now.Add(-time.Hour))
+	s.addPost(now)
+	if n := s.recentPosts(); n != 2 || s.posted != 3 {
+		t.Errorf("recentPosts() = %d, posted %d", n, s.posted)
+	}
+
+	s.addFollowers(now.Add(-8*24*time.Hour), 1)
+	s.addFollowers(now.Add(-2*time.Hour), 10)
+	s.addFollowers(now.Add(-90*time.Minute), 11) // replaces the sample of the same hour
+	s.addFollowers(now, 12)
+	trend := s.followerTrend()
+	if len(trend) != 2 || trend[0].Value != 11 || trend[1].Value != 12 {
+		t.Errorf("followerTrend() = %v", trend)
+	}
+}
+
+func TestUpdateFollowers_Trend(t *testing.T) {
+	fm := loadInstancesConfig(t, `instance:
+  url: https://national.example
+  feed:
+    - name: News
+      token: t
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	fm.instances[""].client = &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusOK)
+			if string(req.URI().Path()) == "/api/v1/accounts/7" {
+				resp.SetBodyString(`{"id":"7","followers_count":120,"statuses_count":900}`)
+			} else {
+				resp.SetBodyString(`[]`)
+			}
+			return nil
+		},
+	}
+	f := fm.Instance.Feeds[0]
+	f.Id = 7
+	// the count read when the token was verified
+	f.stats.addFollowers(time.Now().Add(-2*time.Hour), 100)
+
+	fm.UpdateFollowers()
+	trend := f.stats.followerTrend()
+	if len(trend) != 2 || trend[1].Value != 120 || f.Followers.Load() != 120 {
+		t.Errorf("followerTrend() = %v, Followers = %d", trend, f.Followers.Load())
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	fm := &FeedsMonitor{}
+	f := NewTestFeed("News", "https://example.com/feed.xml")
+	f.Interval = 5
+	f.shedCounter.Store(2)
+	if fm.nextRun(f) != 0 {
+		t.Error("next run estimated without a tick")
+	}
+	start := time.Now()
+	fm.lastStart.Store(start.UnixNano())
+	fm.tick.Store(int64(time.Minute))
+	if got, want := fm.nextRun(f), start.Add(3*time.Minute).Unix(); got != want {
+		t.Errorf("nextRun() = %d, want %d", got, want)
+	}
+	f.SetPaused(true)
+	if fm.nextRun(f) != 0 {
+		t.Error("next run of a paused feed")
+	}
+}
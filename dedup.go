@@ -0,0 +1,337 @@
+package rss2masto
+
+import (
+	"bytes"
+	"math/bits"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+	"github.com/zeebo/xxh3"
+)
+
+// DedupConfig configures the detection of the same story posted by several feeds.
+// Feeds take part by naming a group in Feed.Dedup; an item is suppressed when
+// a feed of the same group posted an item with the same canonical link, or with
+// a near-identical title, within the window.
+type DedupConfig struct {
+	Window      time.Duration `yaml:"window,omitempty"`       // how long a posted item suppresses duplicates, 24h if zero
+	Distance    int           `yaml:"distance,omitempty"`     // max number of differing bits of title fingerprints, 3 if zero, -1 compares links only
+	Canonical   bool          `yaml:"canonical,omitempty"`    // follow <link rel="canonical"> of item pages (one request per new item)
+	StripParams []string      `yaml:"strip_params,omitempty"` // query parameters removed from links in addition to the tracking ones
+}
+
+const (
+	defaultDedupWindow   = 24 * time.Hour
+	defaultDedupDistance = 3
+	// titles with fewer tokens are only compared exactly, their fingerprints are too coarse
+	minFingerprintTokens = 4
+	dedupKeyPrefix       = "dedup:"
+)
+
+func (c DedupConfig) window() time.Duration {
+	if c.Window <= 0 {
+		return defaultDedupWindow
+	}
+	return c.Window
+}
+
+func (c DedupConfig) distance() int {
+	if c.Distance == 0 {
+		return defaultDedupDistance
+	}
+	return c.Distance
+}
+
+// trackingParams lists query parameters removed from links, parameters starting with utm_ are removed too
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_hsenc": true, "_hsmi": true,
+	"ref": true, "ref_src": true, "cmpid": true, "ocid": true,
+	"at_medium": true, "at_campaign": true, "at_custom1": true,
+}
+
+// dedupEntry is an item posted by a feed of a dedup group
+type dedupEntry struct {
+	GUID        string // hash of the item GUID
+	Link        string // canonical link
+	Title       string // normalised title
+	Fingerprint uint64 // SimHash of the title tokens, 0 for short titles
+	Feed        string // name of the feed that posted the item
+	Post        string // URL of the post, empty until published
+	Time        int64  // Unix time the item was claimed
+}
+
+// dedupIndex holds the recent items of each dedup group. It is persisted in the
+// cache, so duplicates are detected across restarts.
+type dedupIndex struct {
+	mu     sync.Mutex
+	groups map[string][]*dedupEntry
+}
+
+// entries returns the recent items of a group, loading them from the cache on first use
+func (d *dedupIndex) entries(group string, window time.Duration) []*dedupEntry {
+	if d.groups == nil {
+		d.groups = make(map[string][]*dedupEntry)
+	}
+	list, ok := d.groups[group]
+	if !ok {
+		var saved []*dedupEntry
+		if err := Cache.Load(dedupKeyPrefix+group, &saved); err == nil {
+			list = saved
+		}
+	}
+	limit := time.Now().Add(-window).Unix()
+	list = slices.DeleteFunc(list, func(e *dedupEntry) bool { return e.Time < limit })
+	d.groups[group] = list
+	return list
+}
+
+// save persists the items of a group; the caller holds mu
+func (d *dedupIndex) save(group string) {
+	if err := Cache.Save(dedupKeyPrefix+group, d.groups[group]); err != nil {
+		logger().Error("Cache store error", errAttrs(err, "dedup_group", group)...)
+	}
+}
+
+// claim claims an item for its feed and returns the claimed entry. If an item another
+// feed of the group posted within the window is the same story, it returns that entry
+// and true; items of the same feed are told apart by their GUID only. Retries of an
+// item of the same feed return the entry of the first attempt.
+// Claimed items are released if the item isn't published.
+func (d *dedupIndex) claim(group string, cfg DedupConfig, e *dedupEntry) (*dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := d.entries(group, cfg.window())
+	for _, prev := range list {
+		if prev.Feed == e.Feed && prev.GUID == e.GUID {
+			return prev, false
+		}
+	}
+	for _, prev := range list {
+		if prev.Feed != e.Feed && prev.isDuplicate(e, cfg.distance()) {
+			return prev, true
+		}
+	}
+	d.groups[group] = append(list, e)
+	return e, false
+}
+
+// publish records the post of a claimed item
+func (d *dedupIndex) publish(group string, e *dedupEntry, post string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.Post == "" {
+		e.Post = post
+		d.save(group)
+	}
+}
+
+// release drops a claimed item that wasn't published
+func (d *dedupIndex) release(group string, e *dedupEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.Post == "" {
+		d.groups[group] = slices.DeleteFunc(d.groups[group], func(prev *dedupEntry) bool { return prev == e })
+	}
+}
+
+// isDuplicate reports whether e is the same story as the posted item
+func (prev *dedupEntry) isDuplicate(e *dedupEntry, distance int) bool {
+	if prev.Link != "" && prev.Link == e.Link {
+		return true
+	}
+	if distance < 0 || e.Title == "" {
+		return false
+	}
+	if prev.Title == e.Title {
+		return true
+	}
+	return prev.Fingerprint != 0 && e.Fingerprint != 0 &&
+		bits.OnesCount64(prev.Fingerprint^e.Fingerprint) <= distance
+}
+
+// newDedupEntry returns the entry of an item of feed f; link is its canonical link if resolved, see canonicalLinks
+func newDedupEntry(cfg DedupConfig, f *Feed, guid, link, title string) *dedupEntry {
+	tokens := titleTokens(title)
+	e := &dedupEntry{
+		GUID:  hashString(guid),
+		Link:  canonicalURL(link, cfg.StripParams),
+		Title: strings.Join(tokens, " "),
+		Feed:  f.Name,
+		Time:  time.Now().Unix(),
+	}
+	if len(tokens) >= minFingerprintTokens {
+		e.Fingerprint = simHash(tokens)
+	}
+	return e
+}
+
+// canonicalLinks returns the <link rel="canonical"> URLs of the new items of a feed by GUID,
+// if its dedup group follows them. Item pages are fetched before processFeed locks the feed.
+func (fm *FeedsMonitor) canonicalLinks(f *Feed, feed *gofeed.Feed) map[string]string {
+	f.mu.Lock()
+	fm.configMu.RLock()
+	follow := f.Dedup != "" && fm.Dedup.Canonical
+	publishers := fm.feedPublishers(f)
+	fm.configMu.RUnlock()
+	limit := max(time.Now().Add(earlierDuration).Unix(), f.LastRun)
+	re := f.regexps()
+	f.mu.Unlock()
+	if !follow {
+		return nil
+	}
+
+	links := make(map[string]string)
+	for _, item := range feed.Items {
+		if item.Link == "" || itemTime(item) < limit {
+			continue
+		}
+		posted := !slices.ContainsFunc(publishers, func(np namedPublisher) bool {
+			return !Cache.KeyExists(np.idempotencyKey(f, item.GUID))
+		})
+		if posted {
+			continue
+		}
+		if canonical := fm.Parser.canonicalLink(re.cleanLink(item.Link)); canonical != "" {
+			links[item.GUID] = canonical
+		}
+	}
+	return links
+}
+
+// canonicalURL normalises a link for comparison: scheme, www. prefix, default
+// ports, fragments, trailing slashes and tracking parameters are dropped and the
+// remaining query parameters sorted. Unparseable links are returned as is.
+func canonicalURL(link string, strip []string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return link
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "utm_") || trackingParams[lower] || slices.Contains(strip, name) {
+			query.Del(name)
+		}
+	}
+
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	canonical := host + path
+	if len(query) > 0 {
+		// Encode sorts by key
+		canonical += "?" + query.Encode()
+	}
+	return canonical
+}
+
+// canonicalLink returns the <link rel="canonical"> URL of an item page, or "" if there is none.
+// A redirect (e.g. of a tracking link) is followed once.
+func (p *Parser) canonicalLink(link string) string {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.SetRequestURI(link)
+	if err := p.Client.Do(req, resp); err != nil {
+		return ""
+	}
+	if resp.StatusCode() >= 300 && resp.StatusCode() < 400 {
+		location := resolveLink(link, string(resp.Header.Peek(fasthttp.HeaderLocation)))
+		if location == "" {
+			return ""
+		}
+		link = location
+		req.SetRequestURI(link)
+		resp.Reset()
+		if err := p.Client.Do(req, resp); err != nil {
+			return link
+		}
+	}
+	if resp.StatusCode() != fasthttp.StatusOK || !isHTML(resp.Header.ContentType(), resp.Body()) {
+		return link
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(resp.Body()))
+	if err != nil {
+		return link
+	}
+	var canonical string
+	doc.Find("link[rel][href]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		rel, _ := s.Attr("rel")
+		if !slices.Contains(strings.Fields(strings.ToLower(rel)), "canonical") {
+			return true
+		}
+		href, _ := s.Attr("href")
+		canonical = resolveLink(link, href)
+		return false
+	})
+	if canonical == "" {
+		return link
+	}
+	return canonical
+}
+
+// resolveLink resolves href against base, returning "" for invalid or non-http links
+func resolveLink(base, href string) string {
+	b, err := url.Parse(base)
+	if err != nil || href == "" {
+		return ""
+	}
+	u, err := b.Parse(strings.TrimSpace(href))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+// titleTokens returns the lower case words of a title
+func titleTokens(title string) []string {
+	return strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// simHash computes a 64-bit SimHash of the tokens and their bigrams.
+// Similar token lists give fingerprints differing in few bits.
+func simHash(tokens []string) uint64 {
+	var weights [64]int
+	add := func(s string) {
+		h := xxh3.HashString(s)
+		for i := range weights {
+			if h&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	for i, t := range tokens {
+		add(t)
+		if i > 0 {
+			add(tokens[i-1] + " " + t)
+		}
+	}
+	var fp uint64
+	for i, w := range weights {
+		if w > 0 {
+			fp |= 1 << i
+		}
+	}
+	return fp
+}
@@ -0,0 +1,235 @@
+package rss2masto
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		link, want string
+	}{
+		{"https://www.Example.com/news/story/?utm_source=rss&utm_medium=feed", "example.com/news/story"},
+		{"http://example.com:80/news/story#comments", "example.com/news/story"},
+		{"https://example.com/a?id=2&fbclid=x&page=1", "example.com/a?id=2&page=1"},
+		{"https://example.com/a?page=1&id=2", "example.com/a?id=2&page=1"},
+		{"https://example.com:8443/a?session=abc", "example.com:8443/a"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := canonicalURL(tt.link, []string{"session"}); got != tt.want {
+			t.Errorf("canonicalURL(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	a := simHash(titleTokens("Earthquake of magnitude 6.1 hits the coast of Chile"))
+	b := simHash(titleTokens("Earthquake of magnitude 6.1 hits the coast of Chile, officials say"))
+	c := simHash(titleTokens("Parliament passes the budget for next year after a long debate"))
+	if d := bits.OnesCount64(a ^ b); d > 16 {
+		t.Errorf("distance of similar titles = %d", d)
+	}
+	if bits.OnesCount64(a^c) <= bits.OnesCount64(a^b) {
+		t.Error("different titles are closer than similar ones")
+	}
+	if simHash(titleTokens("Wire: Storm closes ports")) != simHash(titleTokens("wire storm closes ports!")) {
+		t.Error("fingerprint depends on case or punctuation")
+	}
+}
+
+func TestDedupIndex(t *testing.T) {
+	var d dedupIndex
+	group := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	cfg := DedupConfig{}
+
+	first := &dedupEntry{GUID: "1", Link: "example.com/a", Title: "storm closes ports", Feed: "Wire", Time: time.Now().Unix()}
+	if e, dup := d.claim(group, cfg, first); dup || e != first {
+		t.Fatal("first item not claimed")
+	}
+
+	same := &dedupEntry{GUID: "2", Link: "other.example/b", Title: "storm closes ports", Feed: "Local", Time: time.Now().Unix()}
+	if e, dup := d.claim(group, cfg, same); !dup || e != first {
+		t.Error("item with the same title not detected")
+	}
+
+	link := &dedupEntry{GUID: "3", Link: "example.com/a", Title: "ports reopen", Feed: "Local", Time: time.Now().Unix()}
+	if e, dup := d.claim(group, cfg, link); !dup || e != first {
+		t.Error("item with the same link not detected")
+	}
+
+	// items of the same feed are told apart by their GUID
+	update := &dedupEntry{GUID: "4", Link: "example.com/a", Title: "storm closes ports", Feed: "Wire", Time: time.Now().Unix()}
+	if e, dup := d.claim(group, cfg, update); dup || e != update {
+		t.Error("item of the same feed suppressed")
+	}
+	d.release(group, update)
+
+	retry := &dedupEntry{GUID: "1", Link: "example.com/a", Feed: "Wire", Time: time.Now().Unix()}
+	if e, dup := d.claim(group, cfg, retry); dup || e != first {
+		t.Error("retry of the same item reported as duplicate")
+	}
+
+	// released items don't suppress anything
+	d.release(group, first)
+	if e, dup := d.claim(group, cfg, same); dup || e != same {
+		t.Error("released item still suppresses duplicates")
+	}
+	d.publish(group, same, "https://mastodon.example/@local/1")
+	d.release(group, same)
+	if _, dup := d.claim(group, cfg, first); !dup {
+		t.Error("published item released")
+	}
+
+	// items outside the window are forgotten
+	same.Time = time.Now().Add(-25 * time.Hour).Unix()
+	if _, dup := d.claim(group, cfg, &dedupEntry{GUID: "3", Title: "storm closes ports", Feed: "Other", Time: time.Now().Unix()}); dup {
+		t.Error("item outside the window detected as duplicate")
+	}
+}
+
+func TestCanonicalLink(t *testing.T) {
+	p := NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			switch string(req.URI().Path()) {
+			case "/r":
+				resp.SetStatusCode(fasthttp.StatusFound)
+				resp.Header.Set("Location", "/story?utm_source=x")
+			case "/story":
+				resp.Header.SetContentType("text/html")
+				resp.SetBodyString(`<html><head><link rel="canonical" href="https://news.example/2024/story"></head></html>`)
+			default:
+				resp.SetStatusCode(fasthttp.StatusNotFound)
+			}
+			return nil
+		},
+	})
+	if got := p.canonicalLink("https://t.example/r"); got != "https://news.example/2024/story" {
+		t.Errorf("canonicalLink() = %q", got)
+	}
+	if got := p.canonicalLink("https://t.example/missing"); got != "https://t.example/missing" {
+		t.Errorf("canonicalLink() of a missing page = %q", got)
+	}
+}
+
+func TestProcessFeed_Dedup(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []string
+	fm := &FeedsMonitor{Parser: NewParser(nil)}
+	fm.Instance.URL = "https://mastodon.example"
+	fm.hostClient = &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			statuses = append(statuses, string(req.Header.Peek("Authorization")))
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.SetBodyString(fmt.Sprintf(`{"id":"%d","url":"https://mastodon.example/@wire/%d"}`, len(statuses), len(statuses)))
+			return nil
+		},
+	}
+
+	group := fmt.Sprintf("wire-%d", time.Now().UnixNano())
+	wire := NewTestFeed("Wire", "https://wire.example/rss")
+	local := NewTestFeed("Local", "https://local.example/rss")
+	other := NewTestFeed("Other", "https://other.example/rss")
+	wire.Token, local.Token, other.Token = "wire-token", "local-token", "other-token"
+	wire.Dedup, local.Dedup = group, group
+	for _, f := range []*Feed{wire, local, other} {
+		fm.setFeedDefaults(f)
+	}
+
+	suffix := time.Now().UnixNano()
+	now := time.Now()
+	item := func(link, title string) *gofeed.Feed {
+		return &gofeed.Feed{Items: []*gofeed.Item{{
+			Title:           title,
+			Description:     "Body",
+			Link:            link,
+			GUID:            fmt.Sprintf("%s-%d", link, suffix),
+			PublishedParsed: &now,
+		}}}
+	}
+
+	debugMode = false
+	defer func() { debugMode = true }()
+	fm.processFeed(wire, item("https://wire.example/storm?utm_source=rss", "Storm closes all ports on the coast"))
+	fm.processFeed(local, item("https://local.example/news/1", "Storm closes all ports on the coast"))
+	fm.processFeed(local, item("https://wire.example/storm", "Local copy of the wire story"))
+	fm.processFeed(other, item("https://other.example/storm", "Storm closes all ports on the coast"))
+
+	if got := strings.Join(statuses, ","); got != "Bearer wire-token,Bearer other-token" {
+		t.Errorf("posted by %s, want the wire and the feed outside the group", got)
+	}
+	if local.Count != 0 {
+		t.Errorf("local feed posted %d duplicates", local.Count)
+	}
+}
+
+func TestProcessFeed_Canonical(t *testing.T) {
+	group := fmt.Sprintf("canonical-%d", time.Now().UnixNano())
+	wire := NewTestFeed("Wire", "https://wire.example/rss")
+	local := NewTestFeed("Local", "https://local.example/rss")
+	wire.Token, local.Token = "wire-token", "local-token"
+	wire.Dedup, local.Dedup = group, group
+
+	var mu sync.Mutex
+	var statuses []string
+	fm := &FeedsMonitor{Dedup: DedupConfig{Canonical: true}}
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			// item pages are fetched before the feed is locked
+			for _, f := range []*Feed{wire, local} {
+				if !f.mu.TryLock() {
+					t.Errorf("%s fetched while %s is locked", req.URI(), f.Name)
+					continue
+				}
+				f.mu.Unlock()
+			}
+			resp.Header.SetContentType("text/html")
+			resp.SetBodyString(`<html><head><link rel="canonical" href="https://wire.example/storm"></head></html>`)
+			return nil
+		},
+	})
+	fm.Instance.URL = "https://mastodon.example"
+	fm.hostClient = &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			statuses = append(statuses, string(req.Header.Peek("Authorization")))
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.SetBodyString(fmt.Sprintf(`{"id":"%d"}`, len(statuses)))
+			return nil
+		},
+	}
+	for _, f := range []*Feed{wire, local} {
+		fm.setFeedDefaults(f)
+	}
+
+	now := time.Now()
+	suffix := now.UnixNano()
+	item := func(link, title string) *gofeed.Feed {
+		return &gofeed.Feed{Items: []*gofeed.Item{{
+			Title:           title,
+			Description:     "Body",
+			Link:            link,
+			GUID:            fmt.Sprintf("%s-%d", link, suffix),
+			PublishedParsed: &now,
+		}}}
+	}
+
+	debugMode = false
+	defer func() { debugMode = true }()
+	fm.processFeed(wire, item("https://wire.example/storm", "Storm closes all ports on the coast"))
+	fm.processFeed(local, item("https://t.example/r/1", "Ports closed, local reactions"))
+
+	if got := strings.Join(statuses, ","); got != "Bearer wire-token" {
+		t.Errorf("posted by %s, want the wire only", got)
+	}
+}
@@ -0,0 +1,177 @@
+package rss2masto
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/valyala/fasthttp"
+)
+
+// feedLinkTypes lists the <link rel="alternate"> types recognised during autodiscovery,
+// in order of preference.
+var feedLinkTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+	"application/json",
+}
+
+// DiscoverFeed returns the feed URLs available at pageURL, best candidate first.
+// If pageURL already points to a feed, it is returned as the only candidate.
+// Otherwise the page is treated as HTML and searched for alternate feed links.
+func (p *Parser) DiscoverFeed(pageURL string) ([]string, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.SetRequestURI(pageURL)
+
+	if err := p.Client.Do(req, resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, fmt.Errorf("received non-OK HTTP status: %d", resp.StatusCode())
+	}
+	if !isHTML(resp.Header.ContentType(), resp.Body()) {
+		return []string{pageURL}, nil
+	}
+	links := discoverFeedLinks(resp.Body(), pageURL)
+	if len(links) == 0 {
+		return nil, fmt.Errorf("no feed link found at %s", pageURL)
+	}
+	return links, nil
+}
+
+// discover replaces an HTML page in resp with the best feed advertised by that page.
+// Candidates are tried in order until one returns a feed; it returns an error if none does.
+// If UpdateURL is set on the feed, the discovered URL replaces pageURL, the URL at idx in f.URLs.
+func (p *Parser) discover(f *Feed, pageURL string, idx int, req *fasthttp.Request, resp *fasthttp.Response) error {
+	links := discoverFeedLinks(resp.Body(), pageURL)
+	if len(links) == 0 {
+		return fmt.Errorf("no feed link found at %s", pageURL)
+	}
+
+	// validators of the page are meaningless for the feed
+	req.Header.Del("If-None-Match")
+
+	for _, link := range links {
+		req.SetRequestURI(link)
+		resp.Reset()
+		if err := p.Client.Do(req, resp); err != nil {
+			p.log().Warn("Error fetching discovered feed", errAttrs(err, "feed", f.label(), "url", link)...)
+			continue
+		}
+		if resp.StatusCode() != fasthttp.StatusOK || isHTML(resp.Header.ContentType(), resp.Body()) {
+			continue
+		}
+		if f.UpdateURL {
+			f.cfgMu.Lock()
+			// the list may have been replaced by Reload during the fetch
+			if idx < len(f.URLs) && f.URLs[idx] == pageURL {
+				urls := slices.Clone(f.URLs)
+				urls[idx] = link
+				f.URLs = urls
+			}
+			f.cfgMu.Unlock()
+			p.log().Info("Feed URL updated", "feed", f.label(), "url", link)
+		}
+		return nil
+	}
+	return fmt.Errorf("no usable feed discovered at %s", pageURL)
+}
+
+// isHTML reports whether a response body is an HTML page rather than a feed.
+// The body is sniffed first, since many servers send feeds as text/html.
+func isHTML(contentType, body []byte) bool {
+	head := bytes.TrimLeft(body, "\ufeff \t\r\n")
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.ToLower(head)
+
+	for _, prefix := range []string{"<rss", "<feed", "<rdf", "{"} {
+		if bytes.HasPrefix(head, []byte(prefix)) {
+			return false
+		}
+	}
+	if bytes.Contains(head, []byte("<!doctype html")) || bytes.Contains(head, []byte("<html")) {
+		return true
+	}
+	if bytes.HasPrefix(head, []byte("<?xml")) {
+		return false
+	}
+	return bytes.Contains(bytes.ToLower(contentType), []byte("text/html"))
+}
+
+// discoverFeedLinks returns the feed URLs advertised by an HTML page with
+// <link rel="alternate" type="...">. Relative hrefs are resolved against the
+// page's <base href> or pageURL. Results are ordered best first: preferred types
+// go first and comment feeds go last; ties keep document order.
+func discoverFeedLinks(body []byte, pageURL string) []string {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = u
+		}
+	}
+
+	type candidate struct {
+		url   string
+		score int
+	}
+	var candidates []candidate
+
+	doc.Find("link[rel][href]").Each(func(_ int, s *goquery.Selection) {
+		rel, _ := s.Attr("rel")
+		if !slices.Contains(strings.Fields(strings.ToLower(rel)), "alternate") {
+			return
+		}
+		typ, _ := s.Attr("type")
+		typ, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(typ)), ";")
+		score := slices.Index(feedLinkTypes, strings.TrimSpace(typ))
+		if score < 0 {
+			return
+		}
+
+		href, _ := s.Attr("href")
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		link := u.String()
+		if slices.ContainsFunc(candidates, func(c candidate) bool { return c.url == link }) {
+			return
+		}
+
+		title, _ := s.Attr("title")
+		if strings.Contains(strings.ToLower(title+" "+link), "comment") {
+			score += len(feedLinkTypes)
+		}
+		candidates = append(candidates, candidate{url: link, score: score})
+	})
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score < candidates[j].score
+	})
+
+	links := make([]string, len(candidates))
+	for i, c := range candidates {
+		links[i] = c.url
+	}
+	return links
+}
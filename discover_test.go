@@ -0,0 +1,186 @@
+package rss2masto
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+const discoverPage = `<!DOCTYPE html>
+<html><head>
+<title>Example News</title>
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/rss+xml" title="Comments" href="/comments/feed/">
+<link rel="alternate" type="application/atom+xml" title="Atom" href="/atom.xml">
+<link rel="alternate" type="application/rss+xml" title="RSS" href="feed.xml">
+<link rel="alternate" type="application/feed+json" href="https://cdn.example.com/feed.json">
+<link rel="alternate" hreflang="de" href="/de/">
+</head><body></body></html>`
+
+func TestIsHTML(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"html page", "text/html; charset=utf-8", "<!DOCTYPE html><html></html>", true},
+		{"html without content type", "", "\n  <html lang=\"en\"><head>", true},
+		{"rss served as text/html", "text/html", `<?xml version="1.0"?><rss version="2.0">`, false},
+		{"rss without declaration", "text/html", `<rss version="2.0">`, false},
+		{"atom feed", "application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom">`, false},
+		{"json feed", "application/json", `{"version":"https://jsonfeed.org/version/1.1"}`, false},
+		{"xhtml page", "application/xhtml+xml", `<?xml version="1.0"?><!DOCTYPE html><html>`, true},
+		{"plain text", "text/plain", "not valid xml at all", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isHTML([]byte(tt.contentType), []byte(tt.body)); got != tt.want {
+				t.Errorf("isHTML() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverFeedLinks(t *testing.T) {
+	t.Run("resolves and ranks candidates", func(t *testing.T) {
+		got := discoverFeedLinks([]byte(discoverPage), "https://example.com/news/")
+		want := []string{
+			"https://example.com/news/feed.xml",
+			"https://example.com/atom.xml",
+			"https://cdn.example.com/feed.json",
+			"https://example.com/comments/feed/",
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("discoverFeedLinks() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("honours base href", func(t *testing.T) {
+		page := `<html><head><base href="https://static.example.org/blog/">
+<link rel="alternate" type="application/rss+xml" href="rss">
+</head></html>`
+		got := discoverFeedLinks([]byte(page), "https://example.org/")
+		if len(got) != 1 || got[0] != "https://static.example.org/blog/rss" {
+			t.Errorf("discoverFeedLinks() = %v", got)
+		}
+	})
+
+	t.Run("no feed links", func(t *testing.T) {
+		page := `<html><head><link rel="icon" href="/favicon.ico"></head></html>`
+		if got := discoverFeedLinks([]byte(page), "https://example.org/"); len(got) != 0 {
+			t.Errorf("discoverFeedLinks() = %v, want none", got)
+		}
+	})
+}
+
+func TestFetchAndParse_Autodiscovery(t *testing.T) {
+	const validRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><title>Discovered</title>
+<item><title>Item 1</title><link>https://example.com/1</link><guid>guid1</guid></item>
+</channel></rss>`
+
+	newParser := func(calls *[]string) *Parser {
+		return &Parser{
+			Client: &mockHostClient{
+				handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+					url := string(req.RequestURI())
+					*calls = append(*calls, url)
+					resp.SetStatusCode(fasthttp.StatusOK)
+					switch url {
+					case "https://example.com/news/":
+						resp.Header.SetContentType("text/html")
+						resp.SetBodyString(discoverPage)
+					case "https://example.com/news/feed.xml":
+						resp.Header.SetContentType("application/rss+xml")
+						resp.Header.Set("ETag", `"feed"`)
+						resp.SetBodyString(validRSS)
+					default:
+						resp.SetStatusCode(fasthttp.StatusNotFound)
+					}
+					return nil
+				},
+			},
+			parserPool: sync.Pool{New: func() any { return gofeed.NewParser() }},
+		}
+	}
+
+	t.Run("fetches advertised feed", func(t *testing.T) {
+		var calls []string
+		feed := NewTestFeed("te", "https://example.com/news/")
+
+		result := newParser(&calls).FetchAndParse(feed)
+
+		if result == nil || result.Title != "Discovered" {
+			t.Fatalf("expected discovered feed, got %v", result)
+		}
+		if len(calls) != 2 {
+			t.Errorf("expected 2 HTTP calls, got %v", calls)
+		}
+		if feed.URL() != "https://example.com/news/" {
+			t.Errorf("URL changed without update_url: %q", feed.URL())
+		}
+		if string(feed.ETag()) != `"feed"` {
+			t.Errorf("ETag = %q, want the feed's etag", feed.ETag())
+		}
+	})
+
+	t.Run("update_url writes discovered URL back", func(t *testing.T) {
+		var calls []string
+		feed := NewTestFeed("te", "https://example.com/news/")
+		feed.UpdateURL = true
+
+		if result := newParser(&calls).FetchAndParse(feed); result == nil {
+			t.Fatal("expected discovered feed, got nil")
+		}
+		if feed.URL() != "https://example.com/news/feed.xml" {
+			t.Errorf("URL = %q, want discovered feed URL", feed.URL())
+		}
+	})
+
+	t.Run("page without a usable feed is a failed fetch", func(t *testing.T) {
+		var calls []string
+		feed := NewTestFeed("te", "https://example.com/other/")
+		parser := newParser(&calls)
+		parser.Client.(*mockHostClient).handler = func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.Header.SetContentType("text/html")
+			resp.SetBodyString("<!doctype html><html><head><title>No feed</title></head></html>")
+			return nil
+		}
+
+		if result := parser.FetchAndParse(feed); result != nil {
+			t.Fatalf("expected nil, got %v", result)
+		}
+		if msg, _ := feed.LastError(); feed.Failures() != 1 || !strings.Contains(msg, "no feed link found") {
+			t.Errorf("failures %d, last error %q: want the failure recorded", feed.Failures(), msg)
+		}
+	})
+
+	t.Run("DiscoverFeed lists candidates", func(t *testing.T) {
+		var calls []string
+		links, err := newParser(&calls).DiscoverFeed("https://example.com/news/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(links) != 4 || links[0] != "https://example.com/news/feed.xml" {
+			t.Errorf("DiscoverFeed() = %v", links)
+		}
+	})
+
+	t.Run("DiscoverFeed returns a feed URL unchanged", func(t *testing.T) {
+		var calls []string
+		links, err := newParser(&calls).DiscoverFeed("https://example.com/news/feed.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(links) != 1 || links[0] != "https://example.com/news/feed.xml" {
+			t.Errorf("DiscoverFeed() = %v", links)
+		}
+	})
+}
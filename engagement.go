@@ -0,0 +1,320 @@
+package rss2masto
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/valyala/fasthttp"
+)
+
+const engagementKeyPrefix = "rss2masto:engagement:"
+
+// engagementCadence is the polling interval of a status by its age:
+// statuses are polled often while they spread, then less and less, and no
+// more once older than the last age
+var engagementCadence = []struct {
+	age, every time.Duration
+}{
+	{time.Hour, 10 * time.Minute},
+	{6 * time.Hour, 30 * time.Minute},
+	{24 * time.Hour, 2 * time.Hour},
+	{3 * 24 * time.Hour, 6 * time.Hour},
+	{7 * 24 * time.Hour, 24 * time.Hour},
+}
+
+// pollInterval returns the polling interval of a status of the given age, false if it isn't polled anymore
+func pollInterval(age time.Duration) (time.Duration, bool) {
+	for _, c := range engagementCadence {
+		if age < c.age {
+			return c.every, true
+		}
+	}
+	return 0, false
+}
+
+// EngagementSample is the engagement of a status at a point in time
+type EngagementSample struct {
+	Publisher  string    `json:"publisher,omitempty"` // account of the status, the feed account if empty
+	StatusID   string    `json:"status_id"`
+	Time       time.Time `json:"time"`
+	Favourites int64     `json:"favourites"`
+	Reblogs    int64     `json:"reblogs"`
+	Replies    int64     `json:"replies"`
+}
+
+// PostEngagement is the last engagement sample of a post of the history
+type PostEngagement struct {
+	HistoryEntry
+	Favourites int64     `json:"favourites"`
+	Reblogs    int64     `json:"reblogs"`
+	Replies    int64     `json:"replies"`
+	Sampled    time.Time `json:"sampled"` // time of the sample
+}
+
+// Total returns the sum of favourites, reblogs and replies of the post
+func (p *PostEngagement) Total() int64 {
+	return p.Favourites + p.Reblogs + p.Replies
+}
+
+// EngagementReport aggregates the engagement of the posts of a feed
+type EngagementReport struct {
+	Feed       string           `json:"feed"`
+	Since      time.Time        `json:"since"`
+	Posts      int              `json:"posts"` // posts with an engagement sample
+	Favourites int64            `json:"favourites"`
+	Reblogs    int64            `json:"reblogs"`
+	Replies    int64            `json:"replies"`
+	Median     float64          `json:"median"` // median of the favourites, reblogs and replies per post
+	Top        []PostEngagement `json:"top"`    // most engaging posts, highest total first
+}
+
+// engagementPolls holds the last poll of each tracked status
+type engagementPolls struct {
+	mu   sync.Mutex
+	last map[string]time.Time // publisher and status ID -> last poll
+}
+
+// due reports whether a status posted at the given time should be polled now, and records the poll
+func (p *engagementPolls) due(id string, posted, now time.Time) bool {
+	every, ok := pollInterval(now.Sub(posted))
+	if !ok {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.last[id]) < every {
+		return false
+	}
+	if p.last == nil {
+		p.last = make(map[string]time.Time)
+	}
+	p.last[id] = now
+	return true
+}
+
+// prune forgets the statuses polled before limit
+func (p *engagementPolls) prune(limit time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, t := range p.last {
+		if t.Before(limit) {
+			delete(p.last, id)
+		}
+	}
+}
+
+// engagementKey returns the key of the sorted set holding the engagement samples of
+// the statuses of a feed, scored by Unix time in milliseconds
+func engagementKey(f *Feed) string {
+	return engagementKeyPrefix + f.namespace()
+}
+
+// UpdateEngagement polls the favourites, reblogs and replies of the recent statuses of
+// the feed accounts and their targets, found in the post history. Statuses are polled every 10 minutes in
+// their first hour, then less and less often, up to 7 days. Call it every few minutes.
+func (fm *FeedsMonitor) UpdateEngagement() {
+	fm.configMu.RLock()
+	history := fm.PostHistory
+	fm.configMu.RUnlock()
+	now := time.Now()
+	window := engagementCadence[len(engagementCadence)-1].age
+	fm.polls.prune(now.Add(-window))
+
+	var wg sync.WaitGroup
+	for _, feed := range fm.feeds() {
+		if feed.Paused() {
+			continue
+		}
+		wg.Go(func() {
+			if err := fm.pollEngagement(feed, history, now.Add(-window), now); err != nil && !errors.Is(err, errOffline) {
+				fm.feedLog(feed).Warn("Error getting engagement", errAttrs(err)...)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// engagementAccount is a Mastodon account whose statuses are polled
+type engagementAccount struct {
+	mi    *MastodonInstance
+	token string
+}
+
+// engagementAccounts returns the Mastodon accounts of a feed by publisher name:
+// the feed account and the accounts of its targets
+func (fm *FeedsMonitor) engagementAccounts(f *Feed) (map[string]engagementAccount, error) {
+	fm.configMu.RLock()
+	defer fm.configMu.RUnlock()
+	accounts := make(map[string]engagementAccount, 1+len(f.Targets))
+	if token := f.accessToken(); token != "" {
+		mi := fm.feedInstance(f)
+		if mi == nil {
+			return nil, errors.New("unknown instance " + strconv.Quote(f.instanceName()))
+		}
+		accounts[mastodonPublisherName] = engagementAccount{mi, token}
+	}
+	for _, t := range f.Targets {
+		if mi := fm.instances[fm.targetInstanceName(f, t)]; mi != nil && t.accessToken() != "" {
+			accounts[targetPublisherPrefix+t.Name] = engagementAccount{mi, t.accessToken()}
+		}
+	}
+	return accounts, nil
+}
+
+// engagementID identifies a status across the feed accounts
+func engagementID(publisher, statusID string) string {
+	if publisher == "" {
+		publisher = mastodonPublisherName
+	}
+	return publisher + "\x00" + statusID
+}
+
+// pollEngagement polls the statuses of the accounts of a feed posted since the given time that are due
+func (fm *FeedsMonitor) pollEngagement(f *Feed, cfg HistoryConfig, since, now time.Time) error {
+	accounts, err := fm.engagementAccounts(f)
+	if err != nil || len(accounts) == 0 {
+		return err
+	}
+	entries, err := fm.History(f, since, 0)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range entries {
+		a, ok := accounts[e.Publisher]
+		if !ok || e.StatusID == "" || !fm.polls.due(engagementID(e.Publisher, e.StatusID), e.Time, now) {
+			continue
+		}
+		b, err := sendJSON(a.mi.client, fasthttp.MethodGet, a.mi.URL+"/api/v1/statuses/"+e.StatusID, nil, "Authorization", "Bearer "+a.token)
+		var httpErr *httpError
+		if errors.As(err, &httpErr) && httpErr.Status == fasthttp.StatusNotFound {
+			// the status was deleted
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s := &EngagementSample{
+			StatusID:   e.StatusID,
+			Time:       now,
+			Favourites: jsoniter.Get(b, "favourites_count").ToInt64(),
+			Reblogs:    jsoniter.Get(b, "reblogs_count").ToInt64(),
+			Replies:    jsoniter.Get(b, "replies_count").ToInt64(),
+		}
+		if e.Publisher != mastodonPublisherName {
+			s.Publisher = e.Publisher
+		}
+		if err := recordEngagement(cfg, f, s); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recordEngagement adds a sample to the engagement of a feed, kept as long as the post history
+func recordEngagement(cfg HistoryConfig, f *Feed, s *EngagementSample) error {
+	maxAge := cfg.maxAge()
+	if maxAge < 0 {
+		return nil
+	}
+	data, err := jsoniter.Marshal(s)
+	if err != nil {
+		return err
+	}
+	key := engagementKey(f)
+	if err := Cache.ZAdd(key, []redis.Z{{Score: float64(s.Time.UnixMilli()), Member: data}}); err != nil {
+		return err
+	}
+	if err := Cache.ZRemRangeByScore(key, "-inf", "("+strconv.FormatInt(s.Time.Add(-maxAge).UnixMilli(), 10)); err != nil {
+		return err
+	}
+	return Cache.Expire(key, maxAge)
+}
+
+// EngagementSamples returns the engagement samples of the statuses of a feed taken since
+// the given time, newest first, all of them if since is zero
+func (fm *FeedsMonitor) EngagementSamples(f *Feed, since time.Time) ([]EngagementSample, error) {
+	from := "-inf"
+	if !since.IsZero() {
+		from = strconv.FormatInt(since.UnixMilli(), 10)
+	}
+	members, err := Cache.ZRevRangeByScore(engagementKey(f), from, "+inf", 0)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]EngagementSample, 0, len(members))
+	var errs []error
+	for _, m := range members {
+		var s EngagementSample
+		if err := jsoniter.UnmarshalFromString(m, &s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, errors.Join(errs...)
+}
+
+// Engagement returns the engagement of the posts of a feed and its targets posted since the given
+// time: totals and median over the posts, and the top posts, at most top if positive
+func (fm *FeedsMonitor) Engagement(f *Feed, since time.Time, top int) (*EngagementReport, error) {
+	entries, err := fm.History(f, since, 0)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := fm.EngagementSamples(f, since)
+	if err != nil {
+		return nil, err
+	}
+	// samples are newest first, keep the last one of each status
+	last := make(map[string]*EngagementSample, len(samples))
+	for i := range samples {
+		id := engagementID(samples[i].Publisher, samples[i].StatusID)
+		if _, ok := last[id]; !ok {
+			last[id] = &samples[i]
+		}
+	}
+
+	r := &EngagementReport{Feed: f.label(), Since: since}
+	var posts []PostEngagement
+	for _, e := range entries {
+		s := last[engagementID(e.Publisher, e.StatusID)]
+		if s == nil {
+			continue
+		}
+		posts = append(posts, PostEngagement{
+			HistoryEntry: e,
+			Favourites:   s.Favourites,
+			Reblogs:      s.Reblogs,
+			Replies:      s.Replies,
+			Sampled:      s.Time,
+		})
+		r.Favourites += s.Favourites
+		r.Reblogs += s.Reblogs
+		r.Replies += s.Replies
+	}
+	r.Posts = len(posts)
+	if len(posts) == 0 {
+		return r, nil
+	}
+	slices.SortStableFunc(posts, func(a, b PostEngagement) int {
+		return cmp.Compare(b.Total(), a.Total())
+	})
+	if n := len(posts); n%2 == 1 {
+		r.Median = float64(posts[n/2].Total())
+	} else {
+		r.Median = float64(posts[n/2-1].Total()+posts[n/2].Total()) / 2
+	}
+	if top > 0 && len(posts) > top {
+		posts = posts[:top]
+	}
+	r.Top = posts
+	return r, nil
+}
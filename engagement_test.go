@@ -0,0 +1,116 @@
+package rss2masto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/valyala/fasthttp"
+)
+
+func TestEngagementPolls(t *testing.T) {
+	var p engagementPolls
+	now := time.Now()
+	posted := now.Add(-30 * time.Minute)
+	if !p.due("1", posted, now) {
+		t.Error("new status not due")
+	}
+	if p.due("1", posted, now.Add(5*time.Minute)) {
+		t.Error("status polled again after 5 minutes in its first hour")
+	}
+	if !p.due("1", posted, now.Add(10*time.Minute)) {
+		t.Error("status not polled after 10 minutes in its first hour")
+	}
+	old := now.Add(-2 * 24 * time.Hour)
+	if !p.due("2", old, now) || p.due("2", old, now.Add(time.Hour)) || !p.due("2", old, now.Add(6*time.Hour)) {
+		t.Error("2 days old status not polled every 6 hours")
+	}
+	if p.due("3", now.Add(-8*24*time.Hour), now) {
+		t.Error("status older than 7 days polled")
+	}
+	p.prune(now.Add(time.Minute))
+	if len(p.last) != 2 {
+		t.Errorf("%d statuses after pruning", len(p.last))
+	}
+}
+
+func TestAdminEngagement(t *testing.T) {
+	fm := newAdminMonitor(t)
+	// the cache is offline in tests
+	if resp := adminRequest(fm, fasthttp.MethodGet, "/api/feeds/News/engagement?since=24h", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Errorf("engagement without Redis: %d %s", resp.StatusCode(), resp.Body())
+	}
+}
+
+func TestEngagement_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer client.FlushDB(context.Background())
+	saved := Cache
+	Cache = &CacheClient{client: client, ctx: context.Background(), cache: rediscache.New(&rediscache.Options{Redis: client})}
+	defer func() { Cache = saved }()
+
+	fm := loadInstancesConfig(t, `instance:
+  url: https://national.example
+  feed:
+    - name: News
+      token: t
+      targets:
+        - name: mirror
+          token: m
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]string{
+		"/api/v1/statuses/1": `{"favourites_count":10,"reblogs_count":2,"replies_count":1}`,
+		"/api/v1/statuses/2": `{"favourites_count":1,"reblogs_count":0,"replies_count":0}`,
+		"/api/v1/statuses/3": `{"favourites_count":4,"reblogs_count":1,"replies_count":0}`,
+	}
+	fm.instances[""].client = &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			if string(req.Header.Peek("Authorization")) == "Bearer m" {
+				// the same status ID on the target account
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{"favourites_count":3,"reblogs_count":0,"replies_count":0}`)
+			} else if body, ok := counts[string(req.URI().Path())]; ok {
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(body)
+			} else {
+				resp.SetStatusCode(fasthttp.StatusNotFound)
+			}
+			return nil
+		},
+	}
+	f := fm.Instance.Feeds[0]
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3", "deleted"} {
+		e := &HistoryEntry{Time: now.Add(-time.Duration(i+1) * time.Hour), Publisher: mastodonPublisherName, StatusID: id, Title: "Item " + id}
+		if err := recordHistory(HistoryConfig{}, f, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := &HistoryEntry{Time: now.Add(-time.Hour), Publisher: targetPublisherPrefix + "mirror", StatusID: "1", Title: "Item 1"}
+	if err := recordHistory(HistoryConfig{}, f, target); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.UpdateEngagement()
+	r, err := fm.Engagement(f, now.Add(-24*time.Hour), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Posts != 4 || r.Favourites != 18 || r.Reblogs != 3 || r.Replies != 1 || r.Median != 4 {
+		t.Errorf("Engagement() = %+v", r)
+	}
+	if len(r.Top) != 2 || r.Top[0].StatusID != "1" || r.Top[0].Publisher != mastodonPublisherName || r.Top[1].StatusID != "3" {
+		t.Errorf("top posts = %+v", r.Top)
+	}
+}
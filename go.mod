@@ -3,6 +3,8 @@ module github.com/glaydus/rss2masto
 go 1.26
 
 require (
+	github.com/PuerkitoBio/goquery v1.12.0
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/go-redis/cache/v9 v9.0.0
 	github.com/json-iterator/go v1.1.12
 	github.com/microcosm-cc/bluemonday v1.0.27
@@ -16,7 +18,6 @@ require (
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.12.0 // indirect
 	github.com/andybalholm/brotli v1.2.1 // indirect
 	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/aymerick/douceur v0.2.0 // indirect
@@ -0,0 +1,159 @@
+package rss2masto
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultStatsAge    = 365 * 24 * time.Hour
+	accountKeyPrefix   = "rss2masto:account:"
+	engagementWindow   = 7 * 24 * time.Hour // age of the posts counted in the engagement totals
+	engagementStatuses = 40                 // statuses requested for the engagement totals, the API maximum
+)
+
+// statsAge returns the age after which account samples are removed, negative if they aren't recorded
+func (c HistoryConfig) statsAge() time.Duration {
+	return cmp.Or(c.StatsAge, defaultStatsAge)
+}
+
+// AccountSample is a sample of the counters of a feed account, recorded by UpdateFollowers
+type AccountSample struct {
+	Time       time.Time `json:"time"`
+	Followers  int64     `json:"followers"`
+	Statuses   int64     `json:"statuses"`
+	Favourites int64     `json:"favourites"` // favourites of the posts of the last 7 days
+	Reblogs    int64     `json:"reblogs"`    // reblogs of the posts of the last 7 days
+	Replies    int64     `json:"replies"`    // replies to the posts of the last 7 days
+}
+
+// GrowthReport is the change of the counters of a feed account over a period
+type GrowthReport struct {
+	Feed            string    `json:"feed"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Samples         int       `json:"samples"` // samples recorded over the period, changes need 2
+	Followers       int64     `json:"followers"`
+	FollowersChange int64     `json:"followers_change"`
+	Statuses        int64     `json:"statuses"`
+	StatusesChange  int64     `json:"statuses_change"`
+	Posts           int       `json:"posts"`      // posts of the monitor to the feed account over the period, from the history
+	Favourites      int64     `json:"favourites"` // engagement of the posts of the last 7 days, at the last sample
+	Reblogs         int64     `json:"reblogs"`
+	Replies         int64     `json:"replies"`
+}
+
+// accountKey returns the key of the sorted set holding the account samples of a feed,
+// scored by Unix time in milliseconds
+func accountKey(f *Feed) string {
+	return accountKeyPrefix + f.namespace()
+}
+
+// getEngagement sets the engagement totals of the posts of the last 7 days of a feed account
+func (fm *FeedsMonitor) getEngagement(mi *MastodonInstance, feed *Feed, s *AccountSample) error {
+	b, err := mi.Get(fmt.Sprintf("/api/v1/accounts/%d/statuses?limit=%d&exclude_reblogs=true", feed.Id, engagementStatuses), feed.accessToken())
+	if err != nil {
+		return err
+	}
+	var statuses []struct {
+		CreatedAt  time.Time `json:"created_at"`
+		Favourites int64     `json:"favourites_count"`
+		Reblogs    int64     `json:"reblogs_count"`
+		Replies    int64     `json:"replies_count"`
+	}
+	if err := jsoniter.Unmarshal(b, &statuses); err != nil {
+		return err
+	}
+	limit := s.Time.Add(-engagementWindow)
+	for _, st := range statuses {
+		if st.CreatedAt.Before(limit) {
+			continue
+		}
+		s.Favourites += st.Favourites
+		s.Reblogs += st.Reblogs
+		s.Replies += st.Replies
+	}
+	return nil
+}
+
+// recordAccount adds a sample to the series of a feed account and removes the samples beyond the retention
+func recordAccount(cfg HistoryConfig, f *Feed, s *AccountSample) error {
+	maxAge := cfg.statsAge()
+	if maxAge < 0 {
+		return nil
+	}
+	data, err := jsoniter.Marshal(s)
+	if err != nil {
+		return err
+	}
+	key := accountKey(f)
+	if err := Cache.ZAdd(key, []redis.Z{{Score: float64(s.Time.UnixMilli()), Member: data}}); err != nil {
+		return err
+	}
+	if err := Cache.ZRemRangeByScore(key, "-inf", "("+strconv.FormatInt(s.Time.Add(-maxAge).UnixMilli(), 10)); err != nil {
+		return err
+	}
+	return Cache.Expire(key, maxAge)
+}
+
+// AccountSamples returns the samples of a feed account since the given time, oldest first,
+// all of them if since is zero. Samples are recorded in Redis by UpdateFollowers and kept
+// as set by PostHistory.StatsAge.
+func (fm *FeedsMonitor) AccountSamples(f *Feed, since time.Time) ([]AccountSample, error) {
+	from := "-inf"
+	if !since.IsZero() {
+		from = strconv.FormatInt(since.UnixMilli(), 10)
+	}
+	members, err := Cache.ZRevRangeByScore(accountKey(f), from, "+inf", 0)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]AccountSample, 0, len(members))
+	var errs []error
+	for i := len(members) - 1; i >= 0; i-- {
+		var s AccountSample
+		if err := jsoniter.UnmarshalFromString(members[i], &s); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, errors.Join(errs...)
+}
+
+// Growth returns the change of the counters of a feed account over the period ending now,
+// with the number of posts the monitor sent to the account and the latest engagement totals
+func (fm *FeedsMonitor) Growth(f *Feed, period time.Duration) (*GrowthReport, error) {
+	r := &GrowthReport{Feed: f.label(), To: time.Now()}
+	r.From = r.To.Add(-period)
+	samples, err := fm.AccountSamples(f, r.From)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := fm.History(f, r.From, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range posts {
+		if e.Publisher == mastodonPublisherName {
+			r.Posts++
+		}
+	}
+
+	r.Samples = len(samples)
+	if len(samples) == 0 {
+		r.Followers = f.Followers.Load()
+		return r, nil
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	r.Followers, r.FollowersChange = last.Followers, last.Followers-first.Followers
+	r.Statuses, r.StatusesChange = last.Statuses, last.Statuses-first.Statuses
+	r.Favourites, r.Reblogs, r.Replies = last.Favourites, last.Reblogs, last.Replies
+	return r, nil
+}
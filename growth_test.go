@@ -0,0 +1,124 @@
+package rss2masto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/valyala/fasthttp"
+)
+
+func TestGetFollowers(t *testing.T) {
+	fm := loadInstancesConfig(t, `instance:
+  url: https://national.example
+  feed:
+    - name: News
+      token: t
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	recent := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	old := time.Now().Add(-10 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	fm.instances[""].client = &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusOK)
+			switch path := string(req.URI().Path()); path {
+			case "/api/v1/accounts/7":
+				resp.SetBodyString(`{"id":"7","followers_count":120,"statuses_count":900}`)
+			case "/api/v1/accounts/7/statuses":
+				if string(req.Header.Peek("Authorization")) != "Bearer t" || string(req.URI().QueryArgs().Peek("exclude_reblogs")) != "true" {
+					t.Errorf("statuses request: %s", req.Header.String())
+				}
+				resp.SetBodyString(`[
+{"created_at":"` + recent + `","favourites_count":5,"reblogs_count":2,"replies_count":1},
+{"created_at":"` + recent + `","favourites_count":3,"reblogs_count":0,"replies_count":0},
+{"created_at":"` + old + `","favourites_count":50,"reblogs_count":20,"replies_count":10}]`)
+			default:
+				t.Errorf("unexpected request %s", path)
+			}
+			return nil
+		},
+	}
+	f := fm.Instance.Feeds[0]
+	f.Id = 7
+
+	s, err := fm.getFollowers(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Followers != 120 || s.Statuses != 900 || s.Favourites != 8 || s.Reblogs != 2 || s.Replies != 1 {
+		t.Errorf("getFollowers() = %+v", s)
+	}
+	if f.Followers.Load() != 120 {
+		t.Errorf("Followers = %d", f.Followers.Load())
+	}
+	// the cache is offline in tests
+	if _, err := fm.Growth(f, 24*time.Hour); !errors.Is(err, errOffline) {
+		t.Errorf("Growth() = %v", err)
+	}
+	if err := recordAccount(HistoryConfig{StatsAge: -1}, f, s); err != nil {
+		t.Errorf("disabled samples: %v", err)
+	}
+}
+
+func TestAdminGrowth(t *testing.T) {
+	fm := newAdminMonitor(t)
+	// the cache is offline in tests
+	if resp := adminRequest(fm, fasthttp.MethodGet, "/api/feeds/News/growth?period=24h", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Errorf("growth without Redis: %d %s", resp.StatusCode(), resp.Body())
+	}
+	if resp := adminRequest(fm, fasthttp.MethodPost, "/api/feeds/News/growth", "admin-secret", ""); resp.StatusCode() != fasthttp.StatusMethodNotAllowed {
+		t.Errorf("POST growth: %d", resp.StatusCode())
+	}
+}
+
+func TestGrowth_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer client.FlushDB(context.Background())
+	saved := Cache
+	Cache = &CacheClient{client: client, ctx: context.Background(), cache: rediscache.New(&rediscache.Options{Redis: client})}
+	defer func() { Cache = saved }()
+
+	fm := &FeedsMonitor{}
+	f := NewTestFeed("News", "https://example.com/news.xml")
+	now := time.Now()
+	for _, s := range []AccountSample{
+		{Time: now.Add(-30 * time.Hour), Followers: 90, Statuses: 10},
+		{Time: now.Add(-20 * time.Hour), Followers: 100, Statuses: 12},
+		{Time: now.Add(-time.Hour), Followers: 130, Statuses: 20, Favourites: 7},
+	} {
+		if err := recordAccount(HistoryConfig{}, f, &s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range []*HistoryEntry{
+		{Time: now.Add(-2 * time.Hour), Publisher: mastodonPublisherName},
+		{Time: now.Add(-2 * time.Hour), Publisher: "bluesky"},
+	} {
+		if err := recordHistory(HistoryConfig{}, f, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := fm.Growth(f, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Samples != 2 || r.Followers != 130 || r.FollowersChange != 30 || r.StatusesChange != 8 || r.Posts != 1 || r.Favourites != 7 {
+		t.Errorf("Growth() = %+v", r)
+	}
+	samples, err := fm.AccountSamples(f, time.Time{})
+	if err != nil || len(samples) != 3 || samples[0].Followers != 90 {
+		t.Errorf("AccountSamples() = %+v, %v", samples, err)
+	}
+}
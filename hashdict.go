@@ -81,7 +81,7 @@ func parseHashDictFile(data []byte) map[string]string {
 	} else {
 		f, err := os.Open(HashDictFile)
 		if err != nil {
-			fmt.Printf("hashDict: cannot open %s: %v\n", HashDictFile, err)
+			logger().Error("Cannot open hash dictionary", classAttrs(errClassConfig, err, "file", HashDictFile)...)
 			return nil
 		}
 		defer f.Close()
@@ -101,7 +101,7 @@ func parseHashDictFile(data []byte) map[string]string {
 		newDict[strings.TrimSpace(key)] = strings.TrimSpace(val)
 	}
 	if err := scanner.Err(); err != nil {
-		fmt.Printf("hashDict: error reading %s: %v\n", HashDictFile, err)
+		logger().Error("Error reading hash dictionary", classAttrs(errClassConfig, err, "file", HashDictFile)...)
 		return nil
 	}
 	return newDict
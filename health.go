@@ -0,0 +1,204 @@
+package rss2masto
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// healthStaleTicks is the number of ticks after which a run is stale, without Server.StaleAfter
+	healthStaleTicks = 3
+	// defaultStaleAfter is the staleness threshold before the tick is known
+	defaultStaleAfter = 15 * time.Minute
+	// readyTTL is how long readiness results are reused, so probes don't flood the instances
+	readyTTL = 10 * time.Second
+)
+
+// processStart is the time the process started, runs are expected from then on
+var processStart = time.Now()
+
+// Status of health checks
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// HealthCheck is the result of a single check of /healthz or /readyz
+type HealthCheck struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// HealthReport is the response of /healthz and /readyz
+type HealthReport struct {
+	Status string                  `json:"status"` // ok if all checks are ok
+	Checks map[string]*HealthCheck `json:"checks"`
+}
+
+// newHealthReport returns a report of the checks
+func newHealthReport(checks map[string]*HealthCheck) *HealthReport {
+	r := &HealthReport{Status: HealthOK, Checks: checks}
+	for _, c := range checks {
+		if c.Status != HealthOK {
+			r.Status = HealthFail
+		}
+	}
+	return r
+}
+
+// readyCache holds the last readiness report
+type readyCache struct {
+	mu     sync.Mutex
+	time   time.Time
+	report *HealthReport
+}
+
+// staleAfter returns the time after which a run is considered stale or stuck:
+// Server.StaleAfter, or 3 ticks measured between runs
+func (fm *FeedsMonitor) staleAfter() time.Duration {
+	if fm.Server.StaleAfter > 0 {
+		return fm.Server.StaleAfter
+	}
+	if tick := time.Duration(fm.tick.Load()); tick > 0 {
+		return healthStaleTicks * tick
+	}
+	return defaultStaleAfter
+}
+
+// Health reports whether the monitor is alive: Start is called regularly and
+// a run doesn't take longer than the staleness threshold
+func (fm *FeedsMonitor) Health() *HealthReport {
+	now := time.Now()
+	threshold := fm.staleAfter()
+	lastStart, lastFinish := fm.lastStart.Load(), fm.lastFinish.Load()
+	running := lastStart > lastFinish
+
+	run := &HealthCheck{Status: HealthOK, Details: map[string]any{
+		"running":           running,
+		"threshold_seconds": int64(threshold / time.Second),
+	}}
+	if lastStart > 0 {
+		run.Details["last_start"] = time.Unix(0, lastStart).Unix()
+	}
+	if lastFinish > 0 {
+		run.Details["last_finish"] = time.Unix(0, lastFinish).Unix()
+	}
+	switch {
+	case running && now.Sub(time.Unix(0, lastStart)) > threshold:
+		run.Status, run.Message = HealthFail, "run in progress for "+now.Sub(time.Unix(0, lastStart)).Round(time.Second).String()
+	case lastFinish > 0 && !running && now.Sub(time.Unix(0, lastFinish)) > threshold:
+		run.Status, run.Message = HealthFail, "no run finished for "+now.Sub(time.Unix(0, lastFinish)).Round(time.Second).String()
+	case lastFinish == 0 && !running && now.Sub(processStart) > threshold:
+		run.Status, run.Message = HealthFail, "no run since the start"
+	}
+	return newHealthReport(map[string]*HealthCheck{"run": run})
+}
+
+// Ready reports whether the monitor can post: the Mastodon instances are reachable,
+// the credentials of at least one feed were verified and the cache is online
+// (or Server.Fallback accepts the local cache). Results are reused for 10 seconds.
+func (fm *FeedsMonitor) Ready() *HealthReport {
+	fm.ready.mu.Lock()
+	defer fm.ready.mu.Unlock()
+	if fm.ready.report != nil && time.Since(fm.ready.time) < readyTTL {
+		return fm.ready.report
+	}
+
+	fm.configMu.RLock()
+	instances := maps.Clone(fm.instances)
+	fm.configMu.RUnlock()
+
+	checks := map[string]*HealthCheck{
+		"instances":   checkInstances(instances),
+		"credentials": fm.checkCredentials(),
+		"cache":       fm.checkCache(),
+	}
+	fm.ready.report, fm.ready.time = newHealthReport(checks), time.Now()
+	return fm.ready.report
+}
+
+// checkInstances checks that every instance answers /api/v1/instance
+func checkInstances(instances map[string]*MastodonInstance) *HealthCheck {
+	c := &HealthCheck{Status: HealthOK, Details: make(map[string]any, len(instances))}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, mi := range instances {
+		wg.Go(func() {
+			result := map[string]any{"url": mi.URL, "reachable": true}
+			if _, err := mi.Get("/api/v1/instance"); err != nil {
+				result["reachable"], result["error"] = false, err.Error()
+			}
+			mu.Lock()
+			c.Details[reportName(name)] = result
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	var down []string
+	for name, result := range c.Details {
+		if !result.(map[string]any)["reachable"].(bool) {
+			down = append(down, name)
+		}
+	}
+	if len(down) > 0 {
+		slices.Sort(down)
+		c.Status, c.Message = HealthFail, "unreachable: "+strings.Join(down, ", ")
+	}
+	return c
+}
+
+// reportName returns the name of an instance in reports, "instance" for the instance block
+func reportName(name string) string {
+	if name == "" {
+		return "instance"
+	}
+	return name
+}
+
+// checkCredentials checks that the token of at least one feed was verified
+func (fm *FeedsMonitor) checkCredentials() *HealthCheck {
+	var withToken, verified int
+	for _, f := range fm.feeds() {
+		if f.accessToken() == "" {
+			continue
+		}
+		withToken++
+		if f.verified.Load() {
+			verified++
+		}
+	}
+	c := &HealthCheck{Status: HealthOK, Details: map[string]any{"feeds": withToken, "verified": verified}}
+	if verified == 0 {
+		c.Status, c.Message = HealthFail, "no feed credentials verified"
+	}
+	return c
+}
+
+// checkCache checks the connection to Redis
+func (fm *FeedsMonitor) checkCache() *HealthCheck {
+	c := &HealthCheck{Status: HealthOK, Details: map[string]any{"online": true}}
+	if err := Cache.Ping(); err != nil {
+		c.Details["online"] = false
+		c.Message = err.Error()
+		if !fm.Server.Fallback {
+			c.Status = HealthFail
+		}
+	}
+	return c
+}
+
+// healthHandler serves /healthz and /readyz, with status 503 if a check fails
+func (fm *FeedsMonitor) healthHandler(ctx *fasthttp.RequestCtx, report *HealthReport) {
+	status := fasthttp.StatusOK
+	if report.Status != HealthOK {
+		status = fasthttp.StatusServiceUnavailable
+	}
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-store")
+	adminJSON(ctx, status, report)
+}
@@ -0,0 +1,134 @@
+package rss2masto
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+func TestHealth(t *testing.T) {
+	fm := &FeedsMonitor{}
+	now := time.Now()
+	tests := []struct {
+		name              string
+		start, finish     time.Time
+		staleAfter        time.Duration
+		tick              time.Duration
+		want, wantMessage string
+	}{
+		{"never run", time.Time{}, time.Time{}, 0, 0, HealthOK, ""},
+		{"recent run", now.Add(-time.Minute), now.Add(-50 * time.Second), 0, time.Minute, HealthOK, ""},
+		{"running", now.Add(-time.Minute), now.Add(-2 * time.Minute), 0, time.Minute, HealthOK, ""},
+		{"stuck", now.Add(-4 * time.Minute), now.Add(-5 * time.Minute), 0, time.Minute, HealthFail, "run in progress for 4m0s"},
+		{"stale", now.Add(-10 * time.Minute), now.Add(-9 * time.Minute), 0, time.Minute, HealthFail, "no run finished for 9m0s"},
+		{"configured threshold", now.Add(-10 * time.Minute), now.Add(-9 * time.Minute), time.Hour, time.Minute, HealthOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unixNano := func(t time.Time) int64 {
+				if t.IsZero() {
+					return 0
+				}
+				return t.UnixNano()
+			}
+			fm.lastStart.Store(unixNano(tt.start))
+			fm.lastFinish.Store(unixNano(tt.finish))
+			fm.tick.Store(int64(tt.tick))
+			fm.Server.StaleAfter = tt.staleAfter
+
+			r := fm.Health()
+			if r.Status != tt.want || r.Checks["run"].Message != tt.wantMessage {
+				t.Errorf("Health() = %s %+v", r.Status, r.Checks["run"])
+			}
+		})
+	}
+}
+
+func TestHealth_NoFeeds(t *testing.T) {
+	fm := &FeedsMonitor{}
+	fm.Server.StaleAfter = time.Minute
+	fm.Start()
+	if fm.lastStart.Load() == 0 || fm.lastFinish.Load() < fm.lastStart.Load() {
+		t.Fatalf("run without feeds not recorded: start %d, finish %d", fm.lastStart.Load(), fm.lastFinish.Load())
+	}
+	if r := fm.Health(); r.Status != HealthOK {
+		t.Errorf("Health() = %s %+v", r.Status, r.Checks["run"])
+	}
+}
+
+func TestReady(t *testing.T) {
+	fm := loadInstancesConfig(t, `instance:
+  url: https://national.example
+  feed:
+    - name: News
+      token: t
+    - name: Sport
+      token: t
+instances:
+  - name: regional
+    url: https://regional.example
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	for name, mi := range fm.instances {
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				if name == "regional" {
+					return errors.New("connection refused")
+				}
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{}`)
+				return nil
+			},
+		}
+	}
+
+	r := fm.Ready()
+	if r.Status != HealthFail {
+		t.Errorf("Ready() = %s", r.Status)
+	}
+	if c := r.Checks["instances"]; c.Status != HealthFail || c.Message != "unreachable: regional" ||
+		c.Details["instance"].(map[string]any)["reachable"] != true {
+		t.Errorf("instances check = %+v", c)
+	}
+	if c := r.Checks["credentials"]; c.Status != HealthFail || c.Details["feeds"] != 2 {
+		t.Errorf("credentials check = %+v", c)
+	}
+	// the cache is offline in tests
+	if c := r.Checks["cache"]; c.Status != HealthFail || c.Details["online"] != false {
+		t.Errorf("cache check = %+v", c)
+	}
+
+	// results are reused for a while
+	fm.Instance.Feeds[0].verified.Store(true)
+	fm.Server.Fallback = true
+	if fm.Ready() != r {
+		t.Error("readiness checked again")
+	}
+	fm.ready.time = time.Time{}
+	r = fm.Ready()
+	if r.Checks["credentials"].Status != HealthOK || r.Checks["cache"].Status != HealthOK {
+		t.Errorf("Ready() = %+v", r.Checks)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	fm := &FeedsMonitor{}
+	fm.lastStart.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/healthz")
+	fm.Handler()(&ctx)
+	var report HealthReport
+	if err := jsoniter.Unmarshal(ctx.Response.Body(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Response.StatusCode() != fasthttp.StatusServiceUnavailable || report.Status != HealthFail ||
+		report.Checks["run"].Details["running"] != true {
+		t.Errorf("/healthz: %d %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+}
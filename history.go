@@ -0,0 +1,122 @@
+package rss2masto
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/redis/go-redis/v9"
+)
+
+// HistoryConfig sets how long the post history and the account samples of each feed are kept,
+// see FeedsMonitor.History and FeedsMonitor.AccountSamples
+type HistoryConfig struct {
+	MaxAge     time.Duration `yaml:"max_age,omitempty"`       // 30 days if 0, a negative age disables the history
+	MaxEntries int           `yaml:"max_entries,omitempty"`   // entries kept per feed, no limit if 0
+	StatsAge   time.Duration `yaml:"stats_max_age,omitempty"` // age of the account samples, 365 days if 0, a negative age disables them
+}
+
+const (
+	defaultHistoryAge = 30 * 24 * time.Hour
+	historyKeyPrefix  = "rss2masto:history:"
+)
+
+// maxAge returns the age after which entries are removed, negative if the history is disabled
+func (c HistoryConfig) maxAge() time.Duration {
+	return cmp.Or(c.MaxAge, defaultHistoryAge)
+}
+
+// HistoryEntry is a post recorded in the history of a feed
+type HistoryEntry struct {
+	Time      time.Time `json:"time"`
+	Feed      string    `json:"feed"`      // name of the feed when the item was posted
+	Publisher string    `json:"publisher"` // name of the publisher, mastodon for the feed account
+	GUID      string    `json:"guid"`
+	Link      string    `json:"link"`
+	Title     string    `json:"title"`
+	StatusID  string    `json:"status_id,omitempty"`
+	URL       string    `json:"url,omitempty"` // URL of the post
+	Text      string    `json:"text"`          // rendered text of the post
+}
+
+// historyKey returns the key of the sorted set holding the history of a feed, scored by
+// Unix time in milliseconds. It is kept out of the feed namespace, whose keys are copied
+// as idempotency keys when the namespace changes; migrateKeys moves it instead.
+func historyKey(f *Feed) string {
+	return historyKeyPrefix + f.namespace()
+}
+
+// recordHistory adds a post to the history of a feed and removes the entries beyond the retention
+func recordHistory(cfg HistoryConfig, f *Feed, e *HistoryEntry) error {
+	maxAge := cfg.maxAge()
+	if maxAge < 0 {
+		return nil
+	}
+	data, err := jsoniter.Marshal(e)
+	if err != nil {
+		return err
+	}
+	key := historyKey(f)
+	if err := Cache.ZAdd(key, []redis.Z{{Score: float64(e.Time.UnixMilli()), Member: data}}); err != nil {
+		return err
+	}
+	expired := "(" + strconv.FormatInt(e.Time.Add(-maxAge).UnixMilli(), 10)
+	if err := Cache.ZRemRangeByScore(key, "-inf", expired); err != nil {
+		return err
+	}
+	if cfg.MaxEntries > 0 {
+		if err := Cache.ZRemRangeByRank(key, 0, -int64(cfg.MaxEntries)-1); err != nil {
+			return err
+		}
+	}
+	// the history of a removed feed expires with its last entry
+	return Cache.Expire(key, maxAge)
+}
+
+// History returns the posts of a feed since the given time, newest first:
+// all of them if since is zero, at most limit entries if limit is positive.
+// Posts are recorded in Redis, once per publisher, and kept as set by PostHistory.
+func (fm *FeedsMonitor) History(f *Feed, since time.Time, limit int) ([]HistoryEntry, error) {
+	from := "-inf"
+	if !since.IsZero() {
+		from = strconv.FormatInt(since.UnixMilli(), 10)
+	}
+	members, err := Cache.ZRevRangeByScore(historyKey(f), from, "+inf", int64(max(limit, 0)))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, 0, len(members))
+	var errs []error
+	for _, m := range members {
+		var e HistoryEntry
+		if err := jsoniter.UnmarshalFromString(m, &e); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, errors.Join(errs...)
+}
+
+// HistoryAll returns the posts of all feeds since the given time, newest first,
+// at most limit entries if limit is positive. See History.
+func (fm *FeedsMonitor) HistoryAll(since time.Time, limit int) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	for _, f := range fm.feeds() {
+		e, err := fm.History(f, since, limit)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	slices.SortStableFunc(entries, func(a, b HistoryEntry) int {
+		return b.Time.Compare(a.Time)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
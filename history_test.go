@@ -0,0 +1,82 @@
+package rss2masto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v9"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestHistoryConfig(t *testing.T) {
+	if got := (HistoryConfig{}).maxAge(); got != defaultHistoryAge {
+		t.Errorf("default max age = %v", got)
+	}
+	f := NewTestFeed("News", "https://example.com/feed.xml")
+	if err := recordHistory(HistoryConfig{MaxAge: -1}, f, &HistoryEntry{Time: time.Now()}); err != nil {
+		t.Errorf("disabled history: %v", err)
+	}
+	// the cache is offline in tests
+	if err := recordHistory(HistoryConfig{}, f, &HistoryEntry{Time: time.Now()}); !errors.Is(err, errOffline) {
+		t.Errorf("recordHistory() = %v", err)
+	}
+	if _, err := (&FeedsMonitor{}).History(f, time.Time{}, 0); !errors.Is(err, errOffline) {
+		t.Errorf("History() = %v", err)
+	}
+}
+
+func TestHistory_Integration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer client.FlushDB(context.Background())
+	saved := Cache
+	Cache = &CacheClient{client: client, ctx: context.Background(), cache: rediscache.New(&rediscache.Options{Redis: client})}
+	defer func() { Cache = saved }()
+
+	fm := &FeedsMonitor{}
+	news := NewTestFeed("News", "https://example.com/news.xml")
+	sport := NewTestFeed("Sport", "https://example.com/sport.xml")
+	fm.Instance.Feeds = []*Feed{news, sport}
+	cfg := HistoryConfig{MaxAge: 48 * time.Hour, MaxEntries: 2}
+	now := time.Now()
+	for i, e := range []struct {
+		feed *Feed
+		age  time.Duration
+	}{
+		{news, 72 * time.Hour}, // beyond the max age
+		{news, 3 * time.Hour},
+		{news, 2 * time.Hour},
+		{news, time.Hour},
+		{sport, 90 * time.Minute},
+	} {
+		entry := &HistoryEntry{Time: now.Add(-e.age), Feed: e.feed.Name, Publisher: "mastodon", GUID: string(rune('a' + i))}
+		if err := recordHistory(cfg, e.feed, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := fm.History(news, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].GUID != "d" || entries[1].GUID != "c" {
+		t.Errorf("History() = %+v", entries)
+	}
+	if entries, _ := fm.History(news, now.Add(-90*time.Minute), 0); len(entries) != 1 {
+		t.Errorf("History() since 90m = %+v", entries)
+	}
+	all, err := fm.HistoryAll(time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].GUID != "d" || all[1].Feed != "Sport" {
+		t.Errorf("HistoryAll() = %+v", all)
+	}
+}
@@ -0,0 +1,147 @@
+package rss2masto
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Hooks receives the events of a monitor, see FeedsMonitor.SetHooks.
+// Hooks are called synchronously by the goroutine processing the feed, so they
+// should return quickly and hand longer work over to another goroutine.
+// The item events are reported after the items of the feed are processed and the
+// feed is unlocked, so hooks may call the methods of the monitor, like FeedStatuses
+// or SaveState. Reload waits for the running cycle, so hooks must not call it.
+// A panicking hook is logged and doesn't stop the processing.
+// Embed NopHooks to implement only some of the methods.
+type Hooks interface {
+	// OnFetched is called for every response to a feed request, including post previews, with its status code and body size
+	OnFetched(f *Feed, status int, size int)
+	// OnItemSkipped is called for items that aren't posted
+	OnItemSkipped(f *Feed, item *gofeed.Item, reason SkipReason)
+	// OnPosted is called when a publisher posted an item, with the ID and URL of the post
+	OnPosted(f *Feed, item *gofeed.Item, publisher, statusID, url string)
+	// OnPostFailed is called when a publisher failed to post an item, which is retried on the next run
+	OnPostFailed(f *Feed, item *gofeed.Item, publisher string, err error)
+	// OnFeedStateChanged is called when the health of a feed changes
+	OnFeedStateChanged(f *Feed, from, to FeedHealth)
+}
+
+// NopHooks implements Hooks with methods doing nothing
+type NopHooks struct{}
+
+func (NopHooks) OnFetched(*Feed, int, int)                            {}
+func (NopHooks) OnItemSkipped(*Feed, *gofeed.Item, SkipReason)        {}
+func (NopHooks) OnPosted(*Feed, *gofeed.Item, string, string, string) {}
+func (NopHooks) OnPostFailed(*Feed, *gofeed.Item, string, error)      {}
+func (NopHooks) OnFeedStateChanged(*Feed, FeedHealth, FeedHealth)     {}
+
+// SkipReason tells why an item wasn't posted
+type SkipReason string
+
+const (
+	SkipTooOld        SkipReason = "too_old"         // published more than 12 hours ago
+	SkipBeforeLastRun SkipReason = "before_last_run" // published before the last processed item
+	SkipPosted        SkipReason = "already_posted"  // all publishers of the feed posted it
+	SkipDuplicate     SkipReason = "duplicate"       // a feed of the dedup group posted the same story
+)
+
+// FeedHealth is the state of a feed reported by OnFeedStateChanged and the admin API
+type FeedHealth string
+
+const (
+	FeedOK      FeedHealth = "ok"
+	FeedFailing FeedHealth = "failing" // the last fetch failed
+	FeedDown    FeedHealth = "down"    // at least 3 fetches failed in a row
+	FeedPaused  FeedHealth = "paused"
+)
+
+// downFailures is the number of failed fetches in a row after which a feed is down
+const downFailures = 3
+
+// Health returns the state of the feed
+func (f *Feed) Health() FeedHealth {
+	switch failures := f.Failures(); {
+	case f.Paused():
+		return FeedPaused
+	case failures >= downFailures:
+		return FeedDown
+	case failures > 0:
+		return FeedFailing
+	}
+	return FeedOK
+}
+
+// SetHooks sets the hooks of the monitor and its parser
+func (fm *FeedsMonitor) SetHooks(h Hooks) {
+	fm.Hooks = h
+	if fm.Parser != nil {
+		fm.Parser.Hooks = h
+	}
+}
+
+// hook calls a hook of the monitor, if set
+func (fm *FeedsMonitor) hook(name string, call func(Hooks)) {
+	callHook(fm.Hooks, fm.log(), name, call)
+}
+
+// hookQueue collects the hook calls made while a feed is locked
+type hookQueue []hookCall
+
+type hookCall struct {
+	name string
+	call func(Hooks)
+}
+
+// add queues a hook call
+func (q *hookQueue) add(name string, call func(Hooks)) {
+	*q = append(*q, hookCall{name, call})
+}
+
+// fire calls the queued hooks of the monitor in order
+func (q hookQueue) fire(fm *FeedsMonitor) {
+	for _, c := range q {
+		fm.hook(c.name, c.call)
+	}
+}
+
+// hook calls a hook of the parser, if set
+func (p *Parser) hook(name string, call func(Hooks)) {
+	callHook(p.Hooks, p.log(), name, call)
+}
+
+// callHook calls a hook, recovering from its panics
+func callHook(h Hooks, log *slog.Logger, name string, call func(Hooks)) {
+	if h == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Hook panicked", "hook", name, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	call(h)
+}
+
+// updateHealth reports a change of the health of a feed since the last call,
+// and raises or clears the fetch alert of the feed
+func (fm *FeedsMonitor) updateHealth(f *Feed) {
+	to := f.Health()
+	from, _ := f.health.Swap(to).(FeedHealth)
+	if from == to {
+		return
+	}
+	switch to {
+	case FeedDown:
+		msg, _ := f.LastError()
+		fm.checkAlert(AlertFetch, f, "", fmt.Errorf("%d fetches failed in a row, last error: %s", f.Failures(), msg))
+	case FeedOK:
+		fm.checkAlert(AlertFetch, f, "", nil)
+	}
+	if from != "" {
+		fm.feedLog(f).Info("Feed state changed", "from", from, "to", to)
+		fm.hook("OnFeedStateChanged", func(h Hooks) { h.OnFeedStateChanged(f, from, to) })
+	}
+}
@@ -0,0 +1,131 @@
+package rss2masto
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+// recordingHooks records the events it receives
+type recordingHooks struct {
+	NopHooks
+	events []string
+	panics bool          // OnPosted panics after recording
+	fm     *FeedsMonitor // OnPosted records the post count of the feed, read from the monitor
+}
+
+func (h *recordingHooks) OnFetched(f *Feed, status, size int) {
+	h.events = append(h.events, fmt.Sprintf("fetched %s %d %d", f.Name, status, size))
+}
+
+func (h *recordingHooks) OnItemSkipped(f *Feed, item *gofeed.Item, reason SkipReason) {
+	h.events = append(h.events, fmt.Sprintf("skipped %s %s", item.Title, reason))
+}
+
+func (h *recordingHooks) OnPosted(f *Feed, item *gofeed.Item, publisher, statusID, url string) {
+	h.events = append(h.events, fmt.Sprintf("posted %s %s %s %s", item.Title, publisher, statusID, url))
+	if h.fm != nil {
+		h.events = append(h.events, fmt.Sprintf("count %d", h.fm.FeedStatuses()[0].Count))
+	}
+	if h.panics {
+		panic("hook failure")
+	}
+}
+
+func (h *recordingHooks) OnPostFailed(f *Feed, item *gofeed.Item, publisher string, err error) {
+	h.events = append(h.events, fmt.Sprintf("failed %s %s %v", item.Title, publisher, err))
+}
+
+func (h *recordingHooks) OnFeedStateChanged(f *Feed, from, to FeedHealth) {
+	h.events = append(h.events, fmt.Sprintf("state %s %s %s", f.Name, from, to))
+}
+
+func TestHooks(t *testing.T) {
+	fm := loadInstancesConfig(t, `instance:
+  url: https://national.example
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: national-token
+      targets:
+        - name: regional
+          token: regional-token
+          instance: regional
+instances:
+  - name: regional
+    url: https://regional.example
+`)
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusNotModified)
+			return nil
+		},
+	})
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initTargets(); err != nil {
+		t.Fatal(err)
+	}
+	for name, mi := range fm.instances {
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				if name == "regional" {
+					resp.SetStatusCode(fasthttp.StatusServiceUnavailable)
+					return nil
+				}
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{"id":"1","url":"https://national.example/@news/1"}`)
+				return nil
+			},
+		}
+	}
+	// the hooks read the state of the feed they report on
+	hooks := &recordingHooks{fm: fm}
+	fm.SetHooks(hooks)
+
+	f := fm.Instance.Feeds[0]
+	fm.setFeedDefaults(f)
+	fm.GetFeed(f)
+
+	now := time.Now()
+	old := now.Add(-24 * time.Hour)
+	feed := &gofeed.Feed{Items: []*gofeed.Item{
+		{Title: "Headline", Link: "https://example.com/a", GUID: fmt.Sprintf("hooks-test-%d", now.UnixNano()), PublishedParsed: &now},
+		{Title: "Old", Link: "https://example.com/old", GUID: "old", PublishedParsed: &old},
+	}}
+	debugMode = false
+	defer func() { debugMode = true }()
+	fm.processFeed(f, feed)
+
+	f.failures.Store(downFailures)
+	fm.updateHealth(f)
+
+	want := []string{
+		"fetched News 304 0",
+		"skipped Old too_old",
+		"posted Headline mastodon 1 https://national.example/@news/1",
+		"count 1",
+		"failed Headline @regional returned status: 503",
+		"state News ok down",
+	}
+	if got := strings.Join(hooks.events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestHooks_Panic(t *testing.T) {
+	fm := &FeedsMonitor{}
+	hooks := &recordingHooks{panics: true}
+	fm.SetHooks(hooks)
+	item := &gofeed.Item{Title: "Headline"}
+	fm.hook("OnPosted", func(h Hooks) { h.OnPosted(nil, item, "mastodon", "1", "") })
+	fm.hook("OnPostFailed", func(h Hooks) { h.OnPostFailed(nil, item, "mastodon", nil) })
+	if len(hooks.events) != 2 {
+		t.Errorf("events after a panic = %v", hooks.events)
+	}
+}
@@ -0,0 +1,183 @@
+package rss2masto
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// MastodonInstance is a named Mastodon instance in the instances list of the configuration.
+// Feeds select it with Feed.Instance; feeds without an instance use the instance block.
+type MastodonInstance struct {
+	Name     string `yaml:"name"`               // name referenced by Feed.Instance
+	URL      string `yaml:"url"`                // instance base URL
+	Lang     string `yaml:"lang,omitempty"`     // fallback post language, instance.lang if empty
+	Limit    int    `yaml:"limit,omitempty"`    // max characters per post, discovered if zero
+	TimeZone string `yaml:"timezone,omitempty"` // timezone for display timestamps, instance.timezone if empty
+
+	Version  string `yaml:"-"` // software version reported by the instance
+	MaxMedia int    `yaml:"-"` // max media attachments per status, 0 if unknown
+
+	client     httpClient
+	location   *time.Location
+	publishers sync.Map // token and limit -> *MastodonPublisher, see publisher
+}
+
+// publisher returns the publisher of the account owning token. Publishers are kept
+// with the instance, so the rate limit they track carries over between runs;
+// they are created again when Reload replaces the instances.
+func (mi *MastodonInstance) publisher(token string) *MastodonPublisher {
+	key := token + "\x00" + strconv.Itoa(mi.Limit)
+	if p, ok := mi.publishers.Load(key); ok {
+		return p.(*MastodonPublisher)
+	}
+	p, _ := mi.publishers.LoadOrStore(key, NewMastodonPublisher(mi.URL, token, mi.Limit, mi.client))
+	return p.(*MastodonPublisher)
+}
+
+// Get performs a GET request to the specified endpoint on the instance.
+// Optional parameter token can be provided for authentication
+func (mi *MastodonInstance) Get(endpoint string, token ...string) ([]byte, error) {
+	return getFromInstance(mi.client, mi.URL+endpoint, token...)
+}
+
+// Location returns the timezone location of the instance
+func (mi *MastodonInstance) Location() *time.Location {
+	if mi.location == nil {
+		return time.UTC
+	}
+	return mi.location
+}
+
+// discover fetches the character limit and capabilities of the instance.
+// A configured limit is kept; if the instance can't be reached, the default limit is used.
+func (mi *MastodonInstance) discover() {
+	b, err := mi.Get("/api/v1/instance")
+	if err != nil {
+		b, err = mi.Get("/api/v2/instance")
+		if err != nil {
+			logger().Warn("Error getting instance data", errAttrs(err, "instance", mi.Name, "url", mi.URL)...)
+			if mi.Limit == 0 {
+				mi.Limit = DefaultCharacterLimit
+			}
+			return
+		}
+	}
+	mi.Version = jsoniter.Get(b, "version").ToString()
+	mi.MaxMedia = jsoniter.Get(b, "configuration", "statuses", "max_media_attachments").ToInt()
+	if mi.Limit > 0 {
+		return
+	}
+	mi.Limit = jsoniter.Get(b, "configuration", "statuses", "max_characters").ToInt()
+	if mi.Limit == 0 {
+		// Akkoma/Pleroma compatibility
+		mi.Limit = jsoniter.Get(b, "max_toot_chars").ToInt()
+	}
+	if mi.Limit <= 0 {
+		mi.Limit = DefaultCharacterLimit
+	}
+}
+
+// newInstanceClient creates the client for an instance host
+func newInstanceClient(host string) *fasthttp.HostClient {
+	return &fasthttp.HostClient{
+		IsTLS:                  true,
+		Addr:                   host + ":443",
+		Name:                   "rss2masto",
+		ReadTimeout:            15 * time.Second,
+		WriteTimeout:           15 * time.Second,
+		DisablePathNormalizing: true,
+		Dial: (&fasthttp.TCPDialer{
+			DNSCacheDuration: time.Hour,
+		}).Dial,
+	}
+}
+
+// initInstances creates the instances of the configuration: the instance block
+// (if it has a URL) under the empty name, followed by the instances list.
+// Each instance gets its own client. Lang and TimeZone default to the instance block.
+func (fm *FeedsMonitor) initInstances() error {
+	fm.instances = make(map[string]*MastodonInstance, len(fm.Instances)+1)
+	if fm.Instance.URL != "" {
+		fm.instances[""] = &MastodonInstance{
+			URL:      fm.Instance.URL,
+			Lang:     fm.Instance.Lang,
+			Limit:    fm.Instance.Limit,
+			TimeZone: fm.Instance.TimeZone,
+			client:   fm.hostClient,
+			location: fm.Location(),
+		}
+	}
+
+	for _, mi := range fm.Instances {
+		if mi.Name == "" {
+			return fmt.Errorf("instance %s: missing name", mi.URL)
+		}
+		if _, ok := fm.instances[mi.Name]; ok {
+			return fmt.Errorf("instance %s: duplicate name", mi.Name)
+		}
+		host, err := fm.parseURLHost(mi.URL)
+		if err != nil {
+			return fmt.Errorf("instance %s: invalid URL: %w", mi.Name, err)
+		}
+		mi.client = newInstanceClient(host)
+		if mi.Lang == "" {
+			mi.Lang = fm.Instance.Lang
+		}
+		if mi.TimeZone == "" {
+			mi.TimeZone = fm.Instance.TimeZone
+		}
+		mi.location, err = time.LoadLocation(mi.TimeZone)
+		if err != nil {
+			logger().Warn("Unknown time zone, using UTC", classAttrs(errClassConfig, err, "instance", mi.Name)...)
+			mi.location = time.UTC
+		}
+		fm.instances[mi.Name] = mi
+	}
+
+	for _, feed := range fm.Instance.Feeds {
+		if _, ok := fm.instances[feed.Instance]; !ok {
+			return fmt.Errorf("[%s] unknown instance %q", feed.Name, feed.Instance)
+		}
+	}
+	return nil
+}
+
+// discoverInstances discovers limits and capabilities of all instances concurrently
+func (fm *FeedsMonitor) discoverInstances() {
+	var wg sync.WaitGroup
+	for _, mi := range fm.instances {
+		wg.Go(mi.discover)
+	}
+	wg.Wait()
+
+	// keep the discovered limit of the instance block in the saved configuration
+	if mi, ok := fm.instances[""]; ok {
+		fm.Instance.Limit = mi.Limit
+	}
+}
+
+// feedInstance returns the instance of a feed, or nil if it's unknown.
+// Before NewFeedsMonitor initialises instances (e.g. with LoadConfig),
+// the instance block is used for feeds without an instance.
+func (fm *FeedsMonitor) feedInstance(f *Feed) *MastodonInstance {
+	name := f.instanceName()
+	if mi, ok := fm.instances[name]; ok {
+		return mi
+	}
+	if fm.instances != nil || name != "" {
+		return nil
+	}
+	return &MastodonInstance{
+		URL:      fm.Instance.URL,
+		Lang:     fm.Instance.Lang,
+		Limit:    fm.Instance.Limit,
+		TimeZone: fm.Instance.TimeZone,
+		client:   fm.hostClient,
+		location: fm.Location(),
+	}
+}
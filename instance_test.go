@@ -0,0 +1,182 @@
+package rss2masto
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v3"
+)
+
+const instancesConfig = `instance:
+  url: https://mastodon.social
+  lang: pl
+  timezone: Europe/Warsaw
+  feed:
+    - name: Default
+      url: https://example.com/a.xml
+      token: t1
+    - name: Tech
+      url: https://example.com/b.xml
+      token: t2
+      instance: fosstodon
+    - name: Local
+      url: https://example.com/c.xml
+      token: t3
+      instance: local
+instances:
+  - name: fosstodon
+    url: https://fosstodon.org
+    lang: en
+    timezone: UTC
+  - name: local
+    url: https://social.example.pl
+    limit: 5000
+`
+
+func loadInstancesConfig(t *testing.T, config string) *FeedsMonitor {
+	t.Helper()
+	fm := &FeedsMonitor{}
+	if err := yaml.Unmarshal([]byte(config), fm); err != nil {
+		t.Fatal(err)
+	}
+	return fm
+}
+
+func TestInitInstances(t *testing.T) {
+	fm := loadInstancesConfig(t, instancesConfig)
+	if err := fm.initInstances(); err != nil {
+		t.Fatalf("initInstances() error = %v", err)
+	}
+
+	if len(fm.instances) != 3 {
+		t.Fatalf("got %d instances, want 3", len(fm.instances))
+	}
+	tech := fm.feedInstance(fm.Instance.Feeds[1])
+	if tech == nil || tech.URL != "https://fosstodon.org" || tech.Lang != "en" || tech.Location() != time.UTC {
+		t.Errorf("Tech instance = %+v", tech)
+	}
+	local := fm.feedInstance(fm.Instance.Feeds[2])
+	if local == nil || local.Lang != "pl" || local.Location().String() != "Europe/Warsaw" || local.Limit != 5000 {
+		t.Errorf("Local instance = %+v, want defaults from the instance block", local)
+	}
+	if local.client == nil || local.client == tech.client {
+		t.Error("each instance needs its own client")
+	}
+	if mi := fm.feedInstance(fm.Instance.Feeds[0]); mi == nil || mi.URL != "https://mastodon.social" {
+		t.Errorf("Default instance = %+v", mi)
+	}
+}
+
+func TestInitInstances_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name:   "unknown instance",
+			config: "instance:\n  url: https://a.example\n  feed:\n    - name: x\n      instance: nope\n",
+			want:   `unknown instance "nope"`,
+		},
+		{
+			name:   "duplicate name",
+			config: "instances:\n  - name: a\n    url: https://a.example\n  - name: a\n    url: https://b.example\n",
+			want:   "duplicate name",
+		},
+		{
+			name:   "missing name",
+			config: "instances:\n  - url: https://a.example\n",
+			want:   "missing name",
+		},
+		{
+			name:   "insecure URL",
+			config: "instances:\n  - name: a\n    url: http://a.example\n",
+			want:   "invalid URL",
+		},
+		{
+			name:   "feed without instance and no instance block",
+			config: "instances:\n  - name: a\n    url: https://a.example\ninstance:\n  feed:\n    - name: x\n",
+			want:   `unknown instance ""`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := loadInstancesConfig(t, tt.config)
+			err := fm.initInstances()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("initInstances() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverInstances(t *testing.T) {
+	fm := loadInstancesConfig(t, instancesConfig)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+
+	var inFlight, maxInFlight atomic.Int64
+	limits := map[string]string{
+		"":          `{"version":"4.3.0","configuration":{"statuses":{"max_characters":500,"max_media_attachments":4}}}`,
+		"fosstodon": `{"version":"4.2.1","configuration":{"statuses":{"max_characters":500}}}`,
+		"local":     `{"max_toot_chars":20000}`,
+	}
+	for name, mi := range fm.instances {
+		body := limits[name]
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(body)
+				return nil
+			},
+		}
+	}
+
+	fm.discoverInstances()
+
+	if maxInFlight.Load() < 2 {
+		t.Error("instances were not discovered concurrently")
+	}
+	if mi := fm.instances[""]; mi.Limit != 500 || mi.MaxMedia != 4 || mi.Version != "4.3.0" || fm.Instance.Limit != 500 {
+		t.Errorf("default instance = %+v, instance block limit = %d", mi, fm.Instance.Limit)
+	}
+	if mi := fm.instances["local"]; mi.Limit != 5000 {
+		t.Errorf("configured limit overridden: %d", mi.Limit)
+	}
+}
+
+func TestFeedPublishers_Instance(t *testing.T) {
+	fm := loadInstancesConfig(t, instancesConfig)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	fm.instances["fosstodon"].Limit = 500
+
+	list := fm.feedPublishers(fm.Instance.Feeds[1])
+	if len(list) != 1 {
+		t.Fatalf("got %d publishers, want 1", len(list))
+	}
+	m, ok := list[0].Publisher.(*MastodonPublisher)
+	if !ok || m.URL != "https://fosstodon.org" || m.Token != "t2" || m.Limit != 500 {
+		t.Errorf("publisher = %+v", list[0].Publisher)
+	}
+
+	feed := NewTestFeed("x", "https://example.com/x.xml")
+	feed.Token, feed.Instance = "t", "removed"
+	if list := fm.feedPublishers(feed); len(list) != 0 {
+		t.Errorf("feed with unknown instance got %d publishers", len(list))
+	}
+}
@@ -0,0 +1,126 @@
+package rss2masto
+
+import (
+	"cmp"
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// namespace returns the prefix of the feed's cache keys: a hash of the configured id,
+// or of name, token and instance. Unlike the legacy 2-character name prefix
+// it is not shared by feeds with similar names.
+func (f *Feed) namespace() string {
+	f.cfgMu.RLock()
+	id, name, token, instance := f.FeedID, f.Name, cmp.Or(f.token, f.Token), f.Instance
+	f.cfgMu.RUnlock()
+	if id != "" {
+		return hashString("id\x00" + id)
+	}
+	return hashString(name + "\x00" + token + "\x00" + instance)
+}
+
+// legacyNamespace returns the key prefix used before namespaces: the first 2 bytes of the name
+func (f *Feed) legacyNamespace() string {
+	if len(f.Name) < 2 {
+		return ""
+	}
+	return f.Name[:2]
+}
+
+// namespaceKeyPrefix prefixes the keys recording the namespace of each feed, see migrateKeys
+const namespaceKeyPrefix = "rss2masto:namespace:"
+
+// namespaceKey returns the key recording the namespace of a feed, by its id or name
+func namespaceKey(f *Feed) string {
+	f.cfgMu.RLock()
+	defer f.cfgMu.RUnlock()
+	if f.FeedID != "" {
+		return namespaceKeyPrefix + hashString("id\x00"+f.FeedID)
+	}
+	return namespaceKeyPrefix + hashString(f.Name)
+}
+
+// migrateKeys copies the deduplication keys of feeds whose namespace changed, from the
+// namespace recorded in the state or, without state, in Redis (e.g. after a rename or a
+// token change), whose post history, account and engagement samples are moved too.
+// Feeds without recorded namespace copy the keys of the legacy prefix, once: the keys of
+// all feeds sharing the prefix, which can't be told apart. Such a feed skips the items
+// the others posted in the last 7 days, but never reposts an item. Old keys are left to
+// expire, as feeds sharing a legacy prefix all need them.
+func (fm *FeedsMonitor) migrateKeys(feeds []*Feed) {
+	for _, f := range feeds {
+		f.mu.Lock()
+		if err := fm.migrateFeedKeys(f); err != nil {
+			fm.feedLog(f).Error("Error migrating cache keys", errAttrs(err)...)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// migrateFeedKeys migrates the keys of a feed to its namespace and records it, see migrateKeys.
+// f.mu must be held.
+func (fm *FeedsMonitor) migrateFeedKeys(f *Feed) error {
+	ns := f.namespace()
+	marker := namespaceKey(f)
+	from, legacy := f.keyPrefix, false
+	if from == "" {
+		recorded, err := Cache.Get(marker)
+		switch {
+		case err == nil:
+			from = recorded
+		case errors.Is(err, redis.Nil):
+			from, legacy = f.legacyNamespace(), true
+		case errors.Is(err, errOffline):
+			// nothing to migrate without Redis
+		default:
+			return err
+		}
+	}
+	if from != ns && from != "" {
+		n, err := copyKeys(from+":", ns+":")
+		if err == nil && !legacy {
+			err = moveSets(from, ns)
+		}
+		switch {
+		case errors.Is(err, errOffline):
+		case err != nil:
+			return err
+		case n > 0:
+			fm.feedLog(f).Info("Migrated cache keys", "keys", n, "from", from)
+		}
+	}
+	f.keyPrefix = ns
+	if err := Cache.Set(marker, ns, twoYearDuration); err != nil && !errors.Is(err, errOffline) {
+		return err
+	}
+	return nil
+}
+
+// copyKeys stores a key with prefix to for each key with prefix from and returns their number
+func copyKeys(from, to string) (int, error) {
+	keys, err := Cache.ScanKeys(globEscaper.Replace(from) + "*")
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := Cache.Store(to+strings.TrimPrefix(key, from), "1"); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// moveSets moves the post history, account and engagement samples of a namespace to another one
+func moveSets(from, to string) error {
+	for _, prefix := range []string{historyKeyPrefix, accountKeyPrefix, engagementKeyPrefix} {
+		if err := Cache.ZMove(prefix+from, prefix+to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globEscaper escapes the special characters of Redis key patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
@@ -0,0 +1,162 @@
+package rss2masto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rediscache "github.com/go-redis/cache/v9"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNamespace(t *testing.T) {
+	sport := NewTestFeed("Sport PL", "https://example.com/sport.xml")
+	society := NewTestFeed("Społeczeństwo", "https://example.com/society.xml")
+	if sport.legacyNamespace() != society.legacyNamespace() {
+		t.Fatal("test feeds should share the legacy prefix")
+	}
+	if sport.namespace() == society.namespace() {
+		t.Error("feeds with a common name prefix share a namespace")
+	}
+
+	ns := sport.namespace()
+	sport.Token = "other-token"
+	if sport.namespace() == ns {
+		t.Error("namespace doesn't depend on the token")
+	}
+	sport.Instance = "regional"
+	if sport.namespace() == ns {
+		t.Error("namespace doesn't depend on the instance")
+	}
+
+	// an id keeps the namespace across renames and token changes
+	sport.FeedID = "sport"
+	ns = sport.namespace()
+	sport.Name, sport.Token = "Sport", "new-token"
+	if sport.namespace() != ns {
+		t.Error("namespace with an id changed")
+	}
+	if one := NewTestFeed("Ł", ""); one.legacyNamespace() != "Ł" {
+		t.Errorf("legacy prefix = %q", one.legacyNamespace())
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	if _, err := client.Ping(context.Background()).Result(); err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer client.FlushDB(context.Background())
+
+	local := rediscache.NewTinyLFU(100, time.Minute)
+	original := Cache
+	Cache = &CacheClient{
+		client:     client,
+		ctx:        context.Background(),
+		localCache: local,
+		cache:      rediscache.New(&rediscache.Options{Redis: client, LocalCache: local}),
+	}
+	defer func() { Cache = original }()
+
+	guid := hashString("https://example.com/a")
+	for _, key := range []string{"Sp:" + guid, "Sp:@regional:" + guid, "Ne:" + guid} {
+		if err := Cache.Store(key, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fm := &FeedsMonitor{}
+	sport := NewTestFeed("Sport PL", "https://example.com/sport.xml")
+	fm.migrateKeys([]*Feed{sport})
+	ns := sport.namespace()
+	if sport.keyPrefix != ns || !Cache.KeyExists(ns+":"+guid) || !Cache.KeyExists(ns+":@regional:"+guid) || Cache.KeyExists(ns+":"+hashString("other")) {
+		t.Errorf("legacy keys not migrated to %s", ns)
+	}
+
+	if recorded, err := Cache.Get(namespaceKey(sport)); err != nil || recorded != ns {
+		t.Errorf("recorded namespace = %q, %v", recorded, err)
+	}
+
+	// keys of the legacy prefix can't be told apart: the other feed gets them too
+	society := NewTestFeed("Społeczeństwo", "https://example.com/society.xml")
+	fm.migrateKeys([]*Feed{society})
+	if !Cache.KeyExists(society.namespace() + ":" + guid) {
+		t.Error("legacy keys not migrated for a feed sharing the prefix")
+	}
+
+	// a restart without state doesn't copy the legacy keys again
+	later := hashString("https://example.com/b")
+	if err := Cache.Store("Sp:"+later, "1"); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewTestFeed("Sport PL", "https://example.com/sport.xml")
+	fm.migrateKeys([]*Feed{restarted})
+	if restarted.keyPrefix != ns || Cache.KeyExists(ns+":"+later) {
+		t.Error("legacy keys copied again after a restart")
+	}
+
+	// a new token is followed from the recorded namespace
+	restarted = NewTestFeed("Sport PL", "https://example.com/sport.xml")
+	restarted.Token = "new-token"
+	fm.migrateKeys([]*Feed{restarted})
+	if restarted.keyPrefix == ns || !Cache.KeyExists(restarted.namespace()+":"+guid) {
+		t.Error("keys not migrated after a token change without state")
+	}
+
+	// a rename moves the keys to the new namespace
+	ns = restarted.namespace()
+	restarted.Name = "Sport"
+	fm.migrateKeys([]*Feed{restarted})
+	if restarted.keyPrefix == ns || !Cache.KeyExists(restarted.namespace()+":"+guid) {
+		t.Error("keys not migrated after a rename")
+	}
+}
+
+func TestMigrateKeys_History(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	if _, err := client.Ping(context.Background()).Result(); err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer client.FlushDB(context.Background())
+	original := Cache
+	Cache = &CacheClient{client: client, ctx: context.Background(), cache: rediscache.New(&rediscache.Options{Redis: client})}
+	defer func() { Cache = original }()
+
+	fm := &FeedsMonitor{}
+	news := NewTestFeed("News", "https://example.com/news.xml")
+	news.Token = "old-token"
+	fm.Instance.Feeds = []*Feed{news}
+	fm.migrateKeys(fm.Instance.Feeds)
+	now := time.Now()
+	if err := recordHistory(HistoryConfig{}, news, &HistoryEntry{Time: now.Add(-time.Hour), Feed: news.Name, Publisher: mastodonPublisherName, GUID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordAccount(HistoryConfig{}, news, &AccountSample{Time: now.Add(-time.Hour), Followers: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new token changes the namespace, the history and the samples follow it
+	old := news.namespace()
+	news.Token = "new-token"
+	if err := recordAccount(HistoryConfig{}, news, &AccountSample{Time: now, Followers: 12}); err != nil {
+		t.Fatal(err)
+	}
+	fm.migrateKeys(fm.Instance.Feeds)
+	entries, err := fm.History(news, time.Time{}, 0)
+	if err != nil || len(entries) != 1 || entries[0].GUID != "a" {
+		t.Errorf("History() after a token change = %v, %v", entries, err)
+	}
+	r, err := fm.Growth(news, 24*time.Hour)
+	if err != nil || r.Samples != 2 || r.FollowersChange != 2 || r.Posts != 1 {
+		t.Errorf("Growth() after a token change = %+v, %v", r, err)
+	}
+	if n, _ := client.Exists(context.Background(), historyKeyPrefix+old).Result(); n != 0 {
+		t.Error("history of the old namespace not removed")
+	}
+}
@@ -0,0 +1,102 @@
+package rss2masto
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// defaultLogger is the logger of the package-level code (cache, hash dictionary)
+// and of monitors and parsers without their own Logger
+var defaultLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger used by the package-level code and by monitors and
+// parsers without their own Logger. Without it, slog.Default() is used.
+func SetLogger(l *slog.Logger) {
+	defaultLogger.Store(redactLogger(l))
+}
+
+// logger returns the package logger. Loggers of the package redact secrets, see redactHandler.
+func logger() *slog.Logger {
+	if l := defaultLogger.Load(); l != nil {
+		return redactLogger(l)
+	}
+	return redactLogger(slog.Default())
+}
+
+// SetLogger sets the logger of the monitor and its parser
+func (fm *FeedsMonitor) SetLogger(l *slog.Logger) {
+	fm.Logger = l
+	if fm.Parser != nil {
+		fm.Parser.Logger = l
+	}
+}
+
+// log returns the logger of the monitor
+func (fm *FeedsMonitor) log() *slog.Logger {
+	if fm.Logger != nil {
+		return redactLogger(fm.Logger)
+	}
+	return logger()
+}
+
+// feedLog returns the logger of the monitor with the feed attribute
+func (fm *FeedsMonitor) feedLog(f *Feed) *slog.Logger {
+	return fm.log().With("feed", f.label())
+}
+
+// log returns the logger of the parser
+func (p *Parser) log() *slog.Logger {
+	if p.Logger != nil {
+		return redactLogger(p.Logger)
+	}
+	return logger()
+}
+
+// Error classes of the error_class attribute
+const (
+	errClassTimeout  = "timeout"
+	errClassNetwork  = "network"
+	errClassHTTP     = "http"
+	errClassParse    = "parse"
+	errClassCache    = "cache"
+	errClassConfig   = "config"
+	errClassTemplate = "template"
+	errClassOther    = "other"
+)
+
+// errorClass returns the class of an error for filtering and alerting
+func errorClass(err error) string {
+	var httpErr *httpError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &httpErr):
+		return errClassHTTP
+	case errors.Is(err, fasthttp.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return errClassTimeout
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return errClassTimeout
+		}
+		return errClassNetwork
+	case errors.Is(err, fasthttp.ErrConnectionClosed), errors.Is(err, fasthttp.ErrNoFreeConns):
+		return errClassNetwork
+	case errors.Is(err, errOffline):
+		return errClassCache
+	}
+	return errClassOther
+}
+
+// errAttrs returns the error and error_class attributes followed by args
+func errAttrs(err error, args ...any) []any {
+	return append([]any{"error", err, "error_class", errorClass(err)}, args...)
+}
+
+// classAttrs returns the error attributes with a known class followed by args
+func classAttrs(class string, err error, args ...any) []any {
+	return append([]any{"error", err, "error_class", class}, args...)
+}
@@ -0,0 +1,106 @@
+package rss2masto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&httpError{Status: 500}, errClassHTTP},
+		{fmt.Errorf("post: %w", &httpError{Status: 429}), errClassHTTP},
+		{fasthttp.ErrTimeout, errClassTimeout},
+		{&net.DNSError{Err: "no such host", IsTimeout: true}, errClassTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, errClassNetwork},
+		{redactError(&net.OpError{Op: "dial", Err: errors.New("refused")}), errClassNetwork},
+		{errOffline, errClassCache},
+		{errors.New("boom"), errClassOther},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+// logRecords decodes the records written by a JSON handler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]any
+		if err := jsoniter.UnmarshalFromString(line, &r); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestFetchAndParse_Logging(t *testing.T) {
+	status := fasthttp.StatusNotModified
+	p := NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(status)
+			return nil
+		},
+	})
+	var buf bytes.Buffer
+	p.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	f := NewTestFeed("News", "https://example.com/feed.xml")
+	p.FetchAndParse(f)
+	status = fasthttp.StatusInternalServerError
+	p.FetchAndParse(f)
+
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("logged %d records, want 2: %s", len(records), buf.String())
+	}
+	notModified, failed := records[0], records[1]
+	if notModified["level"] != "DEBUG" || notModified["msg"] != "Not modified" || notModified["feed"] != "News" ||
+		notModified["url"] != "https://example.com/feed.xml" || notModified["status"] != float64(304) {
+		t.Errorf("not modified record = %v", notModified)
+	}
+	if _, ok := notModified["duration"]; !ok {
+		t.Error("not modified record without duration")
+	}
+	if failed["level"] != "ERROR" || failed["status"] != float64(500) || failed["error_class"] != errClassHTTP {
+		t.Errorf("failed fetch record = %v", failed)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer defaultLogger.Store(nil)
+
+	fm := &FeedsMonitor{Parser: NewParser(nil)}
+	fm.log().Info("package logger")
+	var own bytes.Buffer
+	fm.SetLogger(slog.New(slog.NewJSONHandler(&own, nil)))
+	fm.feedLog(NewTestFeed("News", "")).Info("monitor logger")
+	fm.Parser.log().Info("parser logger")
+
+	if !strings.Contains(buf.String(), "package logger") || strings.Contains(buf.String(), "monitor logger") {
+		t.Errorf("package logger output = %s", buf.String())
+	}
+	records := logRecords(t, &own)
+	if len(records) != 2 || records[0]["feed"] != "News" || records[1]["msg"] != "parser logger" {
+		t.Errorf("monitor logger output = %s", own.String())
+	}
+}
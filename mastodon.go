@@ -0,0 +1,101 @@
+package rss2masto
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+const mastodonPublisherName = "mastodon"
+
+// MastodonPublisher publishes statuses to a Mastodon (or compatible) instance
+// on behalf of the account owning the token
+type MastodonPublisher struct {
+	URL    string // instance base URL
+	Token  string // account access token
+	Limit  int    // instance character limit
+	client httpClient
+
+	remaining atomic.Int64 // X-RateLimit-Remaining of the last response, -1 if unknown
+}
+
+// NewMastodonPublisher creates a publisher for the account owning token on the instance at url.
+// The client may be a fasthttp.HostClient bound to the instance.
+func NewMastodonPublisher(url, token string, limit int, client httpClient) *MastodonPublisher {
+	m := &MastodonPublisher{
+		URL:    strings.TrimSuffix(url, "/"),
+		Token:  token,
+		Limit:  limit,
+		client: client,
+	}
+	m.remaining.Store(-1)
+	return m
+}
+
+// RateLimitRemaining returns the number of requests the account may still send in the
+// current rate limit window, as reported by the last response, and false if unknown
+func (m *MastodonPublisher) RateLimitRemaining() (int64, bool) {
+	n := m.remaining.Load()
+	return n, n >= 0
+}
+
+// readRateLimit records the X-RateLimit-Remaining header of a response
+func (m *MastodonPublisher) readRateLimit(resp *fasthttp.Response) {
+	if n, err := strconv.ParseInt(string(resp.Header.Peek("X-RateLimit-Remaining")), 10, 64); err == nil && n >= 0 {
+		m.remaining.Store(n)
+	}
+}
+
+// Publish posts a new status. The idempotency key is sent as the Idempotency-Key
+// header, so a retried request within an hour doesn't create a duplicate.
+func (m *MastodonPublisher) Publish(p *Post) (*Published, error) {
+	post := MastodonPost{
+		Status:     p.Text,
+		Visibility: p.Visibility,
+	}
+	if len(p.Language) == 2 {
+		post.Language = p.Language
+	}
+
+	headers := []string{"Authorization", "Bearer " + m.Token}
+	if p.IdempotencyKey != "" {
+		headers = append(headers, "Idempotency-Key", p.IdempotencyKey)
+	}
+	b, err := doJSON(m.client, fasthttp.MethodPost, m.URL+"/api/v1/statuses", post, m.readRateLimit, headers...)
+	if err != nil {
+		return nil, err
+	}
+	return &Published{
+		ID:  jsoniter.Get(b, "id").ToString(),
+		URL: jsoniter.Get(b, "url").ToString(),
+	}, nil
+}
+
+// Edit replaces the text and language of a status
+func (m *MastodonPublisher) Edit(id string, p *Post) error {
+	post := MastodonPost{Status: p.Text}
+	if len(p.Language) == 2 {
+		post.Language = p.Language
+	}
+	_, err := doJSON(m.client, fasthttp.MethodPut, m.URL+"/api/v1/statuses/"+id, post, m.readRateLimit, "Authorization", "Bearer "+m.Token)
+	return err
+}
+
+// Delete deletes a status
+func (m *MastodonPublisher) Delete(id string) error {
+	_, err := doJSON(m.client, fasthttp.MethodDelete, m.URL+"/api/v1/statuses/"+id, nil, m.readRateLimit, "Authorization", "Bearer "+m.Token)
+	return err
+}
+
+// Capabilities returns the character limit of the instance.
+// Images are not uploaded, the link preview is left to the instance.
+func (m *MastodonPublisher) Capabilities() Capabilities {
+	return Capabilities{
+		MaxLength: m.Limit,
+		Edit:      true,
+		Delete:    true,
+	}
+}
@@ -0,0 +1,367 @@
+package rss2masto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// feedStats counts the fetches and items of a feed for the metrics endpoint
+type feedStats struct {
+	mu          sync.Mutex
+	fetches     map[int]uint64   // responses by status code
+	fetchErrors uint64           // requests without response
+	parseErrors uint64           // responses that couldn't be parsed or scraped
+	seen        uint64           // items of the fetched or pushed feeds
+	skipped     uint64           // items too old or already posted
+	deduped     uint64           // items posted by another feed of the dedup group
+	posted      uint64           // items posted by at least one publisher
+	remaining   map[string]int64 // publisher name -> rate limit remaining
+	postTimes   []int64          // Unix times of the posts of the last 24 hours
+	followers   []sample         // hourly follower counts of the last week
+}
+
+// sample is a value of a time series
+type sample struct {
+	Time  int64 // Unix time
+	Value int64
+}
+
+const (
+	postTimesWindow   = 24 * time.Hour
+	followersInterval = time.Hour
+	followersWindow   = 7 * 24 * time.Hour
+)
+
+// addPost counts a posted item
+func (s *feedStats) addPost(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posted++
+	limit := t.Add(-postTimesWindow).Unix()
+	s.postTimes = append(slices.DeleteFunc(s.postTimes, func(pt int64) bool { return pt < limit }), t.Unix())
+}
+
+// recentPosts returns the number of items posted in the last 24 hours
+func (s *feedStats) recentPosts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limit := time.Now().Add(-postTimesWindow).Unix()
+	n := 0
+	for _, t := range s.postTimes {
+		if t >= limit {
+			n++
+		}
+	}
+	return n
+}
+
+// addFollowers records a follower count, at most one per followersInterval.
+// A minute of slack keeps the samples of an hourly ticker apart.
+func (s *feedStats) addFollowers(t time.Time, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := len(s.followers); l > 0 && t.Unix()-s.followers[l-1].Time < int64((followersInterval-time.Minute)/time.Second) {
+		s.followers[l-1].Value = n
+		return
+	}
+	limit := t.Add(-followersWindow).Unix()
+	s.followers = append(slices.DeleteFunc(s.followers, func(p sample) bool { return p.Time < limit }), sample{t.Unix(), n})
+}
+
+// followerTrend returns the recorded follower counts, oldest first
+func (s *feedStats) followerTrend() []sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.followers)
+}
+
+// setFollowers sets the follower count of the feed account
+func (f *Feed) setFollowers(n int64) {
+	f.Followers.Store(n)
+	f.stats.addFollowers(time.Now(), n)
+}
+
+func (s *feedStats) fetched(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetches == nil {
+		s.fetches = make(map[int]uint64)
+	}
+	s.fetches[status]++
+}
+
+// add adds n to a counter of the feed
+func (s *feedStats) add(counter *uint64, n int) {
+	s.mu.Lock()
+	*counter += uint64(n)
+	s.mu.Unlock()
+}
+
+func (s *feedStats) setRemaining(publisher string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remaining == nil {
+		s.remaining = make(map[string]int64)
+	}
+	s.remaining[publisher] = n
+}
+
+// rateLimited is implemented by publishers reporting the rate limit of their account
+type rateLimited interface {
+	RateLimitRemaining() (int64, bool)
+}
+
+// postBuckets are the upper bounds, in seconds, of the post latency histogram
+var postBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative Prometheus histogram with postBuckets
+type histogram struct {
+	counts []uint64 // observations per bucket, not cumulated
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(postBuckets))
+	}
+	if i, _ := slices.BinarySearch(postBuckets, v); i < len(postBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// postStats holds the latency and the errors of posts by publisher name
+type postStats struct {
+	mu       sync.Mutex
+	duration map[string]*histogram
+	errors   map[[2]string]uint64 // publisher name, status code or error class -> count
+}
+
+// record records the outcome of a post
+func (s *postStats) record(publisher string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if s.errors == nil {
+			s.errors = make(map[[2]string]uint64)
+		}
+		s.errors[[2]string{publisher, postErrorCode(err)}]++
+		return
+	}
+	if s.duration == nil {
+		s.duration = make(map[string]*histogram)
+	}
+	h := s.duration[publisher]
+	if h == nil {
+		h = &histogram{}
+		s.duration[publisher] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// postErrorCode returns the HTTP status of a post error, or its class if there was no response
+func postErrorCode(err error) string {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return strconv.Itoa(httpErr.Status)
+	}
+	return errorClass(err)
+}
+
+// recordPost records the outcome of a post of feed f, and the rate limit reported by the publisher
+func (fm *FeedsMonitor) recordPost(f *Feed, np namedPublisher, d time.Duration, err error) {
+	fm.posts.record(np.name, d, err)
+	if rl, ok := np.Publisher.(rateLimited); ok {
+		if n, ok := rl.RateLimitRemaining(); ok {
+			f.stats.setRemaining(np.name, n)
+		}
+	}
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func (fm *FeedsMonitor) MetricsHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() && !ctx.IsHead() {
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	fm.WriteMetrics(ctx)
+}
+
+// WriteMetrics writes the metrics of the monitor in the Prometheus text exposition format:
+// fetches by status code, not-modified ratio, parse errors, items per feed, post latency
+// and errors by publisher, rate limits, followers, cache statistics and the age of the
+// last check and of the last posted item.
+func (fm *FeedsMonitor) WriteMetrics(w io.Writer) error {
+	m := &metricsWriter{}
+	feeds := fm.feeds()
+
+	m.family("rss2masto_fetches_total", "counter", "Feed responses by HTTP status code.")
+	for _, f := range feeds {
+		f.stats.mu.Lock()
+		for _, code := range slices.Sorted(maps.Keys(f.stats.fetches)) {
+			m.sample("rss2masto_fetches_total", f.stats.fetches[code], "feed", f.label(), "code", strconv.Itoa(code))
+		}
+		f.stats.mu.Unlock()
+	}
+	m.family("rss2masto_fetch_not_modified_ratio", "gauge", "Share of feed responses that were 304 Not Modified.")
+	for _, f := range feeds {
+		f.stats.mu.Lock()
+		var total uint64
+		for _, n := range f.stats.fetches {
+			total += n
+		}
+		if total > 0 {
+			m.sample("rss2masto_fetch_not_modified_ratio", float64(f.stats.fetches[fasthttp.StatusNotModified])/float64(total), "feed", f.label())
+		}
+		f.stats.mu.Unlock()
+	}
+	feedCounters := []struct {
+		name, help string
+		value      func(s *feedStats) uint64
+	}{
+		{"rss2masto_fetch_errors_total", "Feed requests that failed without a response.", func(s *feedStats) uint64 { return s.fetchErrors }},
+		{"rss2masto_parse_errors_total", "Feed responses that couldn't be parsed or scraped.", func(s *feedStats) uint64 { return s.parseErrors }},
+		{"rss2masto_items_seen_total", "Items of fetched or pushed feeds.", func(s *feedStats) uint64 { return s.seen }},
+		{"rss2masto_items_skipped_total", "Items skipped as too old or already posted.", func(s *feedStats) uint64 { return s.skipped }},
+		{"rss2masto_items_deduped_total", "Items skipped as posted by another feed of the dedup group.", func(s *feedStats) uint64 { return s.deduped }},
+		{"rss2masto_items_posted_total", "Items posted by at least one publisher.", func(s *feedStats) uint64 { return s.posted }},
+	}
+	for _, c := range feedCounters {
+		m.family(c.name, "counter", c.help)
+		for _, f := range feeds {
+			f.stats.mu.Lock()
+			m.sample(c.name, c.value(&f.stats), "feed", f.label())
+			f.stats.mu.Unlock()
+		}
+	}
+
+	fm.posts.mu.Lock()
+	m.family("rss2masto_post_duration_seconds", "histogram", "Latency of successful posts by publisher.")
+	for _, name := range slices.Sorted(maps.Keys(fm.posts.duration)) {
+		h := fm.posts.duration[name]
+		var cumulative uint64
+		for i, le := range postBuckets {
+			cumulative += h.counts[i]
+			m.sample("rss2masto_post_duration_seconds_bucket", cumulative, "publisher", name, "le", formatFloat(le))
+		}
+		m.sample("rss2masto_post_duration_seconds_bucket", h.count, "publisher", name, "le", "+Inf")
+		m.sample("rss2masto_post_duration_seconds_sum", h.sum, "publisher", name)
+		m.sample("rss2masto_post_duration_seconds_count", h.count, "publisher", name)
+	}
+	m.family("rss2masto_post_errors_total", "counter", "Failed posts by publisher and HTTP status code, or error class without a response.")
+	errs := make([][2]string, 0, len(fm.posts.errors))
+	for key := range fm.posts.errors {
+		errs = append(errs, key)
+	}
+	slices.SortFunc(errs, func(a, b [2]string) int {
+		return strings.Compare(a[0]+"\x00"+a[1], b[0]+"\x00"+b[1])
+	})
+	for _, key := range errs {
+		m.sample("rss2masto_post_errors_total", fm.posts.errors[key], "publisher", key[0], "code", key[1])
+	}
+	fm.posts.mu.Unlock()
+
+	m.family("rss2masto_rate_limit_remaining", "gauge", "Requests left in the rate limit window of the account, as last reported by the instance.")
+	for _, f := range feeds {
+		f.stats.mu.Lock()
+		for _, name := range slices.Sorted(maps.Keys(f.stats.remaining)) {
+			m.sample("rss2masto_rate_limit_remaining", f.stats.remaining[name], "feed", f.label(), "publisher", name)
+		}
+		f.stats.mu.Unlock()
+	}
+	m.family("rss2masto_followers", "gauge", "Followers of the Mastodon account of the feed.")
+	for _, f := range feeds {
+		if f.accessToken() != "" {
+			m.sample("rss2masto_followers", f.Followers.Load(), "feed", f.label())
+		}
+	}
+
+	if Cache != nil {
+		stats := Cache.Stats()
+		m.family("rss2masto_cache_hits_total", "counter", "Idempotency keys found in Redis.")
+		m.sample("rss2masto_cache_hits_total", stats.Hits)
+		m.family("rss2masto_cache_misses_total", "counter", "Idempotency keys not found in Redis.")
+		m.sample("rss2masto_cache_misses_total", stats.Misses)
+		if pool := Cache.PoolStats(); pool != nil {
+			m.family("rss2masto_redis_pool_hits_total", "counter", "Free connections found in the Redis pool.")
+			m.sample("rss2masto_redis_pool_hits_total", pool.Hits)
+			m.family("rss2masto_redis_pool_misses_total", "counter", "Free connections not found in the Redis pool.")
+			m.sample("rss2masto_redis_pool_misses_total", pool.Misses)
+			m.family("rss2masto_redis_pool_timeouts_total", "counter", "Waits for a Redis pool connection that timed out.")
+			m.sample("rss2masto_redis_pool_timeouts_total", pool.Timeouts)
+			m.family("rss2masto_redis_pool_connections", "gauge", "Connections of the Redis pool.")
+			m.sample("rss2masto_redis_pool_connections", pool.TotalConns, "state", "total")
+			m.sample("rss2masto_redis_pool_connections", pool.IdleConns, "state", "idle")
+			m.family("rss2masto_redis_pool_stale_connections_total", "counter", "Stale connections removed from the Redis pool.")
+			m.sample("rss2masto_redis_pool_stale_connections_total", pool.StaleConns)
+		}
+	}
+
+	now := time.Now().Unix()
+	if last := fm.LastCheck(); last > 0 {
+		m.family("rss2masto_last_check_age_seconds", "gauge", "Seconds since a feed was last due for a check.")
+		m.sample("rss2masto_last_check_age_seconds", now-last)
+	}
+	if last := fm.LastMonit(); last > 0 {
+		m.family("rss2masto_last_monit_age_seconds", "gauge", "Seconds since the publication of the last posted item.")
+		m.sample("rss2masto_last_monit_age_seconds", now-last)
+	}
+
+	_, err := io.WriteString(w, m.String())
+	return err
+}
+
+// metricsWriter builds a Prometheus text exposition
+type metricsWriter struct {
+	strings.Builder
+}
+
+// family writes the HELP and TYPE lines of a metric
+func (m *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample with labels given as name, value pairs
+func (m *metricsWriter) sample(name string, value any, labels ...string) {
+	m.WriteString(name)
+	if len(labels) > 0 {
+		m.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.WriteByte(',')
+			}
+			m.WriteString(labels[i])
+			m.WriteString(`="`)
+			m.WriteString(labelEscaper.Replace(labels[i+1]))
+			m.WriteByte('"')
+		}
+		m.WriteByte('}')
+	}
+	m.WriteByte(' ')
+	switch v := value.(type) {
+	case float64:
+		m.WriteString(formatFloat(v))
+	default:
+		fmt.Fprint(m, v)
+	}
+	m.WriteByte('\n')
+}
+
+// labelEscaper escapes label values of the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,136 @@
+package rss2masto
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	const config = `instance:
+  url: https://national.example
+  limit: 500
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: national-token
+      targets:
+        - name: regional
+          token: regional-token
+          instance: regional
+instances:
+  - name: regional
+    url: https://regional.example
+    limit: 500
+`
+	fm := loadInstancesConfig(t, config)
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusNotModified)
+			return nil
+		},
+	})
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initTargets(); err != nil {
+		t.Fatal(err)
+	}
+	for name, mi := range fm.instances {
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				if name == "regional" {
+					resp.SetStatusCode(fasthttp.StatusServiceUnavailable)
+					return nil
+				}
+				resp.Header.Set("X-RateLimit-Remaining", "299")
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{"id":"1"}`)
+				return nil
+			},
+		}
+	}
+
+	f := fm.Instance.Feeds[0]
+	fm.setFeedDefaults(f)
+	fm.Parser.FetchAndParse(f)
+	f.Followers.Store(42)
+	fm.lastCheck.Store(time.Now().Add(-time.Minute).Unix())
+
+	now := time.Now()
+	old := now.Add(-24 * time.Hour)
+	feed := &gofeed.Feed{Items: []*gofeed.Item{
+		{Title: "Headline", Link: "https://example.com/a", GUID: fmt.Sprintf("metrics-test-%d", now.UnixNano()), PublishedParsed: &now},
+		{Title: "Old", Link: "https://example.com/old", GUID: "old", PublishedParsed: &old},
+	}}
+	debugMode = false
+	defer func() { debugMode = true }()
+	fm.processFeed(f, feed)
+
+	var buf bytes.Buffer
+	if err := fm.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE rss2masto_fetches_total counter\n",
+		`rss2masto_fetches_total{feed="News",code="304"} 1` + "\n",
+		`rss2masto_fetch_not_modified_ratio{feed="News"} 1` + "\n",
+		`rss2masto_parse_errors_total{feed="News"} 0` + "\n",
+		`rss2masto_items_seen_total{feed="News"} 2` + "\n",
+		`rss2masto_items_skipped_total{feed="News"} 1` + "\n",
+		`rss2masto_items_posted_total{feed="News"} 1` + "\n",
+		`rss2masto_post_duration_seconds_bucket{publisher="mastodon",le="+Inf"} 1` + "\n",
+		`rss2masto_post_duration_seconds_count{publisher="mastodon"} 1` + "\n",
+		`rss2masto_post_errors_total{publisher="@regional",code="503"} 1` + "\n",
+		`rss2masto_rate_limit_remaining{feed="News",publisher="mastodon"} 299` + "\n",
+		`rss2masto_followers{feed="News"} 42` + "\n",
+		"rss2masto_cache_hits_total ",
+		"rss2masto_last_check_age_seconds 6", // 60 or 61
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics without %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "rss2masto_last_monit_age_seconds") != (fm.LastMonit() > 0) {
+		t.Errorf("last monit age exported for LastMonit %d", fm.LastMonit())
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+	m := &metricsWriter{}
+	m.sample("m", 0.5, "feed", "a \"b\"\\\n")
+	if got := m.String(); got != `m{feed="a \"b\"\\\n"} 0.5`+"\n" {
+		t.Errorf("sample = %q", got)
+	}
+
+	var h histogram
+	for _, v := range []float64{0.05, 0.1, 3, 60} {
+		h.observe(v)
+	}
+	if want := []uint64{2, 0, 0, 0, 0, 1, 0}; fmt.Sprint(h.counts) != fmt.Sprint(want) || h.count != 4 {
+		t.Errorf("buckets = %v, count %d", h.counts, h.count)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	fm := &FeedsMonitor{}
+	var ctx fasthttp.RequestCtx
+	ctx.Request.SetRequestURI("/metrics")
+	fm.Handler()(&ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("disabled metrics status = %d", ctx.Response.StatusCode())
+	}
+
+	fm.Server.Metrics = true
+	ctx.Response.Reset()
+	fm.Handler()(&ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || !strings.HasPrefix(string(ctx.Response.Header.ContentType()), "text/plain; version=0.0.4") {
+		t.Errorf("metrics status = %d, content type %q", ctx.Response.StatusCode(), ctx.Response.Header.ContentType())
+	}
+}
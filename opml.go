@@ -0,0 +1,197 @@
+package rss2masto
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OPMLOptions controls how an OPML subscription list is imported
+type OPMLOptions struct {
+	Token      string // Mastodon token assigned to every imported feed, written to the configuration as is
+	TokenEnv   string // environment variable with the token of every imported feed, see Feed.TokenEnv
+	TokenFile  string // file with the token of every imported feed, see Feed.TokenFile
+	Replace    bool   // replace the current feeds instead of adding to them
+	CategoryAs string // map outline categories to "hashtag" (default) or "prefix"
+	Interval   int64  // check interval of imported feeds, DefaultCheckInterval if zero
+	Visibility string // visibility of imported feeds, private if empty
+}
+
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    struct {
+		Title       string `xml:"title,omitempty"`
+		DateCreated string `xml:"dateCreated,omitempty"`
+	} `xml:"head"`
+	Body struct {
+		Outlines []*opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlOutline struct {
+	Text     string         `xml:"text,attr"`
+	Title    string         `xml:"title,attr,omitempty"`
+	Type     string         `xml:"type,attr,omitempty"`
+	XMLURL   string         `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string         `xml:"htmlUrl,attr,omitempty"`
+	Category string         `xml:"category,attr,omitempty"`
+	Outlines []*opmlOutline `xml:"outline"`
+}
+
+// ImportOPML adds the feeds of an OPML subscription list to Instance.Feeds.
+// Outline titles become feed names, the host of the feed URL if an outline has
+// no title or text; a number is appended to names already in use. The first category of an outline (or the
+// title of the enclosing folder) becomes its hashtag or prefix, see OPMLOptions.
+// Imported feeds are added to the current ones, outlines whose URL matches any URL
+// of an existing feed are skipped; with Replace the current feeds are replaced.
+// Only the fields given in opts are set, the other settings keep their defaults.
+// It returns the number of imported feeds.
+func (fm *FeedsMonitor) ImportOPML(r io.Reader, opts OPMLOptions) (int, error) {
+	var doc opmlDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return 0, fmt.Errorf("invalid OPML: %w", err)
+	}
+
+	var feeds []*Feed
+	known := make(map[string]bool)
+	names := make(map[string]bool)
+	if !opts.Replace {
+		feeds = fm.Instance.Feeds
+		for _, feed := range feeds {
+			for _, url := range feed.URLs {
+				known[url] = true
+			}
+			names[cmp.Or(feed.Name, urlHost(feed.URL()))] = true
+		}
+	}
+
+	count := 0
+	var err error
+	var walk func(outlines []*opmlOutline, folder string)
+	walk = func(outlines []*opmlOutline, folder string) {
+		for _, o := range outlines {
+			name := cmp.Or(strings.TrimSpace(o.Title), strings.TrimSpace(o.Text))
+			if o.XMLURL == "" {
+				walk(o.Outlines, name)
+				continue
+			}
+			url := strings.TrimSpace(o.XMLURL)
+			if known[url] {
+				continue
+			}
+			known[url] = true
+
+			name = feedNameReplacer.Replace(cmp.Or(name, urlHost(url)))
+			if name == "" {
+				err = cmp.Or(err, fmt.Errorf("outline %q has no title, text or host", url))
+				continue
+			}
+			unique := name
+			for i := 2; names[unique]; i++ {
+				unique = fmt.Sprintf("%s (%d)", name, i)
+			}
+			names[unique] = true
+
+			feed := &Feed{
+				Name:       unique,
+				URLs:       FeedURLs{url},
+				Token:      opts.Token,
+				TokenEnv:   opts.TokenEnv,
+				TokenFile:  opts.TokenFile,
+				Interval:   opts.Interval,
+				Visibility: opts.Visibility,
+			}
+			if tag := opmlHashtag(o.Category, folder); tag != "" {
+				if opts.CategoryAs == "prefix" {
+					feed.Prefix = tag
+				} else {
+					feed.HashTag = tag
+				}
+			}
+			feeds = append(feeds, feed)
+			count++
+		}
+	}
+	walk(doc.Body.Outlines, "")
+	if err != nil {
+		return 0, fmt.Errorf("invalid OPML: %w", err)
+	}
+
+	fm.Instance.Feeds = feeds
+	return count, nil
+}
+
+// ExportOPML writes the configured feeds as an OPML 2.0 subscription list.
+// Hashtags (or prefixes, if no hashtag is set) are exported as categories.
+// Tokens are never exported. Scrape feeds are skipped with a warning:
+// OPML has no place for their rules, so they couldn't be imported again.
+func (fm *FeedsMonitor) ExportOPML(w io.Writer) error {
+	var doc opmlDocument
+	doc.Version = "2.0"
+	doc.Head.Title = "rss2masto feeds"
+	doc.Head.DateCreated = time.Now().UTC().Format(time.RFC1123Z)
+
+	for _, feed := range fm.Instance.Feeds {
+		if feed.URL() == "" {
+			continue
+		}
+		if feed.Source == SourceScrape {
+			fm.feedLog(feed).Warn("Scrape feed not exported to OPML")
+			continue
+		}
+		o := &opmlOutline{
+			Text:   feed.Name,
+			Title:  feed.Name,
+			Type:   "rss",
+			XMLURL: feed.URL(),
+		}
+		if feed.HashTag != "" {
+			o.Category = "/" + feed.HashTag
+		} else if feed.Prefix != "" {
+			o.Category = "/" + feed.Prefix
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, o)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// opmlHashtag converts the first OPML category (e.g. "/News/Local news,/Tech")
+// or else the folder title into a hashtag: the last path element without spaces.
+func opmlHashtag(category, folder string) string {
+	tag := folder
+	if category != "" {
+		first, _, _ := strings.Cut(category, ",")
+		first = strings.Trim(strings.TrimSpace(first), "/")
+		if i := strings.LastIndex(first, "/"); i >= 0 {
+			first = first[i+1:]
+		}
+		if first != "" {
+			tag = first
+		}
+	}
+	return strings.Join(strings.Fields(strings.TrimPrefix(tag, "#")), "")
+}
+
+// urlHost returns the host of a URL, "" if it has none
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,217 @@
+package rss2masto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportOPML(t *testing.T) {
+	newMonitor := func() *FeedsMonitor {
+		fm := &FeedsMonitor{}
+		existing := NewTestFeed("Existing", "https://existing.example.com/feed.xml")
+		existing.Token = "old-token"
+		fm.Instance.Feeds = []*Feed{existing}
+		return fm
+	}
+	importFile := func(fm *FeedsMonitor, opts OPMLOptions) int {
+		t.Helper()
+		f, err := os.Open("testdata/feeds.opml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		n, err := fm.ImportOPML(f, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		fm := newMonitor()
+		n := importFile(fm, OPMLOptions{Token: "default-token", Replace: true})
+
+		if n != 4 || len(fm.Instance.Feeds) != 4 {
+			t.Fatalf("imported %d, configured %d, want 4 and 4", n, len(fm.Instance.Feeds))
+		}
+		sport := fm.Instance.Feeds[0]
+		if sport.Name != "Sport PL" || sport.URL() != "https://sport.example.pl/rss" {
+			t.Errorf("feed = %q %q", sport.Name, sport.URL())
+		}
+		if sport.HashTag != "Sport" {
+			t.Errorf("HashTag = %q, want folder title", sport.HashTag)
+		}
+		if sport.Token != "default-token" || sport.Interval != 0 || sport.Visibility != "" {
+			t.Errorf("only the given options should be set: %q %d %q", sport.Token, sport.Interval, sport.Visibility)
+		}
+		if got := fm.Instance.Feeds[1].HashTag; got != "Hokejnalodzie" {
+			t.Errorf("HashTag = %q, want last element of the first category", got)
+		}
+		if got := fm.Instance.Feeds[2].HashTag; got != "Tech" {
+			t.Errorf("HashTag = %q, want Tech", got)
+		}
+		if fm.Instance.Feeds[3].Token != "default-token" {
+			t.Error("existing feed should be replaced")
+		}
+	})
+
+	t.Run("default adds and skips existing URLs", func(t *testing.T) {
+		fm := newMonitor()
+		n := importFile(fm, OPMLOptions{Token: "default-token", CategoryAs: "prefix"})
+
+		if n != 3 || len(fm.Instance.Feeds) != 4 {
+			t.Fatalf("imported %d, configured %d, want 3 and 4", n, len(fm.Instance.Feeds))
+		}
+		if fm.Instance.Feeds[0].Token != "old-token" {
+			t.Error("existing feed was modified")
+		}
+		if got := fm.Instance.Feeds[1].Prefix; got != "Sport" {
+			t.Errorf("Prefix = %q, want Sport", got)
+		}
+	})
+
+	t.Run("duplicate and missing names", func(t *testing.T) {
+		fm := newMonitor()
+		doc := `<opml version="2.0"><body>
+<outline type="rss" text="Existing" xmlUrl="https://other.example.com/feed.xml"/>
+<outline type="rss" text="News" xmlUrl="https://news.example.com/a.xml"/>
+<outline type="rss" text="News" xmlUrl="https://news.example.com/b.xml"/>
+<outline type="rss" text="" xmlUrl="https://blog.example.com/feed"/>
+</body></opml>`
+		if _, err := fm.ImportOPML(strings.NewReader(doc), OPMLOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range fm.Instance.Feeds {
+			names = append(names, f.Name)
+		}
+		if want := "Existing,Existing (2),News,News (2),blog.example.com"; strings.Join(names, ",") != want {
+			t.Errorf("names = %v, want %s", names, want)
+		}
+
+		if _, err := fm.ImportOPML(strings.NewReader(`<opml><body><outline xmlUrl="feed.xml"/></body></opml>`), OPMLOptions{}); err == nil {
+			t.Error("expected error for an outline without a name")
+		}
+	})
+
+	t.Run("invalid document", func(t *testing.T) {
+		fm := newMonitor()
+		if _, err := fm.ImportOPML(strings.NewReader("<html>"), OPMLOptions{}); err == nil {
+			t.Error("expected error")
+		}
+		if len(fm.Instance.Feeds) != 1 {
+			t.Error("feeds changed on error")
+		}
+	})
+}
+
+func TestExportOPML(t *testing.T) {
+	fm := &FeedsMonitor{}
+	fm.Instance.Feeds = []*Feed{
+		{Name: "Tech & News", URLs: FeedURLs{"https://example.com/rss?a=1&b=2"}, Token: "secret-token", HashTag: "Tech"},
+		{Name: "Local", URLs: FeedURLs{"https://news.example/"}, Prefix: "loc", Source: SourceScrape},
+		{Name: "Empty"},
+	}
+
+	var buf bytes.Buffer
+	if err := fm.ExportOPML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "secret-token") {
+		t.Error("token must not be exported")
+	}
+	if !strings.Contains(out, `xmlUrl="https://example.com/rss?a=1&amp;b=2"`) {
+		t.Errorf("missing escaped feed URL:\n%s", out)
+	}
+	if strings.Contains(out, "news.example") {
+		t.Errorf("scrape feed should be skipped:\n%s", out)
+	}
+
+	// round trip
+	imported := &FeedsMonitor{}
+	n, err := imported.ImportOPML(&buf, OPMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("round trip imported %d feeds, want 1", n)
+	}
+	feed := imported.Instance.Feeds[0]
+	if feed.Name != "Tech & News" || feed.URL() != "https://example.com/rss?a=1&b=2" || feed.HashTag != "Tech" {
+		t.Errorf("round trip feed = %q %q %q", feed.Name, feed.URL(), feed.HashTag)
+	}
+}
+
+func TestOPMLHashtag(t *testing.T) {
+	tests := []struct {
+		category, folder, want string
+	}{
+		{"", "", ""},
+		{"", "Local News", "LocalNews"},
+		{"/News/Local", "Folder", "Local"},
+		{"/Tech,/Go", "", "Tech"},
+		{"#Sport", "", "Sport"},
+		{"/", "Folder", "Folder"},
+	}
+	for _, tt := range tests {
+		if got := opmlHashtag(tt.category, tt.folder); got != tt.want {
+			t.Errorf("opmlHashtag(%q, %q) = %q, want %q", tt.category, tt.folder, got, tt.want)
+		}
+	}
+}
+
+func TestImportOPML_KeepsComments(t *testing.T) {
+	config := `# rss2masto configuration
+instance:
+  url: https://mastodon.example # main account
+  feed:
+    # hand-written entry
+    - name: Existing
+      url: https://existing.example.com/feed.xml
+      token: ${EXISTING_TOKEN} # from the environment
+      interval: 5
+`
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fm, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := `<opml version="2.0"><body>
+<outline type="rss" text="Existing" xmlUrl="https://existing.example.com/feed.xml"/>
+<outline type="rss" text="Tech" xmlUrl="https://tech.example.com/rss"/>
+</body></opml>`
+	if _, err := fm.ImportOPML(strings.NewReader(doc), OPMLOptions{TokenEnv: "TECH_TOKEN"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# rss2masto configuration", "# main account", "# hand-written entry", "token: ${EXISTING_TOKEN} # from the environment"} {
+		if !strings.Contains(string(saved), want) {
+			t.Errorf("saved configuration lost %q:\n%s", want, saved)
+		}
+	}
+	reloaded, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds := reloaded.Instance.Feeds
+	if len(feeds) != 2 || feeds[0].Interval != 5 || feeds[1].Name != "Tech" || feeds[1].TokenEnv != "TECH_TOKEN" {
+		t.Errorf("saved feeds = %+v, %+v:\n%s", feeds[0], feeds[len(feeds)-1], saved)
+	}
+}
@@ -0,0 +1,214 @@
+package rss2masto
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"html"
+	"mime/multipart"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+// ProfileConfig enables the sync of the profile of the feed account with the feed
+// metadata: display name, note, source link, image and bot flag
+type ProfileConfig struct {
+	Avatar bool           `yaml:"avatar,omitempty"` // upload the feed image as avatar
+	Header bool           `yaml:"header,omitempty"` // upload the feed image as header
+	Fields []ProfileField `yaml:"fields,omitempty"` // additional profile fields, after the Source field
+}
+
+// ProfileField is a name and value pair of the profile metadata
+type ProfileField struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// limits of the Mastodon profile
+const (
+	maxDisplayName   = 30
+	maxProfileNote   = 500
+	maxProfileFields = 4
+	maxFieldLength   = 255
+	maxProfileImage  = 2 << 20
+)
+
+// formData is an already encoded request body and its content type, e.g. multipart/form-data
+type formData struct {
+	contentType string
+	body        []byte
+}
+
+// profile is the profile of a feed account built from the feed metadata
+type profile struct {
+	name   string
+	note   string
+	fields []ProfileField
+	image  string // URL of the feed image, uploaded as avatar and/or header
+	avatar bool
+	header bool
+}
+
+// newProfile returns the profile of the feed account
+func newProfile(f *Feed, cfg *ProfileConfig, feed *gofeed.Feed) *profile {
+	p := &profile{
+		name:   truncateRunes(strings.TrimSpace(html.UnescapeString(feed.Title)), maxDisplayName),
+		note:   truncateRunes(html.UnescapeString(strings.TrimSpace(strictPolicy.Sanitize(feed.Description))), maxProfileNote),
+		avatar: cfg.Avatar,
+		header: cfg.Header,
+	}
+	if feed.Image != nil && (cfg.Avatar || cfg.Header) {
+		p.image = feed.Image.URL
+	}
+	p.fields = append(p.fields, ProfileField{Name: "Source", Value: cmp.Or(feed.Link, f.URL())})
+	p.fields = append(p.fields, cfg.Fields...)
+	if len(p.fields) > maxProfileFields {
+		p.fields = p.fields[:maxProfileFields]
+	}
+	for i := range p.fields {
+		p.fields[i].Name = truncateRunes(p.fields[i].Name, maxFieldLength)
+		p.fields[i].Value = truncateRunes(p.fields[i].Value, maxFieldLength)
+	}
+	return p
+}
+
+// hash identifies the content of the profile. The image is identified by its URL.
+func (p *profile) hash() string {
+	var b strings.Builder
+	b.WriteString(p.name)
+	b.WriteByte(0)
+	b.WriteString(p.note)
+	for _, fl := range p.fields {
+		b.WriteByte(0)
+		b.WriteString(fl.Name)
+		b.WriteByte(0)
+		b.WriteString(fl.Value)
+	}
+	fmt.Fprintf(&b, "\x00%s\x00%t\x00%t", p.image, p.avatar, p.header)
+	return hashString(b.String())
+}
+
+// truncateRunes shortens s to n characters, ending with an ellipsis if it was cut
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(append(r[:n-1], '…'))
+}
+
+// profileRetryDelay is the time before a failed profile update is tried again,
+// unless the feed metadata or the profile block changed
+const profileRetryDelay = 24 * time.Hour
+
+// syncProfile updates the profile of the feed account from the feed metadata, if the
+// feed has a profile block and the metadata changed since the last sync.
+// The image is downloaded and the profile updated without holding f.mu.
+func (fm *FeedsMonitor) syncProfile(f *Feed, feed *gofeed.Feed, mi *MastodonInstance) error {
+	token := f.accessToken()
+	if mi == nil || token == "" {
+		return nil
+	}
+	f.mu.Lock()
+	if f.Profile == nil {
+		f.mu.Unlock()
+		return nil
+	}
+	p := newProfile(f, f.Profile, feed)
+	hash := p.hash()
+	skip := hash == f.profile || (hash == f.profileErr && time.Now().Before(f.profileDue))
+	f.mu.Unlock()
+	if skip {
+		return nil
+	}
+
+	err := fm.updateProfile(p, mi, token)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.profileErr, f.profileDue = hash, time.Now().Add(profileRetryDelay)
+		return err
+	}
+	f.profile, f.profileErr = hash, ""
+	fm.feedLog(f).Info("Profile updated", "display_name", p.name, "image", p.image != "")
+	return nil
+}
+
+// updateProfile sends the profile to the account of token, with the feed image if enabled
+func (fm *FeedsMonitor) updateProfile(p *profile, mi *MastodonInstance, token string) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if p.name != "" {
+		w.WriteField("display_name", p.name)
+	}
+	w.WriteField("note", p.note)
+	w.WriteField("bot", "true")
+	for i, fl := range p.fields {
+		w.WriteField(fmt.Sprintf("fields_attributes[%d][name]", i), fl.Name)
+		w.WriteField(fmt.Sprintf("fields_attributes[%d][value]", i), fl.Value)
+	}
+	if p.image != "" {
+		img, contentType, err := fetchImage(fm.webClient(), p.image)
+		if err != nil {
+			return fmt.Errorf("profile image: %w", err)
+		}
+		var uploads []string
+		if p.avatar {
+			uploads = append(uploads, "avatar")
+		}
+		if p.header {
+			uploads = append(uploads, "header")
+		}
+		for _, field := range uploads {
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, cmp.Or(path.Base(p.image), field)))
+			h.Set("Content-Type", contentType)
+			part, err := w.CreatePart(h)
+			if err != nil {
+				return err
+			}
+			part.Write(img)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	_, err := sendJSON(mi.client, fasthttp.MethodPatch, mi.URL+"/api/v1/accounts/update_credentials",
+		&formData{contentType: w.FormDataContentType(), body: body.Bytes()}, "Authorization", "Bearer "+token)
+	return err
+}
+
+// fetchImage downloads an image of at most maxProfileImage bytes and returns it with its content type
+func fetchImage(client httpClient, url string) ([]byte, string, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod(fasthttp.MethodGet)
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.Header.Set("Accept", "image/*")
+	if err := client.Do(req, resp); err != nil {
+		return nil, "", redactError(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, "", errors.New("returned status: " + strconv.Itoa(resp.StatusCode()))
+	}
+	contentType := string(resp.Header.ContentType())
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("unexpected content type %q", contentType)
+	}
+	if len(resp.Body()) > maxProfileImage {
+		return nil, "", fmt.Errorf("image larger than %d bytes", maxProfileImage)
+	}
+	return append([]byte(nil), resp.Body()...), contentType, nil
+}
@@ -0,0 +1,155 @@
+package rss2masto
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+func TestNewProfile(t *testing.T) {
+	f := NewTestFeed("News", "https://example.com/feed.xml")
+	cfg := &ProfileConfig{Fields: []ProfileField{{"A", "1"}, {"B", "2"}, {"C", "3"}, {"D", "4"}}}
+	feed := &gofeed.Feed{
+		Title:       "The Daily &amp; Weekly News of Everywhere",
+		Description: "<p>All the <b>news</b></p>",
+		Image:       &gofeed.Image{URL: "https://example.com/logo.png"},
+	}
+	p := newProfile(f, cfg, feed)
+	if p.name != "The Daily & Weekly News of Ev…" || p.note != "All the news" {
+		t.Errorf("name = %q, note = %q", p.name, p.note)
+	}
+	if len(p.fields) != maxProfileFields || p.fields[0] != (ProfileField{"Source", "https://example.com/feed.xml"}) {
+		t.Errorf("fields = %v", p.fields)
+	}
+	if p.image != "" {
+		t.Errorf("image %q without avatar or header", p.image)
+	}
+	h := p.hash()
+	cfg.Avatar = true
+	if newProfile(f, cfg, feed).hash() == h {
+		t.Error("hash unchanged by the avatar upload")
+	}
+}
+
+func TestSyncProfile(t *testing.T) {
+	fm := loadInstancesConfig(t, `instance:
+  url: https://national.example
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: news-token
+      profile:
+        avatar: true
+        fields:
+          - name: Bot by
+            value: "@ops@national.example"
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	var f *Feed
+	images := 0
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			images++
+			// the feed isn't locked during the network calls
+			if !f.mu.TryLock() {
+				t.Error("image downloaded holding the feed lock")
+			} else {
+				f.mu.Unlock()
+			}
+			if strings.HasSuffix(string(req.URI().Path()), "/broken.png") {
+				resp.SetStatusCode(fasthttp.StatusNotFound)
+				return nil
+			}
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.Header.SetContentType("image/png")
+			resp.SetBodyString("PNG")
+			return nil
+		},
+	})
+	var forms []*multipart.Form
+	fm.instances[""].client = &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			if string(req.Header.Method()) != fasthttp.MethodPatch || string(req.URI().Path()) != "/api/v1/accounts/update_credentials" ||
+				string(req.Header.Peek("Authorization")) != "Bearer news-token" {
+				t.Errorf("unexpected request %s %s", req.Header.Method(), req.URI())
+			}
+			_, params, _ := mime.ParseMediaType(string(req.Header.ContentType()))
+			form, err := multipart.NewReader(bytes.NewReader(req.Body()), params["boundary"]).ReadForm(1 << 20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forms = append(forms, form)
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.SetBodyString(`{"id":"1"}`)
+			return nil
+		},
+	}
+	f = fm.Instance.Feeds[0]
+	fm.setFeedDefaults(f)
+	feed := &gofeed.Feed{
+		Title:       "Daily News",
+		Description: "News of the day",
+		Link:        "https://example.com/",
+		Image:       &gofeed.Image{URL: "https://example.com/logo.png"},
+	}
+	mi := fm.feedInstance(f)
+
+	for range 2 {
+		if err := fm.syncProfile(f, feed, mi); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(forms) != 1 {
+		t.Fatalf("%d updates of an unchanged profile", len(forms))
+	}
+	form := forms[0]
+	value := func(key string) string { return strings.Join(form.Value[key], ",") }
+	if value("display_name") != "Daily News" || value("note") != "News of the day" || value("bot") != "true" ||
+		value("fields_attributes[0][name]") != "Source" || value("fields_attributes[0][value]") != "https://example.com/" ||
+		value("fields_attributes[1][name]") != "Bot by" {
+		t.Errorf("form values = %v", form.Value)
+	}
+	if len(form.File["avatar"]) != 1 || len(form.File["header"]) != 0 {
+		t.Fatalf("form files = %v", form.File)
+	}
+	fh := form.File["avatar"][0]
+	r, _ := fh.Open()
+	img, _ := io.ReadAll(r)
+	if fh.Filename != "logo.png" || fh.Header.Get("Content-Type") != "image/png" || string(img) != "PNG" {
+		t.Errorf("avatar %s %s %q", fh.Filename, fh.Header.Get("Content-Type"), img)
+	}
+
+	feed.Title = "Daily News Extra"
+	if err := fm.syncProfile(f, feed, mi); err != nil {
+		t.Fatal(err)
+	}
+	if len(forms) != 2 || strings.Join(forms[1].Value["display_name"], "") != "Daily News Extra" {
+		t.Errorf("changed title not synced: %d updates", len(forms))
+	}
+	if state := fm.collectState(); state.Feeds["News"].Profile != f.profile || f.profile == "" {
+		t.Errorf("profile hash %q not saved with the state", f.profile)
+	}
+
+	// a failed update isn't retried until the profile changes or the retry delay passed
+	feed.Image.URL = "https://example.com/broken.png"
+	images = 0
+	if err := fm.syncProfile(f, feed, mi); err == nil {
+		t.Fatal("broken image accepted")
+	}
+	if err := fm.syncProfile(f, feed, mi); err != nil || images != 1 {
+		t.Fatalf("failed update retried at once: %d image requests, %v", images, err)
+	}
+	f.profileDue = time.Now()
+	if err := fm.syncProfile(f, feed, mi); err == nil || images != 2 {
+		t.Errorf("failed update not retried after the delay: %d image requests, %v", images, err)
+	}
+}
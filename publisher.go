@@ -0,0 +1,224 @@
+package rss2masto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// Publisher publishes feed items to a social network or another service.
+// Implementations must be safe for concurrent use.
+type Publisher interface {
+	// Publish creates a new post
+	Publish(p *Post) (*Published, error)
+	// Edit replaces the content of a post created by Publish
+	Edit(id string, p *Post) error
+	// Delete removes a post created by Publish
+	Delete(id string) error
+	// Capabilities describes the limits and features of the backend
+	Capabilities() Capabilities
+}
+
+// Post is a feed item rendered for publishing
+type Post struct {
+	Text           string // complete message: title, description, hashtags and link
+	Title          string // item title
+	Description    string // sanitized (and possibly truncated) item description
+	Hashtags       string // space separated hashtags, e.g. "#News #Local"
+	Link           string // item link
+	Image          string // URL of the item image, if any
+	Language       string // ISO 639-1 language code, if known
+	Visibility     string // Mastodon visibility: public, unlisted or private
+	IdempotencyKey string // stable key of the item, used to avoid duplicates on retries
+}
+
+// Published identifies a post created by a Publisher
+type Published struct {
+	ID  string // backend specific post ID, used by Edit and Delete
+	URL string // public URL of the post, if known
+}
+
+// Capabilities describes what a Publisher backend supports
+type Capabilities struct {
+	MaxLength int  // maximum post length, 0 if unlimited
+	Media     bool // Post.Image is attached to the post
+	Edit      bool // posts can be edited
+	Delete    bool // posts can be deleted
+}
+
+// PublisherConfig configures a named publisher in the publishers list of the configuration
+type PublisherConfig struct {
+	Name       string            `yaml:"name"`                 // name referenced by Feed.Publish
+	Type       string            `yaml:"type"`                 // bluesky or webhook
+	URL        string            `yaml:"url,omitempty"`        // PDS URL (default https://bsky.social) or webhook endpoint
+	Identifier string            `yaml:"identifier,omitempty"` // Bluesky handle or DID
+	Password   string            `yaml:"password,omitempty"`   // Bluesky app password, ${VAR} references are expanded
+	Secret     string            `yaml:"secret,omitempty"`     // webhook HMAC-SHA256 secret, ${VAR} references are expanded
+	Headers    map[string]string `yaml:"headers,omitempty"`    // extra webhook request headers, ${VAR} references are expanded
+	Limit      int               `yaml:"limit,omitempty"`      // maximum post length, overrides the backend default
+
+	PasswordFile string `yaml:"password_file,omitempty"` // file containing the password, overrides Password
+	PasswordEnv  string `yaml:"password_env,omitempty"`  // environment variable containing the password, overrides Password
+	SecretFile   string `yaml:"secret_file,omitempty"`   // file containing the webhook secret, overrides Secret
+	SecretEnv    string `yaml:"secret_env,omitempty"`    // environment variable containing the webhook secret, overrides Secret
+
+	// resolved secrets, see FeedsMonitor.resolveSecrets
+	password string
+	secret   string
+	headers  map[string]string
+}
+
+// Publisher types supported in PublisherConfig.Type
+const (
+	PublisherBluesky = "bluesky"
+	PublisherWebhook = "webhook"
+)
+
+// ErrNotSupported is returned by publishers for operations the backend doesn't support
+var ErrNotSupported = errors.New("operation not supported")
+
+// namedPublisher is a publisher used by a feed, with its name used in logs and idempotency keys
+type namedPublisher struct {
+	name   string
+	target *Target // overrides of a feed target, nil for other publishers
+	Publisher
+}
+
+// NewPublisher creates a publisher from its configuration.
+// Requests are sent with the given client, which must accept absolute URLs of any host.
+func NewPublisher(cfg *PublisherConfig, client httpClient) (Publisher, error) {
+	switch cfg.Type {
+	case PublisherBluesky:
+		return NewBlueskyPublisher(cfg, client), nil
+	case PublisherWebhook:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("publisher %s: missing url", cfg.Name)
+		}
+		return NewWebhookPublisher(cfg, client), nil
+	}
+	return nil, fmt.Errorf("publisher %s: unknown type %q", cfg.Name, cfg.Type)
+}
+
+// initPublishers creates the publishers listed in the configuration
+func (fm *FeedsMonitor) initPublishers() error {
+	fm.publishers = make(map[string]Publisher, len(fm.Publishers))
+	for _, cfg := range fm.Publishers {
+		if cfg.Name == "" || cfg.Name == mastodonPublisherName || strings.HasPrefix(cfg.Name, targetPublisherPrefix) {
+			return fmt.Errorf("invalid publisher name %q", cfg.Name)
+		}
+		p, err := NewPublisher(cfg, fm.Parser.Client)
+		if err != nil {
+			return err
+		}
+		fm.publishers[cfg.Name] = p
+	}
+	return nil
+}
+
+// feedPublishers returns the publishers of a feed: its Mastodon account on the
+// feed's instance (if the feed has a token), the accounts of its targets
+// and the named publishers in Feed.Publish.
+func (fm *FeedsMonitor) feedPublishers(f *Feed) []namedPublisher {
+	var list []namedPublisher
+	if f.accessToken() != "" {
+		if mi := fm.feedInstance(f); mi != nil {
+			list = append(list, namedPublisher{
+				name:      mastodonPublisherName,
+				Publisher: mi.publisher(f.accessToken()),
+			})
+		} else {
+			fm.feedLog(f).Error("Unknown instance", "instance", f.instanceName(), "error_class", errClassConfig)
+		}
+	}
+	list = append(list, fm.targetPublishers(f)...)
+	for _, name := range f.Publish {
+		p, ok := fm.publishers[name]
+		if !ok {
+			fm.feedLog(f).Error("Unknown publisher", "publisher", name, "error_class", errClassConfig)
+			continue
+		}
+		list = append(list, namedPublisher{name: name, Publisher: p})
+	}
+	return list
+}
+
+// idempotencyKey returns the key used to deduplicate an item for this publisher:
+// "<namespace>:<hash>" for the Mastodon account, "<namespace>:<publisher>:<hash>" for others.
+func (np namedPublisher) idempotencyKey(f *Feed, guid string) string {
+	if np.name == mastodonPublisherName {
+		return f.namespace() + ":" + hashString(guid)
+	}
+	return f.namespace() + ":" + np.name + ":" + hashString(guid)
+}
+
+// httpError is returned for non-2xx responses of publishing backends
+type httpError struct {
+	Status     int
+	Body       string
+	RetryAfter string
+}
+
+func (e *httpError) Error() string {
+	if e.Status == fasthttp.StatusTooManyRequests {
+		return fmt.Sprintf("rate limited, retry after: %s seconds", e.RetryAfter)
+	}
+	if e.Status < fasthttp.StatusInternalServerError && e.Body != "" {
+		return fmt.Sprintf("returned status: %d [%s]", e.Status, redact(e.Body))
+	}
+	return fmt.Sprintf("returned status: %d", e.Status)
+}
+
+// sendJSON sends a request with an optional JSON payload and returns the body of a 2xx response.
+// The payload is encoded with jsoniter unless it is already encoded ([]byte, or *formData of another content type).
+// headers are given as key, value pairs.
+func sendJSON(client httpClient, method, target string, payload any, headers ...string) ([]byte, error) {
+	return doJSON(client, method, target, payload, nil, headers...)
+}
+
+// doJSON is sendJSON calling onResponse, if not nil, with every response received (e.g. to read its headers)
+func doJSON(client httpClient, method, target string, payload any, onResponse func(*fasthttp.Response), headers ...string) ([]byte, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(target)
+	req.Header.SetMethod(method)
+	req.Header.Set("Accept", "application/json")
+	switch body := payload.(type) {
+	case nil:
+	case []byte:
+		req.Header.SetContentType("application/json")
+		req.SetBody(body)
+	case *formData:
+		req.Header.SetContentType(body.contentType)
+		req.SetBody(body.body)
+	default:
+		req.Header.SetContentType("application/json")
+		// Writing directly to BodyWriter() saves one []byte allocation
+		if err := jsoniter.ConfigDefault.NewEncoder(req.BodyWriter()).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	if err := client.Do(req, resp); err != nil {
+		return nil, redactError(err)
+	}
+	if onResponse != nil {
+		onResponse(resp)
+	}
+	if status := resp.StatusCode(); status < 200 || status > 299 {
+		return nil, &httpError{
+			Status:     status,
+			Body:       strings.TrimSpace(string(resp.Body())),
+			RetryAfter: string(resp.Header.Peek("Retry-After")),
+		}
+	}
+	return append([]byte(nil), resp.Body()...), nil
+}
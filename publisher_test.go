@@ -0,0 +1,372 @@
+package rss2masto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+// recordedRequest is a request received by a test server
+type recordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// newRecordingServer starts an httptest server recording requests and answering with handler
+func newRecordingServer(t *testing.T, handler func(r *recordedRequest, w http.ResponseWriter)) (*httptest.Server, func() []*recordedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []*recordedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec := &recordedRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header, Body: body}
+		mu.Lock()
+		requests = append(requests, rec)
+		mu.Unlock()
+		handler(rec, w)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []*recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*recordedRequest(nil), requests...)
+	}
+}
+
+func testPost() *Post {
+	return &Post{
+		Text:           "Title\n\nDescription\n\n#News #Local\n\nhttps://example.com/a",
+		Title:          "Title",
+		Description:    "Description",
+		Hashtags:       "#News #Local",
+		Link:           "https://example.com/a",
+		Image:          "https://example.com/a.jpg",
+		Language:       "en",
+		Visibility:     "unlisted",
+		IdempotencyKey: "te:123",
+	}
+}
+
+func TestMastodonPublisher(t *testing.T) {
+	srv, requests := newRecordingServer(t, func(r *recordedRequest, w http.ResponseWriter) {
+		if r.Path == "/api/v1/statuses/429" {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"id":"110","url":"https://masto.example/@bot/110"}`))
+	})
+	m := NewMastodonPublisher(srv.URL+"/", "secret-token", 500, &fasthttp.Client{})
+
+	published, err := m.Publish(testPost())
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if published.ID != "110" || published.URL != "https://masto.example/@bot/110" {
+		t.Errorf("Publish() = %+v", published)
+	}
+	if err := m.Edit("110", testPost()); err != nil {
+		t.Errorf("Edit() error = %v", err)
+	}
+	if err := m.Delete("110"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 3 {
+		t.Fatalf("got %d requests, want 3", len(reqs))
+	}
+	create := reqs[0]
+	if create.Method != http.MethodPost || create.Path != "/api/v1/statuses" {
+		t.Errorf("publish request = %s %s", create.Method, create.Path)
+	}
+	if create.Header.Get("Authorization") != "Bearer secret-token" || create.Header.Get("Idempotency-Key") != "te:123" {
+		t.Errorf("publish headers = %v", create.Header)
+	}
+	var post MastodonPost
+	if err := jsoniter.Unmarshal(create.Body, &post); err != nil {
+		t.Fatal(err)
+	}
+	if post.Status != testPost().Text || post.Visibility != "unlisted" || post.Language != "en" {
+		t.Errorf("posted status = %+v", post)
+	}
+	if reqs[1].Method != http.MethodPut || reqs[1].Path != "/api/v1/statuses/110" {
+		t.Errorf("edit request = %s %s", reqs[1].Method, reqs[1].Path)
+	}
+	if reqs[2].Method != http.MethodDelete || reqs[2].Path != "/api/v1/statuses/110" {
+		t.Errorf("delete request = %s %s", reqs[2].Method, reqs[2].Path)
+	}
+
+	err = m.Delete("429")
+	if err == nil || !strings.Contains(err.Error(), "retry after: 30") {
+		t.Errorf("Delete() error = %v, want rate limit error", err)
+	}
+}
+
+func TestBlueskyPublisher(t *testing.T) {
+	var mu sync.Mutex
+	expired := false
+	srv, requests := newRecordingServer(t, func(r *recordedRequest, w http.ResponseWriter) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			w.Write([]byte(`{"did":"did:plc:abc","accessJwt":"access-1","refreshJwt":"refresh-1"}`))
+		case "/xrpc/com.atproto.server.refreshSession":
+			if r.Header.Get("Authorization") != "Bearer refresh-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"did":"did:plc:abc","accessJwt":"access-2","refreshJwt":"refresh-2"}`))
+		case "/xrpc/com.atproto.repo.createRecord", "/xrpc/com.atproto.repo.deleteRecord":
+			if expired && r.Header.Get("Authorization") == "Bearer access-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"ExpiredToken","message":"Token has expired"}`))
+				return
+			}
+			w.Write([]byte(`{"uri":"at://did:plc:abc/app.bsky.feed.post/3kxyz","cid":"bafy"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	b := NewBlueskyPublisher(&PublisherConfig{
+		Name:       "bsky",
+		Type:       PublisherBluesky,
+		URL:        srv.URL,
+		Identifier: "bot.example.com",
+		Password:   "app-password",
+	}, &fasthttp.Client{})
+
+	if caps := b.Capabilities(); caps.MaxLength != 300 || caps.Edit {
+		t.Errorf("Capabilities() = %+v", caps)
+	}
+
+	published, err := b.Publish(testPost())
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if published.ID != "at://did:plc:abc/app.bsky.feed.post/3kxyz" ||
+		published.URL != "https://bsky.app/profile/did:plc:abc/post/3kxyz" {
+		t.Errorf("Publish() = %+v", published)
+	}
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+	session := reqs[0].Body
+	if jsoniter.Get(session, "identifier").ToString() != "bot.example.com" ||
+		jsoniter.Get(session, "password").ToString() != "app-password" {
+		t.Errorf("createSession body = %s", session)
+	}
+	create := reqs[1]
+	if create.Header.Get("Authorization") != "Bearer access-1" {
+		t.Errorf("createRecord Authorization = %q", create.Header.Get("Authorization"))
+	}
+	var body struct {
+		Repo       string        `json:"repo"`
+		Collection string        `json:"collection"`
+		Record     blueskyRecord `json:"record"`
+	}
+	if err := jsoniter.Unmarshal(create.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Repo != "did:plc:abc" || body.Collection != "app.bsky.feed.post" {
+		t.Errorf("createRecord repo = %q, collection = %q", body.Repo, body.Collection)
+	}
+	if body.Record.Text != testPost().Text || len(body.Record.Langs) != 1 || body.Record.Langs[0] != "en" {
+		t.Errorf("record = %+v", body.Record)
+	}
+	if len(body.Record.Facets) != 3 {
+		t.Errorf("got %d facets, want 3 (link and two tags)", len(body.Record.Facets))
+	}
+	if body.Record.Embed == nil || body.Record.Embed.External.URI != "https://example.com/a" || body.Record.Embed.External.Title != "Title" {
+		t.Errorf("embed = %+v", body.Record.Embed)
+	}
+
+	t.Run("expired token is refreshed", func(t *testing.T) {
+		mu.Lock()
+		expired = true
+		mu.Unlock()
+		if err := b.Delete(published.ID); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		reqs := requests()[2:]
+		if len(reqs) != 3 || reqs[1].Path != "/xrpc/com.atproto.server.refreshSession" || reqs[2].Header.Get("Authorization") != "Bearer access-2" {
+			for _, r := range reqs {
+				t.Logf("%s %s", r.Path, r.Header.Get("Authorization"))
+			}
+			t.Fatal("expected deleteRecord to be retried with a refreshed token")
+		}
+		if rkey := jsoniter.Get(reqs[2].Body, "rkey").ToString(); rkey != "3kxyz" {
+			t.Errorf("deleteRecord rkey = %q", rkey)
+		}
+	})
+
+	if err := b.Edit(published.ID, testPost()); err != ErrNotSupported {
+		t.Errorf("Edit() error = %v, want ErrNotSupported", err)
+	}
+	if err := b.Delete("https://bsky.app/x"); err == nil {
+		t.Error("Delete() accepted an invalid URI")
+	}
+}
+
+func TestBlueskyFacets(t *testing.T) {
+	text := "Zażółć gęślą jaźń\n\n#Łódź #News\n\nhttps://example.com/ż"
+	facets := blueskyFacets(text, "https://example.com/ż", "#Łódź #News")
+	if len(facets) != 3 {
+		t.Fatalf("got %d facets, want 3", len(facets))
+	}
+	want := []string{"https://example.com/ż", "#Łódź", "#News"}
+	for i, f := range facets {
+		got := text[f.Index.ByteStart:f.Index.ByteEnd]
+		if got != want[i] {
+			t.Errorf("facet %d covers %q, want %q", i, got, want[i])
+		}
+	}
+	if facets[1].Features[0].Tag != "Łódź" {
+		t.Errorf("tag = %q, want Łódź", facets[1].Features[0].Tag)
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	srv, requests := newRecordingServer(t, func(r *recordedRequest, w http.ResponseWriter) {
+		if jsoniter.Get(r.Body, "event").ToString() == webhookPublish {
+			w.Write([]byte(`{"id":"evt-1","url":"https://chat.example/evt-1"}`))
+		}
+	})
+	p, err := NewPublisher(&PublisherConfig{
+		Name:    "matrix",
+		Type:    PublisherWebhook,
+		URL:     srv.URL + "/hook",
+		Secret:  "hook-secret",
+		Headers: map[string]string{"X-Room": "!news"},
+	}, &fasthttp.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published, err := p.Publish(testPost())
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if published.ID != "evt-1" || published.URL != "https://chat.example/evt-1" {
+		t.Errorf("Publish() = %+v", published)
+	}
+	if err := p.Delete(published.ID); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+	r := reqs[0]
+	mac := hmac.New(sha256.New, []byte("hook-secret"))
+	mac.Write(r.Body)
+	if sig := r.Header.Get("X-Signature"); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("X-Signature = %q", sig)
+	}
+	if r.Path != "/hook" || r.Header.Get("X-Room") != "!news" {
+		t.Errorf("request = %s, headers %v", r.Path, r.Header)
+	}
+	var payload webhookPayload
+	if err := jsoniter.Unmarshal(r.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Text != testPost().Text || payload.Image != "https://example.com/a.jpg" || payload.IdempotencyKey != "te:123" {
+		t.Errorf("payload = %+v", payload)
+	}
+	if event, id := jsoniter.Get(reqs[1].Body, "event").ToString(), jsoniter.Get(reqs[1].Body, "id").ToString(); event != webhookDelete || id != "evt-1" {
+		t.Errorf("delete payload = %s", reqs[1].Body)
+	}
+}
+
+func TestNewPublisher_Errors(t *testing.T) {
+	if _, err := NewPublisher(&PublisherConfig{Name: "x", Type: "misskey"}, nil); err == nil {
+		t.Error("unknown type accepted")
+	}
+	if _, err := NewPublisher(&PublisherConfig{Name: "x", Type: PublisherWebhook}, nil); err == nil {
+		t.Error("webhook without url accepted")
+	}
+
+	fm := &FeedsMonitor{Parser: NewParser(nil)}
+	fm.Publishers = []*PublisherConfig{{Name: mastodonPublisherName, Type: PublisherWebhook, URL: "https://example.com"}}
+	if err := fm.initPublishers(); err == nil {
+		t.Error("reserved publisher name accepted")
+	}
+}
+
+func TestFeedPublishers(t *testing.T) {
+	fm := &FeedsMonitor{Parser: NewParser(nil)}
+	fm.Publishers = []*PublisherConfig{
+		{Name: "bsky", Type: PublisherBluesky},
+		{Name: "hook", Type: PublisherWebhook, URL: "https://example.com/hook"},
+	}
+	if err := fm.initPublishers(); err != nil {
+		t.Fatal(err)
+	}
+
+	feed := NewTestFeed("test", "https://example.com/feed")
+	feed.Token = "token"
+	feed.Publish = []string{"hook", "unknown", "bsky"}
+
+	var names []string
+	for _, np := range fm.feedPublishers(feed) {
+		names = append(names, np.name)
+	}
+	if got := strings.Join(names, ","); got != "mastodon,hook,bsky" {
+		t.Errorf("feedPublishers() = %s, want mastodon,hook,bsky", got)
+	}
+
+	list := fm.feedPublishers(feed)
+	if key := list[0].idempotencyKey(feed, "guid"); key != feed.namespace()+":"+hashString("guid") {
+		t.Errorf("mastodon key = %q, want <namespace>:<hash>", key)
+	}
+	if key := list[1].idempotencyKey(feed, "guid"); key != feed.namespace()+":hook:"+hashString("guid") {
+		t.Errorf("webhook key = %q", key)
+	}
+	// the Mastodon publisher of an initialised instance, and the rate limit it tracks, is kept between runs
+	fm.Instance.URL = "https://mastodon.example"
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if fm.feedPublishers(feed)[0].Publisher != fm.feedPublishers(feed)[0].Publisher {
+		t.Error("Mastodon publisher created again")
+	}
+}
+
+func TestSanitizeMessage_Limit(t *testing.T) {
+	item := &gofeed.Item{
+		Title:       "A title",
+		Description: "<p>" + strings.Repeat("word ", 100) + "</p>",
+		Link:        "https://example.com/a",
+	}
+
+	_, description := sanitizeMessage(item, 0, 0)
+	if strings.HasSuffix(description, "[...]") {
+		t.Error("description truncated without a limit")
+	}
+
+	title, description := sanitizeMessage(item, 10, 150)
+	if len(title)+len(description)+10+len(item.Link) > 150 || !strings.HasSuffix(description, " [...]") {
+		t.Errorf("description not shortened to the limit: %q", description)
+	}
+
+	// a limit too small for any description must not panic
+	if _, description := sanitizeMessage(item, 10, 40); description != "" {
+		t.Errorf("description = %q, want empty", description)
+	}
+}
@@ -0,0 +1,346 @@
+package rss2masto
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ReloadResult lists the names of the feeds changed by Reload
+type ReloadResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// reloadDebounce is the quiet period after the last change of the watched configuration file
+const reloadDebounce = 500 * time.Millisecond
+
+// feeds returns the current list of feeds; the list is replaced, never modified, by Reload
+func (fm *FeedsMonitor) feeds() []*Feed {
+	fm.configMu.RLock()
+	defer fm.configMu.RUnlock()
+	return fm.Instance.Feeds
+}
+
+// Reload reads the configuration file again and applies it to the running monitor.
+// Feeds are matched by name, then by URL:
+// - new feeds are added and their credentials verified with updateFeedData
+// - feeds missing from the configuration are removed
+// - configuration fields of the remaining feeds are updated in place
+// Runtime state (scheduler counters, ETags, last run, followers, WebSub hubs) is preserved.
+// Instances, publishers, scheduler and state settings are replaced; discovered limits
+// of unchanged instances are kept. Server and WebSub settings require a restart.
+// The new feeds are verified and their cache keys migrated while feeds keep running;
+// only the swap waits for the running cycle.
+func (fm *FeedsMonitor) Reload() (*ReloadResult, error) {
+	fm.reloadMu.Lock()
+	defer fm.reloadMu.Unlock()
+
+	next, err := fm.loadNext()
+	if err != nil {
+		return nil, err
+	}
+
+	// the list is only replaced by Reload, so it can be matched without runMu
+	current := fm.feeds()
+	byName := make(map[string]*Feed, len(current))
+	byURL := make(map[string]*Feed, len(current))
+	for _, f := range current {
+		byName[f.Name] = f
+		byURL[f.URL()] = f
+	}
+
+	used := make(map[*Feed]bool, len(current))
+	matched := make([]*Feed, len(next.Instance.Feeds)) // running feed of each new one, nil if added
+	var verify []*Feed
+	for i, nf := range next.Instance.Feeds {
+		fm.setFeedDefaults(nf)
+		old := byName[nf.Name]
+		if old == nil || used[old] {
+			old = byURL[nf.URL()]
+		}
+		if old == nil || used[old] {
+			verify = append(verify, nf)
+			continue
+		}
+		used[old] = true
+		matched[i] = old
+		if old.accessToken() != nf.accessToken() || old.instanceName() != nf.Instance {
+			verify = append(verify, nf)
+		}
+		// keys are migrated from the namespace of the running feed
+		old.mu.Lock()
+		nf.keyPrefix = old.keyPrefix
+		old.mu.Unlock()
+	}
+
+	for _, f := range verify {
+		if err := fm.verifyCredentials(f, next.feedInstance(f)); err != nil {
+			fm.feedLog(f).Error("Error verifying credentials", errAttrs(err)...)
+		}
+	}
+	// new feeds and feeds with a new name, token or id keep their deduplication keys.
+	// Keys the running feed stores until the swap aren't copied: its last run skips those items.
+	fm.migrateKeys(next.Instance.Feeds)
+
+	// wait for the running cycle
+	fm.runMu.Lock()
+	defer fm.runMu.Unlock()
+
+	result := &ReloadResult{}
+	feeds := make([]*Feed, 0, len(next.Instance.Feeds))
+	for i, nf := range next.Instance.Feeds {
+		old := matched[i]
+		if old == nil {
+			result.Added = append(result.Added, nf.Name)
+			feeds = append(feeds, nf)
+			continue
+		}
+		if old.updateConfig(nf) {
+			result.Updated = append(result.Updated, old.Name)
+		}
+		feeds = append(feeds, old)
+	}
+	for _, f := range current {
+		if !used[f] {
+			result.Removed = append(result.Removed, f.Name)
+		}
+	}
+	// pushes for removed feeds are rejected, their subscriptions expire at the hub
+	fm.subs.Range(func(id, v any) bool {
+		if f := v.(*subscription).feed; !used[f] && slices.Contains(current, f) {
+			fm.subs.Delete(id)
+		}
+		return true
+	})
+
+	fm.configMu.Lock()
+	fm.Instance.URL = next.Instance.URL
+	fm.Instance.Lang = next.Instance.Lang
+	fm.Instance.Limit = next.Instance.Limit
+	fm.Instance.Save = next.Instance.Save
+	if fm.Instance.TimeZone != next.Instance.TimeZone {
+		fm.Instance.TimeZone = next.Instance.TimeZone
+		fm.location = next.Location()
+	}
+	fm.Instance.Feeds = feeds
+	fm.hostClient = next.hostClient
+	fm.Instances, fm.instances = next.Instances, next.instances
+	fm.Publishers, fm.publishers = next.Publishers, next.publishers
+	if fm.Scheduler.HostLimit != next.Scheduler.HostLimit {
+		// slots are sized by the limit, new ones are created on demand
+		fm.hostSlots.Clear()
+	}
+	fm.Scheduler = next.Scheduler
+	fm.State = next.State
+	fm.Dedup = next.Dedup
+	fm.PostHistory = next.PostHistory
+	fm.Alerts = next.Alerts
+	fm.configMu.Unlock()
+
+	fm.log().Info("Configuration reloaded", "added", result.Added, "removed", result.Removed, "updated", result.Updated)
+	return result, nil
+}
+
+// loadNext loads and initialises the configuration for Reload without changing the monitor.
+// Clients and discovered capabilities of unchanged instances are reused, other instances are discovered.
+func (fm *FeedsMonitor) loadNext() (*FeedsMonitor, error) {
+	if err := checkConfig(); err != nil {
+		return nil, err
+	}
+	next, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := next.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	fm.configMu.RLock()
+	hostClient, instanceURL := fm.hostClient, fm.Instance.URL
+	fm.configMu.RUnlock()
+	if next.Instance.URL != "" || len(next.Instances) == 0 {
+		host, err := next.parseURLHost(next.Instance.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid instance URL: %w", err)
+		}
+		next.hostClient = hostClient
+		if next.Instance.URL != instanceURL || hostClient == nil {
+			next.hostClient = newInstanceClient(host)
+		}
+	}
+	next.Parser = fm.Parser
+	if err := next.initInstances(); err != nil {
+		return nil, err
+	}
+	if err := next.initTargets(); err != nil {
+		return nil, err
+	}
+	if err := next.initPublishers(); err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	fm.configMu.RLock()
+	for name, mi := range next.instances {
+		old, ok := fm.instances[name]
+		if !ok || old.URL != mi.URL {
+			wg.Go(mi.discover)
+			continue
+		}
+		if name != "" {
+			mi.client = old.client
+		}
+		mi.Version, mi.MaxMedia = old.Version, old.MaxMedia
+		if mi.Limit == 0 {
+			mi.Limit = old.Limit
+		}
+	}
+	fm.configMu.RUnlock()
+	wg.Wait()
+	if mi, ok := next.instances[""]; ok {
+		next.Instance.Limit = mi.Limit
+	}
+	return next, nil
+}
+
+// updateConfig copies the configuration fields of nf to the running feed, keeping its runtime state.
+// The account of new credentials, verified on nf, and the namespace of the migrated keys are taken
+// from nf too. It reports whether the configuration changed.
+func (f *Feed) updateConfig(nf *Feed) (changed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.accessToken() != nf.accessToken() || f.Instance != nf.Instance {
+		changed = true
+		f.Id, f.Language = nf.Id, nf.Language
+		f.verified.Store(nf.verified.Load())
+		if nf.verified.Load() {
+			f.setFollowers(nf.Followers.Load())
+		}
+	}
+	f.keyPrefix = nf.keyPrefix
+
+	if !slices.Equal(f.configURLs, nf.URLs) {
+		// a new URL invalidates the validator of the old one
+		f.cfgMu.Lock()
+		f.URLs, f.configURLs = nf.URLs, nf.URLs
+		f.cfgMu.Unlock()
+		f.EmptyEtag()
+		changed = true
+	}
+
+	// runtime counters of targets are kept by target name
+	for _, t := range nf.Targets {
+		for _, old := range f.Targets {
+			if old.Name == t.Name {
+				t.Count, t.SendTime = old.Count, old.SendTime
+			}
+		}
+	}
+	if !reflect.DeepEqual(f.Targets, nf.Targets) || !reflect.DeepEqual(f.Scrape, nf.Scrape) || !slices.Equal(f.Publish, nf.Publish) ||
+		!reflect.DeepEqual(f.Profile, nf.Profile) {
+		changed = true
+	}
+	f.Targets, f.Scrape, f.Publish, f.Profile = nf.Targets, nf.Scrape, nf.Publish, nf.Profile
+
+	// fields read without mu, see label
+	f.cfgMu.Lock()
+	setField(&f.Name, nf.Name, &changed)
+	setField(&f.FeedID, nf.FeedID, &changed)
+	setField(&f.Token, nf.Token, &changed)
+	setField(&f.TokenFile, nf.TokenFile, &changed)
+	setField(&f.TokenEnv, nf.TokenEnv, &changed)
+	setField(&f.token, nf.token, &changed)
+	setField(&f.Instance, nf.Instance, &changed)
+	f.cfgMu.Unlock()
+	setField(&f.Prefix, nf.Prefix, &changed)
+	setField(&f.Visibility, nf.Visibility, &changed)
+	setField(&f.HashLink, nf.HashLink, &changed)
+	setField(&f.HashTag, nf.HashTag, &changed)
+	setField(&f.ReplaceFrom, nf.ReplaceFrom, &changed)
+	setField(&f.ReplaceTo, nf.ReplaceTo, &changed)
+	setField(&f.ReplaceLink, nf.ReplaceLink, &changed)
+	setField(&f.Template, nf.Template, &changed)
+	setField(&f.UpdateURL, nf.UpdateURL, &changed)
+	setField(&f.Source, nf.Source, &changed)
+	setField(&f.Dedup, nf.Dedup, &changed)
+	setField(&f.Interval, nf.Interval, &changed)
+	return changed
+}
+
+// setField sets *dst to v and records whether the value changed
+func setField[T comparable](dst *T, v T, changed *bool) {
+	if *dst != v {
+		*dst = v
+		*changed = true
+	}
+}
+
+// WatchConfig reloads the configuration whenever the configuration file changes,
+// until stop is closed. Changes are debounced, so an editor saving the file in
+// several steps triggers one reload. The directory of the file is watched,
+// so files replaced by rename (editors, ConfigMap updates) are handled too.
+func (fm *FeedsMonitor) WatchConfig(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	path := filepath.Clean(configFile)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	reload := make(chan struct{}, 1)
+	timer := time.AfterFunc(time.Hour, func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	})
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !isConfigEvent(event, path) {
+				continue
+			}
+			timer.Reset(reloadDebounce)
+		case <-reload:
+			if _, err := fm.Reload(); err != nil {
+				fm.log().Error("Error reloading configuration", classAttrs(errClassConfig, err)...)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fm.log().Error("Error watching configuration", errAttrs(err)...)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// isConfigEvent reports whether a file system event changes the configuration file.
+// Kubernetes ConfigMaps replace the ..data symlink in the same directory.
+func isConfigEvent(event fsnotify.Event, path string) bool {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	return name == path || strings.HasPrefix(filepath.Base(name), "..data")
+}
@@ -0,0 +1,347 @@
+package rss2masto
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/valyala/fasthttp"
+)
+
+const reloadConfig = `instance:
+  url: https://mastodon.example
+  lang: en
+  limit: 500
+  timezone: UTC
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      token: news-token
+      visibility: public
+    - name: Sport
+      url: https://example.com/sport.xml
+      token: sport-token
+    - name: Weather
+      url: https://example.com/weather.xml
+      token: weather-token
+`
+
+// newReloadMonitor initialises a monitor from the configuration file like NewFeedsMonitor,
+// with a mock client for the instance block
+func newReloadMonitor(t *testing.T, client httpClient) *FeedsMonitor {
+	t.Helper()
+	fm, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.hostClient = client
+	fm.Parser = NewParser(nil)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initTargets(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initPublishers(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fm.Instance.Feeds {
+		fm.setFeedDefaults(f)
+	}
+	return fm
+}
+
+func TestReload(t *testing.T) {
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(reloadConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var verified []string
+	client := &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			verified = append(verified, string(req.Header.Peek("Authorization")))
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.SetBodyString(`{"id":"42","followers_count":7,"source":{"language":"de"}}`)
+			return nil
+		},
+	}
+	fm := newReloadMonitor(t, client)
+	news, sport := fm.Instance.Feeds[0], fm.Instance.Feeds[1]
+	news.shedCounter.Store(3)
+	news.SetETag([]byte(`"news"`))
+	news.Followers.Store(100)
+	news.LastRun = 1700000000
+	sport.SetETag([]byte(`"sport"`))
+
+	config := `instance:
+  url: https://mastodon.example
+  lang: en
+  limit: 500
+  timezone: UTC
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      token: news-token
+      visibility: unlisted
+    - name: Sport
+      url: https://example.com/sport.atom
+      token: sport-token
+    - name: Tech
+      url: https://example.com/tech.xml
+      token: tech-token
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := fm.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if !slices.Equal(result.Added, []string{"Tech"}) || !slices.Equal(result.Removed, []string{"Weather"}) ||
+		!slices.Equal(result.Updated, []string{"News", "Sport"}) {
+		t.Errorf("Reload() = %+v", result)
+	}
+
+	feeds := fm.feeds()
+	if len(feeds) != 3 || feeds[0] != news || feeds[1] != sport {
+		t.Fatalf("feeds after reload = %v", feeds)
+	}
+	if news.Visibility != "unlisted" {
+		t.Errorf("News visibility = %q, want unlisted", news.Visibility)
+	}
+	if news.shedCounter.Load() != 3 || string(news.ETag()) != `"news"` || news.Followers.Load() != 100 || news.LastRun != 1700000000 {
+		t.Errorf("News runtime state not preserved: counter %d, etag %q, followers %d, last run %d",
+			news.shedCounter.Load(), news.ETag(), news.Followers.Load(), news.LastRun)
+	}
+	if sport.URL() != "https://example.com/sport.atom" || len(sport.ETag()) != 0 {
+		t.Errorf("Sport URL %q, etag %q: want the new URL without validator", sport.URL(), sport.ETag())
+	}
+	tech := feeds[2]
+	if tech.Id != 42 || tech.Followers.Load() != 7 || tech.LastRun != fm.LastMonit() {
+		t.Errorf("Tech not initialised: id %d, followers %d, last run %d", tech.Id, tech.Followers.Load(), tech.LastRun)
+	}
+	if !slices.Equal(verified, []string{"Bearer tech-token"}) {
+		t.Errorf("verified credentials = %v, want only the new feed", verified)
+	}
+
+	// an unchanged configuration changes nothing
+	result, err = fm.Reload()
+	if err != nil || len(result.Added)+len(result.Removed)+len(result.Updated) != 0 {
+		t.Errorf("second Reload() = %+v, %v", result, err)
+	}
+}
+
+// TestReload_ConcurrentReaders renames a feed and changes its token while the admin
+// API, the metrics and the cache keys read them; run with -race
+func TestReload_ConcurrentReaders(t *testing.T) {
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(reloadConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.SetBodyString(`{"id":"42"}`)
+			return nil
+		},
+	}
+	fm := newReloadMonitor(t, client)
+	news := fm.Instance.Feeds[0]
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			fm.FeedStatuses()
+			fm.WriteMetrics(io.Discard)
+			fm.feedLog(news)
+			news.namespace()
+			fm.GetFromInstance("/api/v1/instance")
+		}
+	}()
+	renamed := strings.Replace(reloadConfig, "name: News", "name: Headlines", 1)
+	renamed = strings.Replace(renamed, "news-token", "headlines-token", 1)
+	for _, config := range []string{renamed, reloadConfig} {
+		if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fm.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+	if news.label() != "News" || news.accessToken() != "news-token" {
+		t.Errorf("feed after reloads: %s %s", news.label(), news.accessToken())
+	}
+}
+
+// TestReload_RunningCycle verifies new credentials while a cycle holds runMu
+// and applies them once the cycle is finished
+func TestReload_RunningCycle(t *testing.T) {
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(reloadConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	verified := make(chan string, 10)
+	client := &mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			token := string(req.Header.Peek("Authorization"))
+			verified <- token
+			resp.SetStatusCode(fasthttp.StatusOK)
+			if token == "Bearer headlines-token" {
+				resp.SetBodyString(`{"id":"43","followers_count":9}`)
+			} else {
+				resp.SetBodyString(`{"id":"42","followers_count":7}`)
+			}
+			return nil
+		},
+	}
+	fm := newReloadMonitor(t, client)
+	news := fm.Instance.Feeds[0]
+	news.Id = 42
+	news.verified.Store(true)
+
+	config := strings.Replace(reloadConfig, "news-token", "headlines-token", 1) + `    - name: Tech
+      url: https://example.com/tech.xml
+      token: tech-token
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fm.runMu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := fm.Reload()
+		done <- err
+	}()
+	var tokens []string
+	for range 2 {
+		select {
+		case token := <-verified:
+			tokens = append(tokens, token)
+		case <-time.After(2 * time.Second):
+			fm.runMu.Unlock()
+			t.Fatalf("credentials not verified during the running cycle, got %v", tokens)
+		}
+	}
+	if !slices.Equal(tokens, []string{"Bearer headlines-token", "Bearer tech-token"}) {
+		t.Errorf("verified credentials = %v", tokens)
+	}
+	select {
+	case <-done:
+		t.Fatal("Reload() applied the configuration during the running cycle")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(fm.feeds()) != 3 || news.Id != 42 {
+		t.Errorf("configuration changed during the running cycle")
+	}
+	fm.runMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	feeds := fm.feeds()
+	if len(feeds) != 4 || feeds[0] != news || news.accessToken() != "headlines-token" {
+		t.Fatalf("feeds after reload = %v", feeds)
+	}
+	if news.Id != 43 || news.Followers.Load() != 9 || !news.verified.Load() {
+		t.Errorf("News account: id %d, followers %d, verified %v", news.Id, news.Followers.Load(), news.verified.Load())
+	}
+	if feeds[3].Id != 42 {
+		t.Errorf("Tech id = %d, want 42", feeds[3].Id)
+	}
+}
+
+func TestReload_InvalidConfig(t *testing.T) {
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(reloadConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fm := newReloadMonitor(t, &mockHostClient{})
+
+	config := reloadConfig + "      instance: missing\n"
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.Reload(); err == nil {
+		t.Fatal("Reload() of an invalid configuration succeeded")
+	}
+	if len(fm.feeds()) != 3 || fm.feeds()[2].Instance != "" {
+		t.Error("an invalid configuration was applied")
+	}
+}
+
+func TestIsConfigEvent(t *testing.T) {
+	path := filepath.Join("etc", "rss2masto", "feed.yaml")
+	tests := []struct {
+		event fsnotify.Event
+		want  bool
+	}{
+		{fsnotify.Event{Name: path, Op: fsnotify.Write}, true},
+		{fsnotify.Event{Name: path, Op: fsnotify.Create}, true},
+		{fsnotify.Event{Name: path, Op: fsnotify.Chmod}, false},
+		{fsnotify.Event{Name: filepath.Join("etc", "rss2masto", "state.json"), Op: fsnotify.Write}, false},
+		{fsnotify.Event{Name: filepath.Join("etc", "rss2masto", "..data_tmp"), Op: fsnotify.Rename}, true},
+	}
+	for _, tt := range tests {
+		if got := isConfigEvent(tt.event, path); got != tt.want {
+			t.Errorf("isConfigEvent(%v) = %v, want %v", tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for file system events")
+	}
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(reloadConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fm := newReloadMonitor(t, &mockHostClient{})
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- fm.WatchConfig(stop) }()
+	time.Sleep(100 * time.Millisecond)
+
+	config := reloadConfig[:len(reloadConfig)-len("    - name: Weather\n      url: https://example.com/weather.xml\n      token: weather-token\n")]
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fm.feeds()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("WatchConfig() error = %v", err)
+	}
+	if len(fm.feeds()) != 2 {
+		t.Errorf("feeds after the file changed = %d, want 2", len(fm.feeds()))
+	}
+}
@@ -2,6 +2,8 @@ package rss2masto
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"html"
 	"regexp"
@@ -11,7 +13,6 @@ import (
 	"sync"
 	"time"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/mmcdole/gofeed"
 	"github.com/valyala/fasthttp"
@@ -24,101 +25,183 @@ const (
 
 var strictPolicy = bluemonday.StrictPolicy()
 
-// Start processes all feeds in parallel using goroutines
-// For each feed with valid URL and token:
+// Start processes all due feeds in parallel using goroutines
+// For each feed with valid URL and token (or targets, or named publishers) that isn't paused:
+// - Requests or renews the WebSub subscription if the feed advertises a hub
 // - Increments sheduler counter
-// - When shedCounter reaches interval, resets counter and processes feed
+// - When shedCounter reaches interval, resets counter and marks the feed as due
+// - Multiplies the interval by websub.poll_factor for feeds with an active subscription
 // - Updates last check timestamp
-// - Saves feed data if configured
+// - Processes due feeds with a bounded worker pool, spread over the jitter window
+// - Saves runtime state if configured
+// The start and finish of the run are recorded for Health, also without feeds.
 func (fm *FeedsMonitor) Start() {
 
-	if len(fm.Instance.Feeds) == 0 {
+	if fm.isStarted.Swap(true) {
 		return
 	}
+	defer fm.isStarted.Store(false)
 
-	if fm.isStarted.Swap(true) {
+	// Reload waits for the cycle to finish before changing the feeds
+	fm.runMu.Lock()
+	defer fm.runMu.Unlock()
+	feeds := fm.feeds()
+	defer func() {
+		fm.lastFinish.Store(time.Now().UnixNano())
+	}()
+
+	// the tick is measured between runs, to estimate the next check of each feed
+	now := time.Now().UnixNano()
+	if last := fm.lastStart.Swap(now); last > 0 {
+		fm.tick.Store(now - last)
+	}
+	// an empty feed list is a finished run, for the health check
+	if len(feeds) == 0 {
 		return
 	}
-	defer fm.isStarted.Store(false)
 
 	var wg sync.WaitGroup
-	for _, feed := range fm.Instance.Feeds {
-		if feed.URL() == "" || feed.Token == "" {
+	var due []*Feed
+	for _, feed := range feeds {
+		fm.updateHealth(feed)
+		if feed.URL() == "" || feed.Paused() || (feed.accessToken() == "" && len(feed.Targets) == 0 && len(feed.Publish) == 0) {
 			continue
 		}
-		if feed.shedCounter.Add(1) >= feed.Interval {
-			feed.shedCounter.Store(0)
-			fm.lastCheck.Store(time.Now().Unix())
+		if fm.needsSubscription(feed) {
 			wg.Go(func() {
-				fm.GetFeed(feed)
+				fm.subscribeFeed(feed)
 			})
 		}
+		if feed.shedCounter.Add(1) >= fm.pollInterval(feed) {
+			feed.shedCounter.Store(0)
+			fm.lastCheck.Store(time.Now().Unix())
+			due = append(due, feed)
+		}
 	}
+	fm.dispatch(due)
 	wg.Wait()
 
-	if fm.Instance.Save {
-		err := fm.SaveFeedsData()
+	if fm.persistState() {
+		err := fm.SaveState()
 		if err != nil {
-			fmt.Println("Error saving config file:", err)
+			fm.log().Error("Error saving state", errAttrs(err)...)
 		}
 	}
 }
 
+// pollInterval returns the number of ticks between checks of a feed;
+// feeds receiving WebSub pushes are polled less often, as a fallback only
+func (fm *FeedsMonitor) pollInterval(f *Feed) int64 {
+	interval := f.Interval
+	if fm.isSubscribed(f) {
+		interval *= fm.WebSub.pollFactor()
+	}
+	return interval
+}
+
 // GetFeed retrieves and processes items from a feed
-// For each item in the feed:
-// - Checks if item is within time limits
-// - Generates idempotency key based on item GUID
-// - Skips if item already processed
-// - Sanitizes title and description
-// - Applies replacement rules if configured
-// - Constructs message with title, description, hashtags and link
-// - Sends post to mastodon instance
-// - Updates counters and timestamps
+// Fetching respects the per-host limit of the scheduler; processing does not hold a host slot.
 func (fm *FeedsMonitor) GetFeed(f *Feed) {
+	fm.getFeed(f, fm.acquireHost(f))
+}
 
-	feed := fm.Parser.FetchAndParse(f)
-	if feed == nil {
+// getFeed is GetFeed with a host slot already taken, freed by release after the fetch.
+// It returns at once if the feed is already being fetched, e.g. on request of the admin API.
+func (fm *FeedsMonitor) getFeed(f *Feed, release func()) {
+	if !f.fetching.CompareAndSwap(false, true) {
+		release()
 		return
 	}
+	defer fm.endFetch(f)
+	fm.fetchFeed(f, release)
+}
 
-	// Sort items by date descending
-	// Sort by UpdatedParsed if available, otherwise fall back to PublishedParsed
-	if len(feed.Items) > 0 && feed.Items[0].UpdatedParsed != nil {
-		sort.Slice(feed.Items, func(i, j int) bool {
-			return feed.Items[i].UpdatedParsed.Unix() > feed.Items[j].UpdatedParsed.Unix()
-		})
-	} else {
-		sort.Slice(feed.Items, func(i, j int) bool {
-			return feed.Items[i].PublishedParsed.Unix() > feed.Items[j].PublishedParsed.Unix()
-		})
+// fetchNow fetches and processes a feed in the background, outside of the scheduler,
+// once a slot of its host is free. It reports false if the feed is already being fetched.
+func (fm *FeedsMonitor) fetchNow(f *Feed) bool {
+	if !f.fetching.CompareAndSwap(false, true) {
+		return false
 	}
+	go func() {
+		defer fm.endFetch(f)
+		fm.fetchFeed(f, fm.acquireHost(f))
+	}()
+	return true
+}
 
-	var reReplace, reTag, reLink *regexp.Regexp
-
-	if f.ReplaceFrom != "" {
-		reReplace, _ = regexp.Compile(f.ReplaceFrom)
+// fetchFeed is getFeed once the feed is marked as being fetched
+func (fm *FeedsMonitor) fetchFeed(f *Feed, release func()) {
+	feed := fm.Parser.FetchAndParse(f)
+	release()
+	fm.updateHealth(f)
+	if feed == nil {
+		return
 	}
-	if f.HashLink != "" {
-		reTag, _ = regexp.Compile(f.HashLink)
+	fm.processFeed(f, feed)
+}
+
+// processFeed processes the items of a polled or pushed feed
+// For each item in the feed:
+// - Checks if item is within time limits
+// - Generates an idempotency key per publisher based on item GUID
+// - Skips if item already processed by all publishers of the feed
+// - Sanitizes title and description, shortened to the limit of each publisher
+// - Applies replacement rules if configured
+// - Constructs message with title, description, hashtags and link
+// - Sends post to the Mastodon account and the named publishers of the feed
+// - Updates counters and timestamps
+// Canonical links are resolved before the feed is locked; hooks are called and the
+// profile is synced once it is unlocked.
+func (fm *FeedsMonitor) processFeed(f *Feed, feed *gofeed.Feed) {
+	var events hookQueue
+	links := fm.canonicalLinks(f, feed)
+	fm.processItems(f, feed, links, &events)
+	events.fire(fm)
+	if debugMode {
+		return
 	}
-	if f.ReplaceLink != "" {
-		reLink, _ = regexp.Compile(f.ReplaceLink)
+	fm.configMu.RLock()
+	instance := fm.feedInstance(f)
+	fm.configMu.RUnlock()
+	if err := fm.syncProfile(f, feed, instance); err != nil {
+		fm.feedLog(f).Warn("Error updating profile", errAttrs(err)...)
 	}
+}
+
+// processItems is processFeed holding the feed lock, it queues the hook calls in events.
+// links holds the canonical links of the items by GUID, see canonicalLinks.
+func (fm *FeedsMonitor) processItems(f *Feed, feed *gofeed.Feed, links map[string]string, events *hookQueue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	log := fm.feedLog(f)
+	f.stats.add(&f.stats.seen, len(feed.Items))
+
+	sortItems(feed)
+	re := f.regexps()
 
 	now := time.Now().UTC()
 	limitUnixTime := now.Add(earlierDuration).Unix()
 
 	postError := false
+	fm.configMu.RLock()
+	publishers := fm.feedPublishers(f)
+	instance := fm.feedInstance(f)
+	loc := fm.Location()
+	dedup := fm.Dedup
+	history := fm.PostHistory
+	fm.configMu.RUnlock()
+	if instance != nil {
+		loc = instance.Location()
+	}
 
 	for i := len(feed.Items) - 1; i >= 0; i-- {
 		item := feed.Items[i]
-		pubUnixTime := item.PublishedParsed.Unix()
-		if item.UpdatedParsed != nil {
-			pubUnixTime = item.UpdatedParsed.Unix()
-		}
+		pubUnixTime := itemTime(item)
 
 		// ignore items older than 12 hours
 		if pubUnixTime < limitUnixTime {
+			f.stats.add(&f.stats.skipped, 1)
+			events.add("OnItemSkipped", func(h Hooks) { h.OnItemSkipped(f, item, SkipTooOld) })
 			continue
 		}
 
@@ -129,122 +212,319 @@ func (fm *FeedsMonitor) GetFeed(f *Feed) {
 
 		// ignore items older than last run (we presume that it has already been sent)
 		if pubUnixTime < f.LastRun {
+			log.Debug("Skipped, older than last run", "guid", item.GUID)
+			f.stats.add(&f.stats.skipped, 1)
+			events.add("OnItemSkipped", func(h Hooks) { h.OnItemSkipped(f, item, SkipBeforeLastRun) })
 			continue
 		}
 
-		idempotencyKey := f.Name[:2] + ":" + hashString(item.GUID)
-
-		if Cache.KeyExists(idempotencyKey) {
+		// publishers that haven't received the item yet
+		var pending []namedPublisher
+		for _, np := range publishers {
+			if !Cache.KeyExists(np.idempotencyKey(f, item.GUID)) {
+				pending = append(pending, np)
+			}
+		}
+		if len(pending) == 0 {
+			delete(f.failedPosts, item.GUID)
+			log.Debug("Skipped, already posted", "guid", item.GUID)
+			f.stats.add(&f.stats.skipped, 1)
+			events.add("OnItemSkipped", func(h Hooks) { h.OnItemSkipped(f, item, SkipPosted) })
 			continue
 		}
 
-		// Determine language for the post
-		// Language is determined in the following order:
-		// 1. Feed (mastodon profile) language
-		// 2. RSS feed language
-		// 3. Language from FeedsMonitor configuration
-		lang := f.Language
-		if len(lang) != 2 {
-			lang = feed.Language
-			if len(lang) > 2 {
-				lang = lang[:2]
-			}
-			if len(lang) != 2 {
-				lang = fm.Instance.Lang
+		lang := postLanguage(f, feed, instance)
+		item.Link = re.cleanLink(item.Link)
+
+		// stories already posted by a feed of the dedup group are skipped
+		var claim *dedupEntry
+		if f.Dedup != "" {
+			entry := newDedupEntry(dedup, f, item.GUID, cmp.Or(links[item.GUID], item.Link), html.UnescapeString(item.Title))
+			orig, duplicate := fm.recent.claim(f.Dedup, dedup, entry)
+			if duplicate {
+				f.stats.add(&f.stats.deduped, 1)
+				if orig.Post == "" {
+					// the original is being published, or will be released if that fails
+					continue
+				}
+				log.Debug("Skipped, duplicate", "guid", item.GUID, "url", item.Link, "original_feed", orig.Feed, "original_post", orig.Post)
+				delete(f.failedPosts, item.GUID)
+				for _, np := range pending {
+					if err := Cache.Store(np.idempotencyKey(f, item.GUID), "1"); err != nil {
+						log.Error("Cache store error", errAttrs(err, "guid", item.GUID)...)
+					}
+				}
+				events.add("OnItemSkipped", func(h Hooks) { h.OnItemSkipped(f, item, SkipDuplicate) })
+				continue
 			}
+			claim = orig
 		}
 
-		item.Link, _, _ = strings.Cut(item.Link, "?source=rss")
-		if reLink != nil {
-			item.Link = reLink.ReplaceAllString(item.Link, "")
-		}
+		hashtags := makeHashtags(item, f, re.tag)
 
-		hashtags := makeHashtags(item, f, reTag)
-		title, description := fm.sanitizeMessage(item, len(hashtags))
+		sent, posted, failed, postURL := false, false, false, ""
+		for _, np := range pending {
+			post, err := makePost(f, item, np, hashtags, lang, re)
+			if err != nil {
+				log.Error("Template error", classAttrs(errClassTemplate, err, "publisher", np.name)...)
+			}
 
-		if reReplace != nil {
-			description = reReplace.ReplaceAllString(description, f.ReplaceTo)
-			description = strings.TrimSpace(description)
-		}
+			if debugMode {
+				//os.WriteFile(post.IdempotencyKey, []byte(post.Text), 0600)
+				failed = true
+				continue
+			}
 
-		sb := strings.Builder{}
-		sb.WriteString(title)
-		sb.WriteString("\n\n")
+			start := time.Now()
+			published, err := np.Publish(post)
+			fm.recordPost(f, np, time.Since(start), err)
+			if err != nil {
+				log.Error("Post error", errAttrs(err, "publisher", np.name, "guid", item.GUID, "duration", time.Since(start))...)
+				f.setError(fmt.Errorf("%s: %w", np.name, err))
+				postError, failed = true, true
+				if f.failedPosts == nil {
+					f.failedPosts = make(map[string]failedPost)
+				}
+				f.failedPosts[item.GUID] = failedPost{title: item.Title, time: pubUnixTime, publisher: np.name, err: err}
+				events.add("OnPostFailed", func(h Hooks) { h.OnPostFailed(f, item, np.name, err) })
+				fm.checkAlert(AlertPost, f, np.name, err)
+				continue
+			}
+			log.Debug("Posted", "publisher", np.name, "guid", item.GUID, "url", published.URL, "duration", time.Since(start))
+			events.add("OnPosted", func(h Hooks) { h.OnPosted(f, item, np.name, published.ID, published.URL) })
+			fm.checkAlert(AlertPost, f, np.name, nil)
+			posted = true
+			postURL = cmp.Or(postURL, published.URL, published.ID)
+			if published.ID != "" {
+				if f.statuses == nil {
+					f.statuses = make(map[string]string)
+				}
+				f.statuses[np.name] = published.ID
+			}
+			if t := np.target; t != nil {
+				t.Count++
+				t.SendTime = time.Now().In(loc)
+			} else {
+				sent = true
+			}
 
-		if description != "" {
-			sb.WriteString(description)
-			sb.WriteString("\n\n")
+			err = Cache.Store(post.IdempotencyKey, "1")
+			if err != nil {
+				log.Error("Cache store error", errAttrs(err, "guid", item.GUID)...)
+			}
+
+			err = recordHistory(history, f, &HistoryEntry{
+				Time:      time.Now(),
+				Feed:      f.Name,
+				Publisher: np.name,
+				GUID:      item.GUID,
+				Link:      post.Link,
+				Title:     post.Title,
+				StatusID:  published.ID,
+				URL:       published.URL,
+				Text:      post.Text,
+			})
+			if err != nil && !errors.Is(err, errOffline) {
+				log.Error("Error recording history", classAttrs(errClassCache, err, "guid", item.GUID)...)
+			}
 		}
-		if hashtags != "" {
-			sb.WriteString(hashtags)
-			sb.WriteString("\n\n")
+
+		if posted {
+			f.stats.addPost(time.Now())
 		}
-		sb.WriteString(item.Link)
-		msg := sb.String()
+		if sent {
+			f.Count++
+			f.SendTime = time.Now().In(loc)
+		}
+		if claim != nil {
+			if postURL != "" || sent {
+				fm.recent.publish(f.Dedup, claim, cmp.Or(postURL, item.Link))
+			} else {
+				fm.recent.release(f.Dedup, claim)
+			}
+		}
+		// the item is retried on the next run until every publisher has it
+		if failed {
+			continue
+		}
+		delete(f.failedPosts, item.GUID)
+		if f.LastRun < pubUnixTime {
+			f.LastRun = pubUnixTime
+		}
+		if f.LastRun > fm.LastMonit() {
+			fm.lastMonit.Store(f.LastRun)
+		}
+	}
+	if postError {
+		// reset etag so next run re-fetches unconditionally
+		f.EmptyEtag()
+	}
+	fm.giveUpPosts(f, max(limitUnixTime, f.LastRun))
+}
 
-		if !debugMode {
-			func() {
+// failedPost is an item whose post failed, retried until every publisher has it
+type failedPost struct {
+	title     string
+	time      int64 // time of the item, capped to the time of the failure
+	publisher string
+	err       error // last error
+}
 
-				req := fasthttp.AcquireRequest()
-				defer fasthttp.ReleaseRequest(req)
+// giveUpPosts raises a dead letter alert for the failed posts of items older than
+// oldest, the last run or the 12 hour limit, which won't be retried. f.mu must be held.
+func (fm *FeedsMonitor) giveUpPosts(f *Feed, oldest int64) {
+	for guid, fp := range f.failedPosts {
+		if fp.time >= oldest {
+			continue
+		}
+		delete(f.failedPosts, guid)
+		fm.feedLog(f).Warn("Post given up", errAttrs(fp.err, "publisher", fp.publisher, "guid", guid)...)
+		fm.checkAlert(AlertDeadLetter, f, fp.publisher, fmt.Errorf("%q was not posted: %w", fp.title, fp.err))
+	}
+}
 
-				// Prepare post data
-				post := MastodonPost{
-					Status:     msg,
-					Visibility: f.Visibility,
-				}
-				if len(lang) == 2 {
-					post.Language = lang
-				}
+// sortItems sorts the items of a feed by date descending,
+// by UpdatedParsed if available, otherwise by PublishedParsed
+func sortItems(feed *gofeed.Feed) {
+	if len(feed.Items) > 0 && feed.Items[0].UpdatedParsed != nil {
+		sort.Slice(feed.Items, func(i, j int) bool {
+			return feed.Items[i].UpdatedParsed.Unix() > feed.Items[j].UpdatedParsed.Unix()
+		})
+	} else {
+		sort.Slice(feed.Items, func(i, j int) bool {
+			return feed.Items[i].PublishedParsed.Unix() > feed.Items[j].PublishedParsed.Unix()
+		})
+	}
+}
 
-				// Writing directly to BodyWriter() saves one []byte allocation
-				err := jsoniter.ConfigDefault.NewEncoder(req.BodyWriter()).Encode(post)
-				if err != nil {
-					fmt.Printf("Jsoniter error: %v\n", err)
-					return
-				}
+// itemTime returns the Unix time an item was updated, or published if it wasn't
+func itemTime(item *gofeed.Item) int64 {
+	if item.UpdatedParsed != nil {
+		return item.UpdatedParsed.Unix()
+	}
+	return item.PublishedParsed.Unix()
+}
 
-				req.Header.SetContentType("application/json")
-				req.Header.Set("Authorization", "Bearer "+f.Token)
-				req.Header.Set("Idempotency-Key", idempotencyKey)
+// feedRegexps holds the compiled regular expressions of a feed
+type feedRegexps struct {
+	replace *regexp.Regexp // ReplaceFrom
+	tag     *regexp.Regexp // HashLink
+	link    *regexp.Regexp // ReplaceLink
+}
 
-				err = fm.PostToInstance(req)
-				if err != nil {
-					fmt.Printf("[%s] Mastodon post error: %v\n", f.Name, err)
-					postError = true
-					return
-				}
+// regexps compiles the regular expressions of the feed, invalid ones are ignored
+func (f *Feed) regexps() feedRegexps {
+	var re feedRegexps
+	if f.ReplaceFrom != "" {
+		re.replace, _ = regexp.Compile(f.ReplaceFrom)
+	}
+	if f.HashLink != "" {
+		re.tag, _ = regexp.Compile(f.HashLink)
+	}
+	if f.ReplaceLink != "" {
+		re.link, _ = regexp.Compile(f.ReplaceLink)
+	}
+	return re
+}
 
-				f.Count++
-				f.SendTime = time.Now().In(fm.Location())
+// cleanLink removes the ?source=rss suffix and the ReplaceLink matches from an item link
+func (re feedRegexps) cleanLink(link string) string {
+	link, _, _ = strings.Cut(link, "?source=rss")
+	if re.link != nil {
+		link = re.link.ReplaceAllString(link, "")
+	}
+	return link
+}
 
-				err = Cache.Store(idempotencyKey, "1")
-				if err != nil {
-					fmt.Printf("[%s] Cache store error: %v\n", f.Name, err)
-				}
+// postLanguage determines the language of posts of a feed in the following order:
+// 1. Feed (mastodon profile) language
+// 2. RSS feed language
+// 3. Language of the feed's instance from FeedsMonitor configuration
+func postLanguage(f *Feed, feed *gofeed.Feed, instance *MastodonInstance) string {
+	lang := f.Language
+	if len(lang) != 2 {
+		lang = feed.Language
+		if len(lang) > 2 {
+			lang = lang[:2]
+		}
+		if len(lang) != 2 && instance != nil {
+			lang = instance.Lang
+		}
+	}
+	return lang
+}
 
-				if f.LastRun < pubUnixTime {
-					f.LastRun = pubUnixTime
-				}
-				if f.LastRun > fm.LastMonit() {
-					fm.lastMonit.Store(f.LastRun)
-				}
-			}()
-		} else {
-			//os.WriteFile(idempotencyKey, []byte(msg), 0600)
+// makePost renders an item of feed f for a publisher, applying the overrides of its target.
+// On a template error the post is rendered with the default layout and the error returned.
+func makePost(f *Feed, item *gofeed.Item, np namedPublisher, hashtags, lang string, re feedRegexps) (*Post, error) {
+	// per-target overrides of the feed settings
+	tags, visibility, postLang, tmpl := hashtags, f.Visibility, lang, f.Template
+	if t := np.target; t != nil {
+		if t.HashTag != "" || t.Prefix != "" {
+			tags = makeTags(item, cmp.Or(t.HashTag, f.HashTag), cmp.Or(t.Prefix, f.Prefix), re.tag)
+		}
+		if visibilityTypes[t.Visibility] {
+			visibility = t.Visibility
+		}
+		if len(t.Language) == 2 {
+			postLang = t.Language
 		}
+		tmpl = cmp.Or(t.Template, tmpl)
 	}
-	if postError {
-		// reset etag so next run re-fetches unconditionally
-		f.EmptyEtag()
+
+	data := &messageData{
+		Feed:     f.Name,
+		Title:    html.UnescapeString(item.Title),
+		Hashtags: tags,
+		Link:     item.Link,
+		Image:    itemImage(item),
+	}
+	// the message is shortened to the limit of each publisher,
+	// taking the text added by the template into account
+	extra := len(tags)
+	if tmpl != "" {
+		if fixed, err := renderMessage(tmpl, data); err == nil {
+			extra = max(len(fixed)-len(data.Title)-len(data.Link)-11, 0)
+		}
 	}
+	data.Title, data.Description = sanitizeMessage(item, extra, np.Capabilities().MaxLength)
+	if re.replace != nil {
+		data.Description = re.replace.ReplaceAllString(data.Description, f.ReplaceTo)
+		data.Description = strings.TrimSpace(data.Description)
+	}
+
+	msg, err := renderMessage(tmpl, data)
+	if err != nil {
+		msg, _ = renderMessage("", data)
+	}
+
+	post := &Post{
+		Text:           msg,
+		Title:          data.Title,
+		Description:    data.Description,
+		Hashtags:       tags,
+		Link:           item.Link,
+		Image:          data.Image,
+		Visibility:     visibility,
+		IdempotencyKey: np.idempotencyKey(f, item.GUID),
+	}
+	if len(postLang) == 2 {
+		post.Language = postLang
+	}
+	return post, err
 }
 
 // GetFromInstance performs a GET request to the specified endpoint on the Mastodon instance.
 // Optional parameter token can be provided for authentication
 func (fm *FeedsMonitor) GetFromInstance(endpoint string, token ...string) ([]byte, error) {
-	target := fm.Instance.URL + endpoint
+	fm.configMu.RLock()
+	client, url := fm.hostClient, fm.Instance.URL
+	fm.configMu.RUnlock()
+	return getFromInstance(client, url+endpoint, token...)
+}
 
+// getFromInstance performs a GET request of a JSON resource
+func getFromInstance(client httpClient, target string, token ...string) ([]byte, error) {
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
@@ -265,19 +545,23 @@ func (fm *FeedsMonitor) GetFromInstance(endpoint string, token ...string) ([]byt
 		req.Header.Set("Authorization", "Bearer "+token[0])
 	}
 
-	if err := fm.hostClient.Do(req, resp); err != nil {
-		return nil, err
+	if err := client.Do(req, resp); err != nil {
+		return nil, redactError(err)
 	}
 
 	if resp.StatusCode() != fasthttp.StatusOK {
-		return nil, fmt.Errorf("Received non-OK HTTP status: %d", resp.StatusCode())
+		return nil, &httpError{Status: resp.StatusCode()}
 	}
-	return resp.Body(), nil
+	return append([]byte(nil), resp.Body()...), nil
 }
 
 // PostToInstance performs a POST request to the Mastodon instance's API endpoint for creating statuses.
+//
+// Deprecated: feeds publish through the Publisher interface, use MastodonPublisher.
 func (fm *FeedsMonitor) PostToInstance(req *fasthttp.Request) error {
-	target := fm.Instance.URL + "/api/v1/statuses"
+	fm.configMu.RLock()
+	client, target := fm.hostClient, fm.Instance.URL+"/api/v1/statuses"
+	fm.configMu.RUnlock()
 
 	url := fasthttp.AcquireURI()
 	defer fasthttp.ReleaseURI(url)
@@ -293,7 +577,7 @@ func (fm *FeedsMonitor) PostToInstance(req *fasthttp.Request) error {
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
 
-	if err := fm.hostClient.Do(req, resp); err != nil {
+	if err := client.Do(req, resp); err != nil {
 		return err
 	}
 	statusCode := resp.StatusCode()
@@ -314,6 +598,8 @@ func (fm *FeedsMonitor) PostToInstance(req *fasthttp.Request) error {
 
 // FetchAndParse fetches and parses a feed, trying each URL in order.
 // The first URL is the primary; subsequent URLs are used as fallbacks.
+// If a URL returns an HTML page, the feed it advertises is fetched instead,
+// unless the feed source is scrape and items are extracted from the page itself.
 // Returns a parsed feed or nil if all URLs fail.
 func (p *Parser) FetchAndParse(f *Feed) *gofeed.Feed {
 	req := fasthttp.AcquireRequest()
@@ -330,9 +616,18 @@ func (p *Parser) FetchAndParse(f *Feed) *gofeed.Feed {
 		req.Header.SetBytesV("If-None-Match", currentEtag)
 	}
 
+	urls := f.urlList()
+	if len(urls) == 0 {
+		f.setError(errors.New("no feed URL"))
+		return nil
+	}
 	var err error
+	used := 0
+	start := time.Now()
 	func() {
-		for _, url := range f.URLs {
+		for i, url := range urls {
+			used = i
+			start = time.Now()
 			req.SetRequestURI(url)
 			err = p.Client.Do(req, resp)
 			if err == nil {
@@ -342,37 +637,90 @@ func (p *Parser) FetchAndParse(f *Feed) *gofeed.Feed {
 		}
 	}()
 
+	log := p.log().With("feed", f.label(), "url", urls[used])
 	if err != nil {
-		fmt.Printf("[%s] Error fetching: %v\n", f.Name, err)
+		log.Error("Error fetching", errAttrs(err, "duration", time.Since(start))...)
+		f.failures.Add(1)
+		f.stats.add(&f.stats.fetchErrors, 1)
+		f.setError(err)
 		return nil
 	}
+	f.stats.fetched(resp.StatusCode())
+	if !f.preview {
+		p.hook("OnFetched", func(h Hooks) { h.OnFetched(f, resp.StatusCode(), len(resp.Body())) })
+	}
 
 	if resp.StatusCode() == fasthttp.StatusNotModified {
+		log.Debug("Not modified", "status", resp.StatusCode(), "duration", time.Since(start))
+		f.failures.Store(0)
 		return nil
 	}
 
 	if resp.StatusCode() == fasthttp.StatusOK {
+		// a homepage instead of a feed - look for an advertised feed
+		if f.Source != SourceScrape && isHTML(resp.Header.ContentType(), resp.Body()) {
+			if err := p.discover(f, urls[used], used, req, resp); err != nil {
+				log.Error("Error discovering feed", errAttrs(err, "duration", time.Since(start))...)
+				f.failures.Add(1)
+				f.stats.add(&f.stats.fetchErrors, 1)
+				f.setError(err)
+				return nil
+			}
+		}
+
 		newEtag := resp.Header.Peek("ETag")
 		if len(newEtag) > 0 && !bytes.Equal(currentEtag, newEtag) {
 			f.SetETag(append([]byte(nil), newEtag...))
 		}
 
-		fp := p.parserPool.Get().(*gofeed.Parser)
-		defer p.parserPool.Put(fp)
+		if f.Source == SourceScrape {
+			result, err := scrapeFeed(resp.Body(), urls[used], f.Scrape)
+			if err != nil {
+				log.Error("Error scraping", classAttrs(errClassParse, err)...)
+				f.failures.Add(1)
+				f.stats.add(&f.stats.parseErrors, 1)
+				f.setError(err)
+				return nil
+			}
+			f.stampUndated(result, time.Now().UTC())
+			f.failures.Store(0)
+			return result
+		}
 
-		result, err := fp.Parse(bytes.NewReader(resp.Body()))
+		result, err := p.parse(resp.Body())
 		if err != nil {
-			fmt.Printf("[%s] Error parsing: %v\n", f.Name, err)
+			log.Error("Error parsing", classAttrs(errClassParse, err)...)
+			f.failures.Add(1)
+			f.stats.add(&f.stats.parseErrors, 1)
+			f.setError(err)
 			return nil
 		}
+		if hub, self := findHubLinks(resp.Header.PeekAll("Link"), resp.Body()); hub != "" {
+			if self == "" {
+				self = urls[used]
+			}
+			f.setHub(hub, self)
+		}
+		f.failures.Store(0)
 		return result
 	}
-	fmt.Printf("[%s] Failed to fetch, status code: %d\n", f.Name, resp.StatusCode())
+	log.Error("Failed to fetch", "status", resp.StatusCode(), "duration", time.Since(start), "error_class", errClassHTTP)
+	f.failures.Add(1)
+	f.setError(fmt.Errorf("fetch returned status: %d", resp.StatusCode()))
 	return nil
 }
 
-// sanitizeMessage cleans up the message content and title
-func (fm *FeedsMonitor) sanitizeMessage(item *gofeed.Item, tagsLen int) (title, description string) {
+// parse parses a feed document using a pooled gofeed.Parser
+func (p *Parser) parse(body []byte) (*gofeed.Feed, error) {
+	fp := p.parserPool.Get().(*gofeed.Parser)
+	defer p.parserPool.Put(fp)
+
+	return fp.Parse(bytes.NewReader(body))
+}
+
+// sanitizeMessage cleans up the message content and title.
+// The description is shortened so the post fits in limit characters, unless limit is zero.
+func sanitizeMessage(item *gofeed.Item, tagsLen, limit int) (title, description string) {
 	description = item.Description
 	if item.Content != "" {
 		description = item.Content
@@ -381,10 +729,14 @@ func (fm *FeedsMonitor) sanitizeMessage(item *gofeed.Item, tagsLen int) (title,
 	description = html.UnescapeString(strings.TrimSpace(description))
 	title = html.UnescapeString(item.Title)
 
-	// Check if the post is too long
+	// Check if the post is too long (no limit if zero)
 	l := len(title) + tagsLen + len(item.Link)
-	if l+len(description) > fm.Instance.Limit {
-		n := fm.Instance.Limit - l - 11
+	if limit > 0 && l+len(description) > limit {
+		n := limit - l - 11
+		if n <= 2 {
+			// no room left for the description
+			return title, ""
+		}
 		description = description[:n]
 		n = strings.LastIndexAny(description, " .,;!?")
 		if n > 0 {
@@ -394,7 +746,7 @@ func (fm *FeedsMonitor) sanitizeMessage(item *gofeed.Item, tagsLen int) (title,
 			}
 		}
 		l = len(description)
-		if description[l-2] == ' ' {
+		if l > 1 && description[l-2] == ' ' {
 			description = description[:l-2]
 		}
 		description = description + " [...]"
@@ -402,6 +754,37 @@ func (fm *FeedsMonitor) sanitizeMessage(item *gofeed.Item, tagsLen int) (title,
 	return
 }
 
+// composeMessage joins the parts of a post, separated by blank lines
+func composeMessage(title, description, hashtags, link string) string {
+	sb := strings.Builder{}
+	sb.WriteString(title)
+	sb.WriteString("\n\n")
+
+	if description != "" {
+		sb.WriteString(description)
+		sb.WriteString("\n\n")
+	}
+	if hashtags != "" {
+		sb.WriteString(hashtags)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString(link)
+	return sb.String()
+}
+
+// itemImage returns the URL of the item image or of the first image enclosure
+func itemImage(item *gofeed.Item) string {
+	if item.Image != nil && item.Image.URL != "" {
+		return item.Image.URL
+	}
+	for _, e := range item.Enclosures {
+		if strings.HasPrefix(e.Type, "image/") {
+			return e.URL
+		}
+	}
+	return ""
+}
+
 var replacer = strings.NewReplacer(" - ", " ", " i ", ": ")
 
 // makeHashtags constructs hashtags from item categories or link matching
@@ -410,10 +793,15 @@ var replacer = strings.NewReplacer(" - ", " ", " i ", ": ")
 // If HashLink regex is provided, it's used to extract hashtags from the item link
 // Prefix is added to hashtags if specified
 func makeHashtags(item *gofeed.Item, f *Feed, re *regexp.Regexp) (hashtags string) {
+	return makeTags(item, f.HashTag, f.Prefix, re)
+}
+
+// makeTags constructs hashtags with the given static hashtag and prefix, see makeHashtags
+func makeTags(item *gofeed.Item, hashTag, prefix string, re *regexp.Regexp) (hashtags string) {
 	var aTags []string
 
-	if f.HashTag != "" {
-		aTags = append(aTags, f.HashTag)
+	if hashTag != "" {
+		aTags = append(aTags, hashTag)
 	}
 
 	if item.Categories != nil {
@@ -438,7 +826,7 @@ func makeHashtags(item *gofeed.Item, f *Feed, re *regexp.Regexp) (hashtags strin
 				tag := res[0][1]
 				tag = hashDict(tag)
 				if !strings.Contains(tag, "-") {
-					if f.Prefix == "" || !strings.HasPrefix(tag, f.Prefix) {
+					if prefix == "" || !strings.HasPrefix(tag, prefix) {
 						tag = casesTitle.String(tag)
 					}
 					aTags = append(aTags, tag)
@@ -449,10 +837,10 @@ func makeHashtags(item *gofeed.Item, f *Feed, re *regexp.Regexp) (hashtags strin
 
 	l := len(aTags)
 	if l > 0 {
-		if f.Prefix != "" {
+		if prefix != "" {
 			for i := range l {
-				if !strings.Contains(aTags[i], f.Prefix) {
-					aTags = append(aTags, f.Prefix+casesTitle.String(aTags[i]))
+				if !strings.Contains(aTags[i], prefix) {
+					aTags = append(aTags, prefix+casesTitle.String(aTags[i]))
 				}
 			}
 		}
@@ -1,7 +1,10 @@
 package rss2masto
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 	"sync"
@@ -38,12 +41,50 @@ type FeedsMonitor struct {
 		Feeds    []*Feed `yaml:"feed"`
 	} `yaml:"instance"`
 
-	Parser     *Parser
+	// Server configures the embedded HTTP server (see ListenAndServe)
+	Server ServerConfig `yaml:"server,omitempty"`
+	// WebSub configures push subscriptions for feeds advertising a hub
+	WebSub WebSubConfig `yaml:"websub,omitempty"`
+	// Scheduler limits the concurrency of feed fetching
+	Scheduler SchedulerConfig `yaml:"scheduler,omitempty"`
+	// State selects where runtime state (last run timestamps, validators) is stored
+	State StateConfig `yaml:"state,omitempty"`
+	// Instances lists named Mastodon instances feeds can be assigned to
+	Instances []*MastodonInstance `yaml:"instances,omitempty"`
+	// Publishers lists named publishers (Bluesky, webhooks) feeds can publish to
+	Publishers []*PublisherConfig `yaml:"publishers,omitempty"`
+	// Dedup configures the detection of stories posted by several feeds of a dedup group
+	Dedup DedupConfig `yaml:"dedup,omitempty"`
+	// PostHistory sets the retention of the post history, see History
+	PostHistory HistoryConfig `yaml:"history,omitempty"`
+	// Alerts configures the notifications of failing feeds and accounts
+	Alerts AlertConfig `yaml:"alerts,omitempty"`
+
+	Parser     *Parser      `yaml:"-"`
+	Logger     *slog.Logger `yaml:"-"` // logger of the monitor, see SetLogger; the package logger if nil
+	Hooks      Hooks        `yaml:"-"` // receives the events of the monitor, see SetHooks
 	hostClient httpClient
 	isStarted  atomic.Bool
 	lastCheck  atomic.Int64
 	lastMonit  atomic.Int64
 	location   *time.Location
+	subs       sync.Map     // WebSub callback id -> *subscription
+	hostSlots  sync.Map     // feed host -> chan struct{} limiting concurrent fetches
+	runMu      sync.Mutex   // held by Start for a whole cycle
+	reloadMu   sync.Mutex   // serialises Reload
+	configMu   sync.RWMutex // guards the feed list, instances and publishers replaced by Reload
+	publishers map[string]Publisher
+	recent     dedupIndex                   // recently posted items of dedup groups
+	posts      postStats                    // post latency and errors of the metrics endpoint
+	lastStart  atomic.Int64                 // Unix time in nanoseconds of the last Start
+	tick       atomic.Int64                 // time between the last two Starts
+	lastFinish atomic.Int64                 // Unix time in nanoseconds the last Start finished
+	ready      readyCache                   // last result of Ready
+	alerts     alerter                      // problems notified to the alert channels
+	polls      engagementPolls              // last engagement poll of the recent statuses
+	workerMu   sync.Mutex                   // guards workers
+	workers    chan struct{}                // limits feeds processed at once, see acquireWorker
+	instances  map[string]*MastodonInstance // instance name -> instance, "" is the instance block
 }
 
 // FeedURLs holds one or more RSS feed URLs with YAML unmarshaling support for both
@@ -80,9 +121,15 @@ func (u FeedURLs) MarshalYAML() (any, error) {
 
 // Feed holds the configuration and runtime state for a single RSS/Atom feed.
 type Feed struct {
-	Name        string                 `yaml:"name"`                   // feed identifier used in logs and idempotency keys
+	Name        string                 `yaml:"name"`                   // feed identifier used in logs
+	FeedID      string                 `yaml:"id,omitempty"`           // stable identifier of the feed's cache keys, see namespace
 	URLs        FeedURLs               `yaml:"url"`                    // RSS feed endpoint(s); first is primary, rest are fallbacks
-	Token       string                 `yaml:"token"`                  // Mastodon API access token
+	Token       string                 `yaml:"token"`                  // Mastodon API access token, ${VAR} references are expanded
+	TokenFile   string                 `yaml:"token_file,omitempty"`   // file containing the token, overrides Token
+	TokenEnv    string                 `yaml:"token_env,omitempty"`    // environment variable containing the token, overrides Token
+	Instance    string                 `yaml:"instance,omitempty"`     // name of the Mastodon instance, the instance block if empty
+	Targets     []*Target              `yaml:"targets,omitempty"`      // additional accounts the feed is cross-posted to
+	Publish     []string               `yaml:"publish,omitempty"`      // names of additional publishers, see FeedsMonitor.Publishers
 	Prefix      string                 `yaml:"prefix,omitempty"`       // optional hashtag prefix added to every generated tag
 	Visibility  string                 `yaml:"visibility,omitempty"`   // post visibility: public, unlisted, or private
 	HashLink    string                 `yaml:"hashlink,omitempty"`     // regex with one capture group to extract a hashtag from the item link
@@ -90,6 +137,12 @@ type Feed struct {
 	ReplaceFrom string                 `yaml:"replace_from,omitempty"` // regex pattern applied to post description
 	ReplaceTo   string                 `yaml:"replace_to,omitempty"`   // replacement string for ReplaceFrom matches
 	ReplaceLink string                 `yaml:"replace_link,omitempty"` // regex applied to item link — all matches are removed before posting
+	Template    string                 `yaml:"template,omitempty"`     // text/template of the message, fields: Feed, Title, Description, Hashtags, Link, Image
+	UpdateURL   bool                   `yaml:"update_url,omitempty"`   // replace a homepage URL with the feed URL found by autodiscovery
+	Source      string                 `yaml:"source,omitempty"`       // item source: rss (default) or scrape
+	Dedup       string                 `yaml:"dedup,omitempty"`        // dedup group: items posted by feeds of the group aren't posted again
+	Scrape      *ScrapeRules           `yaml:"scrape,omitempty"`       // CSS selectors used when source is scrape
+	Profile     *ProfileConfig         `yaml:"profile,omitempty"`      // sync the profile of the account with the feed metadata
 	Interval    int64                  `yaml:"interval,omitempty"`     // scheduler ticks between checks
 	LastRun     int64                  `yaml:"last_run,omitempty"`     // Unix timestamp of the last processed item
 	Count       int64                  `yaml:"-"`                      // number of items posted in the current run
@@ -99,6 +152,28 @@ type Feed struct {
 	Followers   atomic.Int64           `yaml:"-"`                      // follower count, updated concurrently
 	shedCounter atomic.Int64           `yaml:"-"`
 	etag        atomic.Pointer[[]byte] `yaml:"-"`
+	hub         atomic.Pointer[hubRef] `yaml:"-"` // WebSub hub advertised by the feed
+	mu          sync.Mutex             `yaml:"-"` // serialises processing of polled and pushed items
+	token       string                 `yaml:"-"` // resolved token, see accessToken
+	failures    atomic.Int64           `yaml:"-"` // consecutive failed fetches
+	statuses    map[string]string      `yaml:"-"` // publisher name -> ID of the last post, guarded by mu
+	configURLs  FeedURLs               `yaml:"-"` // URLs as configured, before update_url replacements
+	keyPrefix   string                 `yaml:"-"` // namespace of the stored cache keys, see migrateKeys
+	stats       feedStats              `yaml:"-"` // counters of the metrics endpoint
+	paused      atomic.Bool            `yaml:"-"` // paused feeds aren't polled, see SetPaused
+	fetching    atomic.Bool            `yaml:"-"` // the feed is being fetched and processed, or its push processed, see getFeed and endFetch
+	lastError   atomic.Pointer[issue]  `yaml:"-"` // last fetch, parse or post error
+	verified    atomic.Bool            `yaml:"-"` // the token was verified, see updateFeedData
+	health      atomic.Value           `yaml:"-"` // FeedHealth last reported to OnFeedStateChanged
+	profile     string                 `yaml:"-"` // hash of the last synced profile, guarded by mu, see syncProfile
+	profileErr  string                 `yaml:"-"` // hash of the last failed profile update, guarded by mu
+	profileDue  time.Time              `yaml:"-"` // earliest retry of profileErr, guarded by mu
+	scraped     map[string]int64       `yaml:"-"` // undated items of the last scraped page by GUID, guarded by mu, see stampUndated
+	failedPosts map[string]failedPost  `yaml:"-"` // items retried after a failed post by GUID, guarded by mu, see giveUpPosts
+	pushed      *gofeed.Feed           `yaml:"-"` // content pushed while the feed was being processed, see endFetch
+	pushMu      sync.Mutex             `yaml:"-"` // guards pushed
+	preview     bool                   `yaml:"-"` // temporary copy fetched by PreviewPost, without hooks
+	cfgMu       sync.RWMutex           `yaml:"-"` // guards Name, FeedID, URLs, Instance and the tokens, see label
 }
 
 // MastodonPost holds the data needed to post to Mastodon
@@ -131,9 +206,23 @@ type httpClient interface {
 // Parser wraps gofeed.Parser with an HTTP client and sync.Pool for efficient reuse
 type Parser struct {
 	Client     httpClient
+	Logger     *slog.Logger // logger of the parser, the package logger if nil
+	Hooks      Hooks        // receives OnFetched events, see FeedsMonitor.SetHooks
 	parserPool sync.Pool
 }
 
+// clientTimeout is the read and write timeout of the default HTTP clients
+const clientTimeout = 15 * time.Second
+
+// webClient returns the client of the parser for outgoing requests, or a
+// client with the same timeouts when the monitor has no parser
+func (fm *FeedsMonitor) webClient() httpClient {
+	if fm.Parser != nil {
+		return fm.Parser.Client
+	}
+	return &fasthttp.Client{ReadTimeout: clientTimeout, WriteTimeout: clientTimeout}
+}
+
 // NewParser creates a new RSS parser with optional custom HTTP client
 // If no client is provided, a default fasthttp.Client is created with:
 // - 1MB max response size
@@ -147,8 +236,8 @@ func NewParser(c httpClient) *Parser {
 			MaxResponseBodySize:      1024 * 1024, // 1MB limit
 			ReadBufferSize:           4096 * 2,    // 2 * default
 			MaxConnsPerHost:          10,
-			ReadTimeout:              15 * time.Second,
-			WriteTimeout:             15 * time.Second,
+			ReadTimeout:              clientTimeout,
+			WriteTimeout:             clientTimeout,
 			NoDefaultUserAgentHeader: true,
 			// increase DNS cache time to an hour instead of default minute
 			Dial: (&fasthttp.TCPDialer{
@@ -168,39 +257,47 @@ func NewParser(c httpClient) *Parser {
 }
 
 // NewFeedsMonitor creates and initializes a new FeedsMonitor instance by:
+// - Validating the feed configuration (see Validate), warnings are printed
 // - Loading and parsing the feed configuration from YAML file
 // - Setting up monitoring timestamps and intervals
 // - Configuring timezone and language settings
 // - Setting character limits and feed IDs
 // - Initializing default values for all feeds
 func NewFeedsMonitor() (*FeedsMonitor, error) {
-	var fm FeedsMonitor
-
-	file, err := os.ReadFile(configFile)
-	if err != nil {
+	if err := checkConfig(); err != nil {
 		return nil, err
 	}
-	err = yaml.Unmarshal(file, &fm)
+	fm, err := LoadConfig()
 	if err != nil {
 		return nil, err
 	}
-	instanceHost, err := fm.parseURLHost(fm.Instance.URL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid instance URL: %w", err)
+	if err := fm.resolveSecrets(); err != nil {
+		return nil, err
 	}
-
-	fm.hostClient = &fasthttp.HostClient{
-		IsTLS:                  true,
-		Addr:                   instanceHost + ":443",
-		Name:                   "rss2masto",
-		ReadTimeout:            15 * time.Second,
-		WriteTimeout:           15 * time.Second,
-		DisablePathNormalizing: true,
-		Dial: (&fasthttp.TCPDialer{
-			DNSCacheDuration: time.Hour,
-		}).Dial,
+	// the instance block may be left without URL when all feeds use named instances
+	if fm.Instance.URL != "" || len(fm.Instances) == 0 {
+		instanceHost, err := fm.parseURLHost(fm.Instance.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid instance URL: %w", err)
+		}
+		fm.hostClient = newInstanceClient(instanceHost)
+	}
+	if err := fm.initInstances(); err != nil {
+		return nil, err
+	}
+	if err := fm.initTargets(); err != nil {
+		return nil, err
 	}
 	fm.Parser = NewParser(nil)
+	if err := fm.initPublishers(); err != nil {
+		return nil, err
+	}
+
+	// Restore runtime state saved by previous runs
+	state, err := fm.loadState()
+	if err != nil {
+		return nil, err
+	}
 
 	// Set LastMonit to 12 hours ago if not set or older than 12 hours
 	if fm.Instance.Monit == 0 || time.Now().UTC().Sub(time.Unix(fm.Instance.Monit, 0)).Hours() > 12 {
@@ -215,15 +312,41 @@ func NewFeedsMonitor() (*FeedsMonitor, error) {
 		langTag, err = language.Parse(fm.Instance.Lang)
 		if err != nil {
 			langTag = language.English
-			fmt.Println(err, "using default language")
+			fm.log().Warn("Invalid language, using English", classAttrs(errClassConfig, err)...)
 		}
 	}
 	casesTitle = cases.Title(langTag, cases.NoLower)
 
 	// Set default values for feeds and get their IDs
 	fm.setDefaults()
+	fm.applyState(state)
+	fm.migrateKeys(fm.Instance.Feeds)
+
+	return fm, nil
+}
 
-	return &fm, nil
+// SetConfigFile sets the path of the configuration file used by
+// NewFeedsMonitor, LoadConfig and SaveConfig (default "./feed.yaml").
+func SetConfigFile(path string) {
+	configFile = path
+}
+
+// LoadConfig reads the configuration file without contacting the Mastodon instance.
+// The returned monitor is meant for inspecting or editing the configuration
+// (e.g. ImportOPML followed by SaveConfig); use NewFeedsMonitor to monitor feeds.
+func LoadConfig() (*FeedsMonitor, error) {
+	fm := &FeedsMonitor{}
+
+	file, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(file, fm)
+	if err != nil {
+		return nil, err
+	}
+	fm.lastMonit.Store(fm.Instance.Monit)
+	return fm, nil
 }
 
 // NewTestFeed creates a new feed with default values for testing purposes
@@ -239,10 +362,35 @@ func NewTestFeed(name, url string) *Feed {
 
 // URL returns the primary (first) feed URL for convenience.
 func (f *Feed) URL() string {
-	if len(f.URLs) == 0 {
+	urls := f.urlList()
+	if len(urls) == 0 {
 		return ""
 	}
-	return f.URLs[0]
+	return urls[0]
+}
+
+// label returns the name of the feed. Reload renames feeds and replaces their URLs,
+// instance and tokens holding both mu and cfgMu: code holding mu reads these fields
+// directly, other code through label, urlList, instanceName and accessToken.
+func (f *Feed) label() string {
+	f.cfgMu.RLock()
+	defer f.cfgMu.RUnlock()
+	return f.Name
+}
+
+// instanceName returns the name of the feed's instance, "" for the instance block
+func (f *Feed) instanceName() string {
+	f.cfgMu.RLock()
+	defer f.cfgMu.RUnlock()
+	return f.Instance
+}
+
+// urlList returns the feed URLs. The list is replaced, never modified, so it can be
+// used without holding cfgMu.
+func (f *Feed) urlList() FeedURLs {
+	f.cfgMu.RLock()
+	defer f.cfgMu.RUnlock()
+	return f.URLs
 }
 
 // EmptyEtag initialises the etag to an empty slice.
@@ -282,50 +430,139 @@ func (fm *FeedsMonitor) LastMonit() int64 {
 
 // FeedIndex returns the index of the feed with the given name prefix, or -1 if not found
 func (fm *FeedsMonitor) FeedIndex(name string) int {
-	for i, feed := range fm.Instance.Feeds {
-		if strings.HasPrefix(feed.Name, name) {
+	for i, feed := range fm.feeds() {
+		if strings.HasPrefix(feed.label(), name) {
 			return i
 		}
 	}
 	return -1
 }
 
+// FeedByName returns the feed with exactly the given name, or nil if not found
+func (fm *FeedsMonitor) FeedByName(name string) *Feed {
+	for _, f := range fm.feeds() {
+		if f.label() == name {
+			return f
+		}
+	}
+	return nil
+}
+
 // Location returns the timezone location used for time formatting
 func (fm *FeedsMonitor) Location() *time.Location {
 	if fm.location == nil {
 		var err error
 		fm.location, err = time.LoadLocation(fm.Instance.TimeZone)
 		if err != nil {
-			fmt.Println(err)
+			fm.log().Warn("Unknown time zone, using UTC", classAttrs(errClassConfig, err)...)
 			fm.location = time.UTC
 		}
 	}
 	return fm.location
 }
 
-// SaveFeedsData saves the current feed monitoring state to the config file
-func (fm *FeedsMonitor) SaveFeedsData() error {
-	fm.Instance.Monit = fm.LastMonit()
-	out, err := yaml.Marshal(fm)
-	if err != nil {
+// SaveConfig writes the feed list to the configuration file, e.g. after ImportOPML.
+// Only instance.feed is changed: entries of feeds already in the file (same name and URL)
+// are kept as written, other feeds are appended and entries of removed feeds are dropped.
+// Comments and the rest of the file are preserved.
+// The monitor itself never writes the configuration, see SaveState.
+func (fm *FeedsMonitor) SaveConfig() error {
+	data, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	err = os.WriteFile(configFile, out, 0600)
-	if err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return err
 	}
-	return nil
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	instance := mapChild(doc.Content[0], "instance", yaml.MappingNode, "!!map")
+	list := mapChild(instance, "feed", yaml.SequenceNode, "!!seq")
+
+	// entries of the file by feed name and URL
+	written := make(map[string][]*yaml.Node)
+	for _, n := range list.Content {
+		var f Feed
+		if err := n.Decode(&f); err != nil {
+			return err
+		}
+		key := f.Name + "\x00" + f.URL()
+		written[key] = append(written[key], n)
+	}
+	content := make([]*yaml.Node, 0, len(fm.Instance.Feeds))
+	for _, f := range fm.Instance.Feeds {
+		key := f.Name + "\x00" + f.URL()
+		if nodes := written[key]; len(nodes) > 0 {
+			content = append(content, nodes[0])
+			written[key] = nodes[1:]
+			continue
+		}
+		n := &yaml.Node{}
+		if err := n.Encode(f); err != nil {
+			return err
+		}
+		content = append(content, n)
+	}
+	list.Content = content
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(configFile, out.Bytes(), 0600)
+}
+
+// mapChild returns the value of key in a mapping node, replaced by an empty node of
+// the given kind and tag if it is missing or of another kind (e.g. null)
+func mapChild(n *yaml.Node, key string, kind yaml.Kind, tag string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != key {
+			continue
+		}
+		if n.Content[i+1].Kind != kind {
+			n.Content[i+1] = &yaml.Node{Kind: kind, Tag: tag}
+		}
+		return n.Content[i+1]
+	}
+	v := &yaml.Node{Kind: kind, Tag: tag}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
+
+// SaveFeedsData saves the current feed monitoring state.
+//
+// Deprecated: the state is no longer written to the configuration file, use SaveState.
+func (fm *FeedsMonitor) SaveFeedsData() error {
+	return fm.SaveState()
 }
 
-// UpdateFollowers concurrently updates the follower counts for all feeds
+// UpdateFollowers concurrently updates the follower counts for all feeds.
+// The follower and status counts are recorded with the engagement of the recent posts, see Growth.
 func (fm *FeedsMonitor) UpdateFollowers() {
+	fm.configMu.RLock()
+	history := fm.PostHistory
+	fm.configMu.RUnlock()
 	var wg sync.WaitGroup
-	for _, feed := range fm.Instance.Feeds {
+	for _, feed := range fm.feeds() {
 		if feed.Id > 0 {
 			wg.Go(func() {
-				err := fm.getFollowers(feed)
+				s, err := fm.getFollowers(feed)
 				if err != nil {
-					fmt.Printf("[%s] Error getting followers: %v\n", feed.Name, err)
+					fm.feedLog(feed).Warn("Error getting followers", errAttrs(err)...)
+					if s == nil {
+						return
+					}
+				}
+				err = recordAccount(history, feed, s)
+				if err != nil && !errors.Is(err, errOffline) {
+					fm.feedLog(feed).Error("Error recording followers", classAttrs(errClassCache, err)...)
 				}
 			})
 		}
@@ -333,109 +570,135 @@ func (fm *FeedsMonitor) UpdateFollowers() {
 	wg.Wait()
 }
 
-// getFollowers gets the followers count for a feed from the Mastodon API
-func (fm *FeedsMonitor) getFollowers(feed *Feed) error {
-	b, err := fm.GetFromInstance(fmt.Sprintf("/api/v1/accounts/%d", feed.Id))
+// getFollowers gets the followers and statuses count for a feed from the Mastodon API,
+// and the engagement of its recent posts. The sample is returned without engagement if
+// only the statuses can't be read.
+func (fm *FeedsMonitor) getFollowers(feed *Feed) (*AccountSample, error) {
+	fm.configMu.RLock()
+	mi := fm.feedInstance(feed)
+	fm.configMu.RUnlock()
+	if mi == nil {
+		return nil, fmt.Errorf("unknown instance %q", feed.Instance)
+	}
+	b, err := mi.Get(fmt.Sprintf("/api/v1/accounts/%d", feed.Id))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	feed.Followers.Store(jsoniter.Get(b, "followers_count").ToInt64())
-	return nil
+	s := &AccountSample{
+		Time:      time.Now(),
+		Followers: jsoniter.Get(b, "followers_count").ToInt64(),
+		Statuses:  jsoniter.Get(b, "statuses_count").ToInt64(),
+	}
+	feed.setFollowers(s.Followers)
+	if err := fm.getEngagement(mi, feed, s); err != nil {
+		return s, fmt.Errorf("unable to get statuses: %w", err)
+	}
+	return s, nil
 }
 
 // setDefaults sets default values for feeds that don't have them set
 func (fm *FeedsMonitor) setDefaults() {
 
-	// Set instance characters limit if not set
-	if fm.Instance.Limit == 0 {
-		fm.Instance.Limit = fm.getInstanceLimit()
-	}
-
-	feedNameReplacer := strings.NewReplacer("\n", "\\n", "\r", "\\r")
+	// Discover instance characters limits (unless set) and capabilities
+	fm.discoverInstances()
 
 	for _, feed := range fm.Instance.Feeds {
-		if feed.LastRun == 0 {
-			feed.LastRun = fm.LastMonit()
-		}
-		if feed.Interval == 0 {
-			feed.Interval = DefaultCheckInterval
-		}
-
-		if !visibilityTypes[feed.Visibility] {
-			feed.Visibility = "private"
-		}
-
-		if feed.Name == "" {
-			url := fasthttp.AcquireURI()
-			defer fasthttp.ReleaseURI(url)
-
-			err := url.Parse(nil, s2b(feed.URL()))
-			if err == nil {
-				feed.Name = string(url.Host())
-			}
-		}
-		// Sanitize feed.Name
-		feed.Name = feedNameReplacer.Replace(feed.Name)
-		if len(feed.Name) == 1 {
-			feed.Name += "_"
-		}
-
-		// Initialise empty etag for the feed
-		feed.EmptyEtag()
+		fm.setFeedDefaults(feed)
 
 		// Update feed data including ID and followers count
 		if err := fm.updateFeedData(feed); err != nil {
-			fmt.Println(err)
+			fm.feedLog(feed).Error("Error verifying credentials", errAttrs(err)...)
 		}
 	}
 }
 
-// Get instance characters limit
-// If the instance returns a valid limit, it's used; otherwise, the default limit is returned
-func (fm *FeedsMonitor) getInstanceLimit() (limit int) {
-	limit = DefaultCharacterLimit
+var feedNameReplacer = strings.NewReplacer("\n", "\\n", "\r", "\\r")
 
-	b, err := fm.GetFromInstance("/api/v1/instance")
-	if err != nil {
-		b, err = fm.GetFromInstance("/api/v2/instance")
-		if err != nil {
-			fmt.Println("Error getting instance data from", fm.Instance.URL, ":", err)
-			return
-		}
+// setFeedDefaults sets default values of a single feed without contacting the instance
+func (fm *FeedsMonitor) setFeedDefaults(feed *Feed) {
+	if feed.LastRun == 0 {
+		feed.LastRun = fm.LastMonit()
 	}
-	i := jsoniter.Get(b, "configuration", "statuses", "max_characters").ToInt()
-	if i == 0 {
-		// Akkoma/Pleroma compatibility
-		i = jsoniter.Get(b, "max_toot_chars").ToInt()
+	if feed.Interval == 0 {
+		feed.Interval = DefaultCheckInterval
 	}
-	if i > 0 {
-		limit = i
+	if feed.configURLs == nil {
+		feed.configURLs = feed.URLs
 	}
-	return
+
+	if !visibilityTypes[feed.Visibility] {
+		feed.Visibility = "private"
+	}
+
+	if feed.Name == "" {
+		url := fasthttp.AcquireURI()
+		defer fasthttp.ReleaseURI(url)
+
+		err := url.Parse(nil, s2b(feed.URL()))
+		if err == nil {
+			feed.Name = string(url.Host())
+		}
+	}
+	// Sanitize feed.Name
+	feed.Name = feedNameReplacer.Replace(feed.Name)
+	if len(feed.Name) == 1 {
+		feed.Name += "_"
+	}
+
+	// Initialise empty etag for the feed
+	feed.EmptyEtag()
 }
 
 // updateFeedData gets the Mastodon account ID and followers count for a feed
 // The function verifies the token and retrieves the account ID and followers count
 func (fm *FeedsMonitor) updateFeedData(feed *Feed) error {
-	if feed.Token == "" {
-		return fmt.Errorf("[%s] Missing token", feed.Name)
+	return fm.verifyCredentials(feed, fm.feedInstance(feed))
+}
+
+// verifyCredentials verifies the token of a feed on the instance mi, see updateFeedData.
+// Reload verifies the feeds of the next configuration with its instances.
+func (fm *FeedsMonitor) verifyCredentials(feed *Feed, mi *MastodonInstance) (err error) {
+	if feed.accessToken() == "" {
+		return errors.New("missing token")
 	}
+	defer func() {
+		// network errors and outages of the instance say nothing about the token
+		if err == nil || credentialsRejected(err) {
+			fm.checkAlert(AlertCredentials, feed, "", err)
+		}
+	}()
 
-	b, err := fm.GetFromInstance("/api/v1/accounts/verify_credentials", feed.Token)
+	if mi == nil {
+		return fmt.Errorf("unknown instance %q", feed.Instance)
+	}
+	b, err := mi.Get("/api/v1/accounts/verify_credentials", feed.accessToken())
 	if err != nil {
-		return fmt.Errorf("[%s] Unable to get credentials: %w", feed.Name, err)
+		return fmt.Errorf("unable to get credentials: %w", err)
 	}
 	id := jsoniter.Get(b, "id").ToInt64()
 	if id == 0 {
-		return fmt.Errorf("[%s] Invalid token", feed.Name)
+		return errInvalidToken
 	}
 	feed.Id = id
+	feed.verified.Store(true)
 	feed.Language = jsoniter.Get(b, "source", "language").ToString()
-	feed.Followers.Store(jsoniter.Get(b, "followers_count").ToInt64())
+	feed.setFollowers(jsoniter.Get(b, "followers_count").ToInt64())
 
 	return nil
 }
 
+// errInvalidToken is returned by verifyCredentials for a response without account
+var errInvalidToken = errors.New("invalid token")
+
+// credentialsRejected reports whether the instance rejected the token of a feed
+func credentialsRejected(err error) bool {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status == fasthttp.StatusUnauthorized || httpErr.Status == fasthttp.StatusForbidden
+	}
+	return errors.Is(err, errInvalidToken)
+}
+
 // parseURLHost parses a URL and returns the host portion
 // It validates that the URL uses HTTPS scheme and has no path traversal attempts
 func (fm *FeedsMonitor) parseURLHost(rawURL string) (string, error) {
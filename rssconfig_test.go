@@ -128,6 +128,30 @@ func TestFeedIndex(t *testing.T) {
 	}
 }
 
+func TestFeedByName(t *testing.T) {
+	fm := &FeedsMonitor{}
+	fm.Instance.Feeds = []*Feed{{Name: "newsroom"}, {Name: "news"}}
+
+	if f := fm.FeedByName("news"); f != fm.Instance.Feeds[1] {
+		t.Errorf("FeedByName(news) = %v, want the feed named news, not newsroom", f)
+	}
+	if f := fm.FeedByName("new"); f != nil {
+		t.Errorf("FeedByName(new) = %v, want nil for a prefix", f)
+	}
+}
+
+func TestWebClient(t *testing.T) {
+	fm := &FeedsMonitor{}
+	c, ok := fm.webClient().(*fasthttp.Client)
+	if !ok || c.ReadTimeout != clientTimeout || c.WriteTimeout != clientTimeout {
+		t.Errorf("webClient() without a parser = %+v, want the parser timeouts", c)
+	}
+	fm.Parser = NewParser(nil)
+	if fm.webClient() != fm.Parser.Client {
+		t.Error("webClient() is not the parser client")
+	}
+}
+
 func TestLastCheckStr(t *testing.T) {
 	fm := &FeedsMonitor{location: time.UTC}
 
@@ -156,10 +180,9 @@ func (m *mockHostClient) Do(req *fasthttp.Request, resp *fasthttp.Response) erro
 	return m.handler(req, resp)
 }
 
-func TestGetInstanceLimit(t *testing.T) {
-	fm := &FeedsMonitor{}
-	fm.Instance.URL = "https://mastodon.example"
-	fm.hostClient = &mockHostClient{
+func TestDiscoverInstanceLimit(t *testing.T) {
+	mi := &MastodonInstance{URL: "https://mastodon.example"}
+	mi.client = &mockHostClient{
 		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
 			resp.SetStatusCode(fasthttp.StatusOK)
 			resp.SetBodyString(`{"configuration":{"statuses":{"max_characters":1000}}}`)
@@ -167,24 +190,23 @@ func TestGetInstanceLimit(t *testing.T) {
 		},
 	}
 
-	limit := fm.getInstanceLimit()
-	if limit != 1000 {
-		t.Errorf("getInstanceLimit() = %v, want 1000", limit)
+	mi.discover()
+	if mi.Limit != 1000 {
+		t.Errorf("discover() limit = %v, want 1000", mi.Limit)
 	}
 }
 
-func TestGetInstanceLimitDefault(t *testing.T) {
-	fm := &FeedsMonitor{}
-	fm.Instance.URL = "https://mastodon.example"
-	fm.hostClient = &mockHostClient{
+func TestDiscoverInstanceLimitDefault(t *testing.T) {
+	mi := &MastodonInstance{URL: "https://mastodon.example"}
+	mi.client = &mockHostClient{
 		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
 			return fmt.Errorf("connection refused")
 		},
 	}
 
-	limit := fm.getInstanceLimit()
-	if limit != DefaultCharacterLimit {
-		t.Errorf("getInstanceLimit() on error = %v, want %v", limit, DefaultCharacterLimit)
+	mi.discover()
+	if mi.Limit != DefaultCharacterLimit {
+		t.Errorf("discover() limit on error = %v, want %v", mi.Limit, DefaultCharacterLimit)
 	}
 }
 
@@ -0,0 +1,173 @@
+package rss2masto
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// SchedulerConfig limits how due feeds are fetched by Start
+type SchedulerConfig struct {
+	Workers   int           `yaml:"workers,omitempty"`    // max feeds processed at once, unlimited if zero
+	HostLimit int           `yaml:"host_limit,omitempty"` // max concurrent fetches per feed host, unlimited if zero
+	Jitter    time.Duration `yaml:"jitter,omitempty"`     // window over which due feeds are spread, should be shorter than the tick
+}
+
+// hostRetry is how long a worker whose queued feeds all wait for a host slot sleeps,
+// unless a slot is freed earlier by the worker pool
+const hostRetry = 100 * time.Millisecond
+
+// dispatch processes due feeds with a pool of Scheduler.Workers workers, one per feed
+// without a limit; pool workers also take a slot of acquireWorker, shared with WebSub pushes.
+// Each feed is queued at a stable offset within the Scheduler.Jitter window,
+// derived from its name, so feeds with equal intervals don't fire together.
+// A feed whose host has no free slot is queued again and the worker takes the
+// next one, so feeds of a saturated host don't hold up the other hosts.
+// It returns when all feeds are processed.
+func (fm *FeedsMonitor) dispatch(due []*Feed) {
+	if len(due) == 0 {
+		return
+	}
+	fm.configMu.RLock()
+	sched := fm.Scheduler
+	fm.configMu.RUnlock()
+
+	type job struct {
+		feed   *Feed
+		offset time.Duration
+	}
+	jobs := make([]job, len(due))
+	for i, feed := range due {
+		jobs[i].feed = feed
+		if sched.Jitter > 0 {
+			jobs[i].offset = time.Duration(xxh3.HashString(feed.label()) % uint64(sched.Jitter))
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].offset < jobs[j].offset
+	})
+
+	// every feed is either queued or taken by one worker, so sends never block
+	queue := make(chan *Feed, len(jobs))
+	freed := make(chan struct{}, 1)
+	var pending, wg sync.WaitGroup
+	pending.Add(len(jobs))
+	workers := len(jobs)
+	if sched.Workers > 0 {
+		workers = min(sched.Workers, workers)
+	}
+	for range workers {
+		wg.Go(func() {
+			busy := 0
+			for f := range queue {
+				release, ok := fm.tryAcquireHost(f)
+				if !ok {
+					queue <- f
+					if busy++; busy >= len(queue) {
+						// all queued feeds wait for their hosts
+						busy = 0
+						select {
+						case <-freed:
+						case <-time.After(hostRetry):
+						}
+					}
+					continue
+				}
+				busy = 0
+				done := fm.acquireWorker()
+				fm.getFeed(f, func() {
+					release()
+					select {
+					case freed <- struct{}{}:
+					default:
+					}
+				})
+				done()
+				pending.Done()
+			}
+		})
+	}
+
+	start := time.Now()
+	for _, j := range jobs {
+		if wait := time.Until(start.Add(j.offset)); wait > 0 {
+			time.Sleep(wait)
+		}
+		queue <- j.feed
+	}
+	pending.Wait()
+	close(queue)
+	wg.Wait()
+}
+
+// acquireWorker waits for one of the Scheduler.Workers slots, shared by scheduled
+// fetches and WebSub pushes, and returns the function releasing it.
+// Without a limit it returns immediately.
+func (fm *FeedsMonitor) acquireWorker() func() {
+	fm.configMu.RLock()
+	limit := fm.Scheduler.Workers
+	fm.configMu.RUnlock()
+	if limit <= 0 {
+		return func() {}
+	}
+	fm.workerMu.Lock()
+	if cap(fm.workers) != limit {
+		// sized by the limit, replaced when a reload changes it
+		fm.workers = make(chan struct{}, limit)
+	}
+	slots := fm.workers
+	fm.workerMu.Unlock()
+
+	slots <- struct{}{}
+	return func() {
+		<-slots
+	}
+}
+
+// acquireHost waits for a free fetch slot for the feed's host and returns
+// the function releasing it. Without Scheduler.HostLimit it returns immediately.
+func (fm *FeedsMonitor) acquireHost(f *Feed) func() {
+	slots := fm.hostSlotsOf(f)
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() {
+		<-slots
+	}
+}
+
+// tryAcquireHost is acquireHost without waiting: it reports false if the host has no free slot
+func (fm *FeedsMonitor) tryAcquireHost(f *Feed) (func(), bool) {
+	slots := fm.hostSlotsOf(f)
+	if slots == nil {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() {
+			<-slots
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// hostSlotsOf returns the fetch slots of the feed's host, nil without Scheduler.HostLimit
+func (fm *FeedsMonitor) hostSlotsOf(f *Feed) chan struct{} {
+	fm.configMu.RLock()
+	limit := fm.Scheduler.HostLimit
+	fm.configMu.RUnlock()
+	if limit <= 0 {
+		return nil
+	}
+	host := f.URL()
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	v, _ := fm.hostSlots.LoadOrStore(host, make(chan struct{}, limit))
+	return v.(chan struct{})
+}
@@ -0,0 +1,218 @@
+package rss2masto
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// concurrencyClient answers 304 Not Modified after a short delay and records
+// the maximum number of concurrent requests, overall and per host.
+type concurrencyClient struct {
+	mu      sync.Mutex
+	active  map[string]int
+	maxHost map[string]int
+	total   atomic.Int64
+	maxAll  atomic.Int64
+	started []time.Time
+}
+
+func (c *concurrencyClient) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	host := string(req.URI().Host())
+
+	c.mu.Lock()
+	c.active[host]++
+	c.maxHost[host] = max(c.maxHost[host], c.active[host])
+	c.started = append(c.started, time.Now())
+	c.mu.Unlock()
+
+	n := c.total.Add(1)
+	for {
+		m := c.maxAll.Load()
+		if n <= m || c.maxAll.CompareAndSwap(m, n) {
+			break
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.total.Add(-1)
+	c.mu.Lock()
+	c.active[host]--
+	c.mu.Unlock()
+
+	resp.SetStatusCode(fasthttp.StatusNotModified)
+	return nil
+}
+
+func newSchedulerMonitor(feeds int, hosts int) (*FeedsMonitor, *concurrencyClient) {
+	client := &concurrencyClient{active: map[string]int{}, maxHost: map[string]int{}}
+	fm := &FeedsMonitor{Parser: NewParser(client)}
+	for i := range feeds {
+		feed := NewTestFeed(fmt.Sprintf("feed-%d", i), fmt.Sprintf("https://host%d.example.com/feed-%d.xml", i%hosts, i))
+		fm.Instance.Feeds = append(fm.Instance.Feeds, feed)
+	}
+	return fm, client
+}
+
+func TestDispatch_Workers(t *testing.T) {
+	fm, client := newSchedulerMonitor(10, 10)
+	fm.Scheduler.Workers = 3
+
+	fm.dispatch(fm.Instance.Feeds)
+
+	if len(client.started) != 10 {
+		t.Errorf("fetched %d feeds, want 10", len(client.started))
+	}
+	if got := client.maxAll.Load(); got > 3 {
+		t.Errorf("max concurrent fetches = %d, want <= 3", got)
+	}
+}
+
+func TestDispatch_WorkerPool(t *testing.T) {
+	fm, client := newSchedulerMonitor(40, 2)
+	fm.Scheduler.Workers, fm.Scheduler.HostLimit = 3, 1
+	goroutines := &goroutineCount{}
+	fm.Parser.Hooks = goroutines
+
+	base := runtime.NumGoroutine()
+	fm.dispatch(fm.Instance.Feeds)
+
+	if len(client.started) != 40 {
+		t.Errorf("fetched %d feeds, want 40", len(client.started))
+	}
+	// 3 workers, not one goroutine per due feed
+	if got := goroutines.max.Load() - int64(base); got > 5 {
+		t.Errorf("dispatch started %d goroutines, want a pool of 3 workers", got)
+	}
+}
+
+// goroutineCount records the maximum number of goroutines seen when a feed is fetched
+type goroutineCount struct {
+	NopHooks
+	max atomic.Int64
+}
+
+func (h *goroutineCount) OnFetched(f *Feed, status, size int) {
+	n := int64(runtime.NumGoroutine())
+	for {
+		m := h.max.Load()
+		if n <= m || h.max.CompareAndSwap(m, n) {
+			return
+		}
+	}
+}
+
+func TestDispatch_Unlimited(t *testing.T) {
+	fm, client := newSchedulerMonitor(8, 8)
+
+	fm.dispatch(fm.Instance.Feeds)
+
+	if got := client.maxAll.Load(); got < 2 {
+		t.Errorf("max concurrent fetches = %d, expected parallel fetching without limits", got)
+	}
+}
+
+func TestDispatch_HostLimit(t *testing.T) {
+	fm, client := newSchedulerMonitor(12, 2)
+	fm.Scheduler.HostLimit = 2
+
+	fm.dispatch(fm.Instance.Feeds)
+
+	if len(client.started) != 12 {
+		t.Errorf("fetched %d feeds, want 12", len(client.started))
+	}
+	for host, n := range client.maxHost {
+		if n > 2 {
+			t.Errorf("max concurrent fetches for %s = %d, want <= 2", host, n)
+		}
+	}
+}
+
+func TestDispatch_Jitter(t *testing.T) {
+	fm, client := newSchedulerMonitor(6, 6)
+	fm.Scheduler.Jitter = 200 * time.Millisecond
+
+	start := time.Now()
+	fm.dispatch(fm.Instance.Feeds)
+
+	first, last := client.started[0], client.started[len(client.started)-1]
+	if last.Sub(first) < 20*time.Millisecond {
+		t.Errorf("fetches not spread: first at %v, last at %v", first.Sub(start), last.Sub(start))
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("dispatch took %v, longer than the jitter window", time.Since(start))
+	}
+}
+
+func TestAcquireHost(t *testing.T) {
+	fm := &FeedsMonitor{}
+	fm.Scheduler.HostLimit = 1
+	a := NewTestFeed("a", "https://medium.com/feed/a")
+	b := NewTestFeed("b", "https://medium.com/feed/b")
+	c := NewTestFeed("c", "https://other.example.com/feed")
+
+	release := fm.acquireHost(a)
+
+	acquired := make(chan struct{})
+	go func() {
+		fm.acquireHost(b)()
+		close(acquired)
+	}()
+
+	// another host is not blocked
+	fm.acquireHost(c)()
+
+	select {
+	case <-acquired:
+		t.Fatal("second fetch for the same host was not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("slot was not released")
+	}
+}
+
+func TestDispatch_HostLimitDoesNotBlockOtherHosts(t *testing.T) {
+	client := &concurrencyClient{active: map[string]int{}, maxHost: map[string]int{}}
+	fm := &FeedsMonitor{Parser: NewParser(client)}
+	fm.Scheduler.Workers, fm.Scheduler.HostLimit = 2, 1
+	for i := range 4 {
+		fm.Instance.Feeds = append(fm.Instance.Feeds, NewTestFeed(fmt.Sprintf("busy-%d", i), fmt.Sprintf("https://busy.example.com/%d.xml", i)))
+	}
+	fm.Instance.Feeds = append(fm.Instance.Feeds, NewTestFeed("other", "https://other.example.com/feed.xml"))
+	fetched := &fetchTimes{times: map[string]time.Time{}}
+	fm.Parser.Hooks = fetched
+
+	start := time.Now()
+	fm.dispatch(fm.Instance.Feeds)
+
+	// the fetches of busy.example.com take 4 x 20ms one after the other
+	if len(fetched.times) != 5 {
+		t.Fatalf("fetched %d feeds, want 5", len(fetched.times))
+	}
+	if d := fetched.times["other"].Sub(start); d > 50*time.Millisecond {
+		t.Errorf("feed of another host fetched after %v, behind the saturated host", d)
+	}
+}
+
+// fetchTimes records the time each feed was fetched
+type fetchTimes struct {
+	NopHooks
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+func (h *fetchTimes) OnFetched(f *Feed, status, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.times[f.Name] = time.Now()
+}
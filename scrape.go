@@ -0,0 +1,201 @@
+package rss2masto
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// SourceScrape is the Feed.Source value for sites without any feed.
+// Items are extracted from the HTML page using the CSS selectors in Feed.Scrape.
+const SourceScrape = "scrape"
+
+// ScrapeRules describes how to extract items from an HTML page.
+// Item selects the item containers; all other selectors are evaluated inside a container.
+// A selector may end with "@attr" to read an attribute instead of the text,
+// e.g. "a@href" or "img@src". "@attr" alone reads the attribute of the container itself.
+type ScrapeRules struct {
+	Item        string   `yaml:"item"`                   // selector of the item container
+	Title       string   `yaml:"title"`                  // item title
+	Link        string   `yaml:"link,omitempty"`         // item link, default "a@href"
+	Date        string   `yaml:"date,omitempty"`         // publication date, default "time@datetime"
+	DateFormats []string `yaml:"date_formats,omitempty"` // Go time layouts tried before the built-in ones
+	TimeZone    string   `yaml:"timezone,omitempty"`     // timezone of dates without offset, default UTC
+	Summary     string   `yaml:"summary,omitempty"`      // item description (inner HTML is sanitized later)
+	Image       string   `yaml:"image,omitempty"`        // item image URL, e.g. "img@src"
+}
+
+// scrapeDateFormats are tried after the layouts configured in ScrapeRules.DateFormats
+var scrapeDateFormats = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02T15:04",
+	time.DateTime,
+	"2006-01-02 15:04",
+	time.DateOnly,
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"January 2, 2006",
+	"2 January 2006",
+}
+
+// scrapeFeed converts an HTML page into a feed using the given rules.
+// Relative links and image URLs are resolved against pageURL.
+// Items without a parseable date get a zero time; stampUndated then dates the
+// items not seen before with the time of the scrape, except on the first scrape.
+func scrapeFeed(body []byte, pageURL string, rules *ScrapeRules) (*gofeed.Feed, error) {
+	if rules == nil || rules.Item == "" {
+		return nil, fmt.Errorf("missing scrape rules")
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := time.UTC
+	if rules.TimeZone != "" {
+		loc, err = time.LoadLocation(rules.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	linkSel := rules.Link
+	if linkSel == "" {
+		linkSel = "a@href"
+	}
+	dateSel := rules.Date
+	if dateSel == "" {
+		dateSel = "time@datetime"
+	}
+
+	feed := &gofeed.Feed{
+		Title:    strings.TrimSpace(doc.Find("title").First().Text()),
+		Link:     pageURL,
+		FeedType: "html",
+	}
+	doc.Find(rules.Item).Each(func(_ int, s *goquery.Selection) {
+		item := &gofeed.Item{
+			Title: strings.Join(strings.Fields(scrapeValue(s, rules.Title, false)), " "),
+			Link:  resolveURL(base, scrapeValue(s, linkSel, false)),
+		}
+		if item.Title == "" && item.Link == "" {
+			return
+		}
+		item.GUID = item.Link
+		if item.GUID == "" {
+			item.GUID = item.Title
+		}
+		if rules.Summary != "" {
+			item.Description = strings.TrimSpace(scrapeValue(s, rules.Summary, true))
+		}
+		if rules.Image != "" {
+			if img := resolveURL(base, scrapeValue(s, rules.Image, false)); img != "" {
+				item.Image = &gofeed.Image{URL: img}
+			}
+		}
+
+		item.Published = strings.TrimSpace(scrapeValue(s, dateSel, false))
+		published, _ := parseScrapeDate(item.Published, rules.DateFormats, loc)
+		item.PublishedParsed = &published
+
+		feed.Items = append(feed.Items, item)
+	})
+	return feed, nil
+}
+
+// stampUndated dates each undated item of a scraped page with the time of the scrape
+// that first found it (now for items new to this scrape), so new items are posted once
+// and retried like dated items. Only the items of the first scrape keep their zero time
+// and are skipped as old, as the page may list years of items. The times are kept in
+// the state, so later scrapes and restarts don't date an item again.
+func (f *Feed) stampUndated(feed *gofeed.Feed, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	first := f.scraped == nil
+	current := make(map[string]int64)
+	for _, item := range feed.Items {
+		if !item.PublishedParsed.IsZero() {
+			continue
+		}
+		seen, ok := f.scraped[item.GUID]
+		switch {
+		case ok:
+		case first:
+			seen = 0
+		default:
+			seen = now.Unix()
+		}
+		current[item.GUID] = seen
+		if seen != 0 {
+			t := time.Unix(seen, 0).UTC()
+			item.PublishedParsed = &t
+		}
+	}
+	f.scraped = current
+}
+
+// scrapeValue evaluates a "selector@attr" expression inside s.
+// Without an attribute it returns the text, or the inner HTML if html is set.
+func scrapeValue(s *goquery.Selection, expr string, html bool) string {
+	if expr == "" {
+		return ""
+	}
+	sel, attr, hasAttr := strings.Cut(expr, "@")
+	sel = strings.TrimSpace(sel)
+	if sel != "" {
+		s = s.Find(sel).First()
+	}
+	if s.Length() == 0 {
+		return ""
+	}
+	if hasAttr {
+		return strings.TrimSpace(s.AttrOr(strings.TrimSpace(attr), ""))
+	}
+	if html {
+		h, _ := s.Html()
+		return h
+	}
+	return s.Text()
+}
+
+// resolveURL resolves a possibly relative reference against base.
+// It returns an empty string for empty or invalid references.
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// parseScrapeDate parses s using the configured layouts first, then the built-in ones.
+// Dates without an offset are interpreted in loc.
+func parseScrapeDate(s string, layouts []string, loc *time.Location) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, list := range [][]string{layouts, scrapeDateFormats} {
+		for _, layout := range list {
+			t, err := time.ParseInLocation(layout, s, loc)
+			if err == nil {
+				return t.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
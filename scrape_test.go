@@ -0,0 +1,216 @@
+package rss2masto
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+func TestScrapeFeed(t *testing.T) {
+	body, err := os.ReadFile("testdata/scrape_news.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &ScrapeRules{
+		Item:        "article.news-item",
+		Title:       ".title",
+		Link:        ".title a@href",
+		Date:        ".date",
+		DateFormats: []string{"02.01.2006 15:04"},
+		TimeZone:    "Europe/Warsaw",
+		Summary:     ".lead",
+		Image:       "img.thumb@src",
+	}
+
+	feed, err := scrapeFeed(body, "https://gazeta.example.pl/", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if feed.Title != "Gazeta Lokalna - Wiadomości" {
+		t.Errorf("Title = %q", feed.Title)
+	}
+	if len(feed.Items) != 3 {
+		t.Fatalf("Items count = %d, want 3 (empty container skipped)", len(feed.Items))
+	}
+
+	first := feed.Items[0]
+	if first.Title != "Nowy most otwarty dla ruchu" {
+		t.Errorf("Title = %q, whitespace should be collapsed", first.Title)
+	}
+	if first.Link != "https://gazeta.example.pl/wiadomosci/nowy-most-otwarty" {
+		t.Errorf("Link = %q", first.Link)
+	}
+	if first.GUID != first.Link {
+		t.Errorf("GUID = %q, want link", first.GUID)
+	}
+	if !strings.Contains(first.Description, "<b>oddany</b>") {
+		t.Errorf("Description = %q, want inner HTML", first.Description)
+	}
+	if first.Image == nil || first.Image.URL != "https://gazeta.example.pl/img/most.jpg" {
+		t.Errorf("Image = %v", first.Image)
+	}
+	want := time.Date(2024, 3, 14, 8, 30, 0, 0, time.UTC) // 09:30 CET
+	if !first.PublishedParsed.Equal(want) {
+		t.Errorf("PublishedParsed = %v, want %v", first.PublishedParsed, want)
+	}
+
+	if feed.Items[1].Title != "Derby miasta & regionu" {
+		t.Errorf("Title = %q", feed.Items[1].Title)
+	}
+	if feed.Items[1].Image != nil {
+		t.Errorf("Image = %v, want nil", feed.Items[1].Image)
+	}
+
+	undated := feed.Items[2]
+	if undated.PublishedParsed == nil || !undated.PublishedParsed.IsZero() {
+		t.Errorf("item without date should get a zero time, got %v", undated.PublishedParsed)
+	}
+}
+
+func TestStampUndated(t *testing.T) {
+	page := func(links ...string) *gofeed.Feed {
+		var b strings.Builder
+		for _, l := range links {
+			b.WriteString(`<li><a href="/` + l + `">` + l + `</a></li>`)
+		}
+		feed, err := scrapeFeed([]byte("<ul>"+b.String()+"</ul>"), "https://example.com/", &ScrapeRules{Item: "li", Title: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return feed
+	}
+	f := NewTestFeed("Blog", "https://example.com/")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// the first scrape of a page without dates posts nothing
+	feed := page("a", "b")
+	f.stampUndated(feed, now)
+	limit := now.Add(earlierDuration).Unix()
+	for _, item := range feed.Items {
+		if itemTime(item) >= limit {
+			t.Errorf("item %s of the first scrape dated %v", item.GUID, item.PublishedParsed)
+		}
+	}
+
+	// items added since are dated with the time they appeared
+	feed = page("c", "a", "b")
+	f.stampUndated(feed, now)
+	if !feed.Items[0].PublishedParsed.Equal(now) || !feed.Items[1].PublishedParsed.IsZero() {
+		t.Errorf("dates = %v, %v", feed.Items[0].PublishedParsed, feed.Items[1].PublishedParsed)
+	}
+	feed = page("c", "a")
+	f.stampUndated(feed, now.Add(time.Hour))
+	if !feed.Items[0].PublishedParsed.Equal(now) {
+		t.Errorf("new item dated again: %v", feed.Items[0].PublishedParsed)
+	}
+
+	// the times survive a restart in the state
+	fm := &FeedsMonitor{}
+	fm.Instance.Feeds = []*Feed{f}
+	state := fm.collectState()
+	restarted := NewTestFeed("Blog", "https://example.com/")
+	fm.Instance.Feeds = []*Feed{restarted}
+	fm.applyState(state)
+	feed = page("d", "c", "a")
+	restarted.stampUndated(feed, now.Add(2*time.Hour))
+	if !feed.Items[0].PublishedParsed.Equal(now.Add(2*time.Hour)) || !feed.Items[1].PublishedParsed.Equal(now) {
+		t.Errorf("dates after a restart = %v, %v", feed.Items[0].PublishedParsed, feed.Items[1].PublishedParsed)
+	}
+}
+
+func TestScrapeFeed_Defaults(t *testing.T) {
+	body, err := os.ReadFile("testdata/scrape_blog.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &ScrapeRules{
+		Item:    "ul.posts li",
+		Title:   "h3",
+		Link:    "@data-url", // attribute of the container, resolved against <base href>
+		Summary: ".excerpt",
+	}
+
+	feed, err := scrapeFeed(body, "https://example.com/", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 2 {
+		t.Fatalf("Items count = %d, want 2", len(feed.Items))
+	}
+	if feed.Items[0].Link != "https://example.com/first-post" {
+		t.Errorf("Link = %q", feed.Items[0].Link)
+	}
+	// default date selector reads <time datetime>
+	want := time.Date(2024, 3, 14, 7, 0, 0, 0, time.UTC)
+	if !feed.Items[0].PublishedParsed.Equal(want) {
+		t.Errorf("PublishedParsed = %v, want %v", feed.Items[0].PublishedParsed, want)
+	}
+	if feed.Items[1].Description != "" {
+		t.Errorf("Description = %q, want empty", feed.Items[1].Description)
+	}
+}
+
+func TestScrapeFeed_Errors(t *testing.T) {
+	if _, err := scrapeFeed([]byte("<html></html>"), "https://example.com/", nil); err == nil {
+		t.Error("expected error for missing rules")
+	}
+	rules := &ScrapeRules{Item: "article", TimeZone: "Not/ATimezone"}
+	if _, err := scrapeFeed([]byte("<html></html>"), "https://example.com/", rules); err == nil {
+		t.Error("expected error for invalid timezone")
+	}
+}
+
+func TestParseScrapeDate(t *testing.T) {
+	tests := []struct {
+		input   string
+		layouts []string
+		want    time.Time
+		ok      bool
+	}{
+		{"2024-03-14T10:00:00+02:00", nil, time.Date(2024, 3, 14, 8, 0, 0, 0, time.UTC), true},
+		{"14.03.2024", nil, time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC), true},
+		{"14/03/2024 10:15", []string{"02/01/2006 15:04"}, time.Date(2024, 3, 14, 10, 15, 0, 0, time.UTC), true},
+		{"yesterday", nil, time.Time{}, false},
+		{"", nil, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := parseScrapeDate(tt.input, tt.layouts, time.UTC)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("parseScrapeDate(%q) = %v, %v, want %v, %v", tt.input, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFetchAndParse_Scrape(t *testing.T) {
+	body, err := os.ReadFile("testdata/scrape_news.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.Header.SetContentType("text/html; charset=utf-8")
+			resp.SetBody(body)
+			return nil
+		},
+	})
+	feed := NewTestFeed("ga", "https://gazeta.example.pl/")
+	feed.Source = SourceScrape
+	feed.Scrape = &ScrapeRules{Item: "article.news-item", Title: ".title", Link: ".title a@href"}
+
+	result := p.FetchAndParse(feed)
+	if result == nil {
+		t.Fatal("expected scraped feed, got nil")
+	}
+	if len(result.Items) != 3 {
+		t.Errorf("Items count = %d, want 3", len(result.Items))
+	}
+}
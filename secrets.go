@@ -0,0 +1,256 @@
+package rss2masto
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// reSecretVar matches ${VAR} references expanded in secret fields
+var reSecretVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references with the values of environment variables.
+// Unlike os.ExpandEnv, a bare $ is kept and a reference to an unset variable is an error.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	s = reSecretVar.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s not set", strings.Join(missing, ", "))
+	}
+	return s, nil
+}
+
+// resolveSecret returns the value of a secret read from a file, an environment variable
+// or given inline with ${VAR} expansion, in that order of precedence.
+// Relative file paths are relative to the directory of the configuration file.
+func resolveSecret(inline, file, env string) (string, error) {
+	var value string
+	switch {
+	case file != "":
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(configFile), file)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		value = strings.TrimSpace(string(b))
+	case env != "":
+		var ok bool
+		if value, ok = os.LookupEnv(env); !ok {
+			return "", fmt.Errorf("environment variable %s not set", env)
+		}
+	default:
+		var err error
+		if value, err = expandEnv(inline); err != nil {
+			return "", err
+		}
+	}
+	registerSecret(value)
+	return value, nil
+}
+
+// resolveSecrets resolves tokens, passwords and other secrets of the configuration.
+// The resolved values are kept in unexported fields, so SaveConfig writes back
+// only the configured references (${VAR}, *_file, *_env).
+func (fm *FeedsMonitor) resolveSecrets() error {
+	var err error
+	for _, feed := range fm.Instance.Feeds {
+		if feed.token, err = resolveSecret(feed.Token, feed.TokenFile, feed.TokenEnv); err != nil {
+			return fmt.Errorf("[%s] token: %w", feed.Name, err)
+		}
+		for _, t := range feed.Targets {
+			if t.token, err = resolveSecret(t.Token, t.TokenFile, t.TokenEnv); err != nil {
+				return fmt.Errorf("[%s] target %s token: %w", feed.Name, t.Name, err)
+			}
+		}
+	}
+	for _, cfg := range fm.Publishers {
+		if cfg.password, err = resolveSecret(cfg.Password, cfg.PasswordFile, cfg.PasswordEnv); err != nil {
+			return fmt.Errorf("publisher %s password: %w", cfg.Name, err)
+		}
+		if cfg.secret, err = resolveSecret(cfg.Secret, cfg.SecretFile, cfg.SecretEnv); err != nil {
+			return fmt.Errorf("publisher %s secret: %w", cfg.Name, err)
+		}
+		cfg.headers = make(map[string]string, len(cfg.Headers))
+		for k, v := range cfg.Headers {
+			if cfg.headers[k], err = resolveSecret(v, "", ""); err != nil {
+				return fmt.Errorf("publisher %s header %s: %w", cfg.Name, k, err)
+			}
+		}
+	}
+	for i, ch := range fm.Alerts.Channels {
+		if ch.secret, err = resolveSecret(ch.Secret, ch.SecretFile, ch.SecretEnv); err != nil {
+			return fmt.Errorf("alert channel %d secret: %w", i, err)
+		}
+		if ch.password, err = resolveSecret(ch.Password, ch.PasswordFile, ch.PasswordEnv); err != nil {
+			return fmt.Errorf("alert channel %d password: %w", i, err)
+		}
+		if ch.token, err = resolveSecret(ch.Token, ch.TokenFile, ch.TokenEnv); err != nil {
+			return fmt.Errorf("alert channel %d token: %w", i, err)
+		}
+	}
+	if fm.WebSub.secret, err = resolveSecret(fm.WebSub.Secret, fm.WebSub.SecretFile, fm.WebSub.SecretEnv); err != nil {
+		return fmt.Errorf("websub secret: %w", err)
+	}
+	if fm.Server.adminToken, err = resolveSecret(fm.Server.AdminToken, fm.Server.AdminTokenFile, fm.Server.AdminTokenEnv); err != nil {
+		return fmt.Errorf("server admin token: %w", err)
+	}
+	return nil
+}
+
+// ResolveSecrets resolves the secrets of a configuration read with LoadConfig,
+// for commands using the cache keys of the feeds without starting a monitor
+func (fm *FeedsMonitor) ResolveSecrets() error {
+	return fm.resolveSecrets()
+}
+
+// accessToken returns the resolved token of the feed
+func (f *Feed) accessToken() string {
+	f.cfgMu.RLock()
+	defer f.cfgMu.RUnlock()
+	return cmp.Or(f.token, f.Token)
+}
+
+// accessToken returns the resolved token of the target
+func (t *Target) accessToken() string {
+	return cmp.Or(t.token, t.Token)
+}
+
+// minSecretLength is the length below which values are not redacted,
+// so short header values or test strings don't mangle logs.
+const minSecretLength = 6
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+	redactor  *strings.Replacer
+)
+
+// registerSecret adds a value to be redacted from logs and error messages
+func registerSecret(s string) {
+	if len(s) < minSecretLength {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if slices.Contains(secrets, s) {
+		return
+	}
+	secrets = append(secrets, s)
+	// longer secrets first, so a secret containing another one is fully redacted
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, "[REDACTED]")
+	}
+	redactor = strings.NewReplacer(pairs...)
+}
+
+// redact replaces registered secrets in s
+func redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	if redactor == nil {
+		return s
+	}
+	return redactor.Replace(s)
+}
+
+// redactedError hides registered secrets in the message of the wrapped error
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError wraps err so its message doesn't reveal secrets
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
+}
+
+// redactHandler removes registered secrets from the message and the attributes of
+// log records, so tokens in URLs and errors don't reach the logs
+type redactHandler struct {
+	h slog.Handler
+}
+
+// redactLogger returns l logging through a redactHandler
+func redactLogger(l *slog.Logger) *slog.Logger {
+	if _, ok := l.Handler().(*redactHandler); ok {
+		return l
+	}
+	return slog.New(&redactHandler{h: l.Handler()})
+}
+
+func (r *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return r.h.Enabled(ctx, level)
+}
+
+func (r *redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return r.h.Handle(ctx, out)
+}
+
+func (r *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactHandler{h: r.h.WithAttrs(redacted)}
+}
+
+func (r *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{h: r.h.WithGroup(name)}
+}
+
+// redactAttr redacts string, error and Stringer values, in groups too
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]any, len(group))
+		for i, g := range group {
+			attrs[i] = redactAttr(g)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.Any(a.Key, redactError(x))
+		case fmt.Stringer:
+			return slog.String(a.Key, redact(x.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
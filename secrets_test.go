@@ -0,0 +1,193 @@
+package rss2masto
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("RSS2MASTO_TEST_TOKEN", "abc123")
+
+	got, err := expandEnv("Bearer ${RSS2MASTO_TEST_TOKEN}")
+	if err != nil || got != "Bearer abc123" {
+		t.Errorf("expandEnv() = %q, %v", got, err)
+	}
+	got, err = expandEnv("pa$$word$HOME")
+	if err != nil || got != "pa$$word$HOME" {
+		t.Errorf("bare $ expanded: %q, %v", got, err)
+	}
+	if _, err := expandEnv("${RSS2MASTO_TEST_UNSET}"); err == nil || !strings.Contains(err.Error(), "RSS2MASTO_TEST_UNSET") {
+		t.Errorf("unset variable error = %v", err)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	originalConfigFile := configFile
+	configFile = filepath.Join(dir, "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	t.Setenv("RSS2MASTO_TEST_TOKEN", "env-token")
+
+	tests := []struct {
+		name, inline, file, env string
+		want                    string
+		wantErr                 bool
+	}{
+		{name: "plain", inline: "plain-token", want: "plain-token"},
+		{name: "expanded", inline: "${RSS2MASTO_TEST_TOKEN}", want: "env-token"},
+		{name: "env", inline: "ignored", env: "RSS2MASTO_TEST_TOKEN", want: "env-token"},
+		{name: "file relative to config", inline: "ignored", file: "token", env: "RSS2MASTO_TEST_TOKEN", want: "file-token"},
+		{name: "absolute file", file: filepath.Join(dir, "token"), want: "file-token"},
+		{name: "missing file", file: "missing", wantErr: true},
+		{name: "missing env", env: "RSS2MASTO_TEST_UNSET", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSecret(tt.inline, tt.file, tt.env)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("resolveSecret() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecrets_NotSaved(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "regional.token"), []byte("regional-secret-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := `instance:
+  url: https://mastodon.example
+  save: true
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: ${RSS2MASTO_TEST_TOKEN}
+      targets:
+        - name: regional
+          token_file: regional.token
+publishers:
+  - name: bsky
+    type: bluesky
+    identifier: bot.example
+    password_env: RSS2MASTO_TEST_PASSWORD
+  - name: hook
+    type: webhook
+    url: https://hooks.example/post
+    secret_env: RSS2MASTO_TEST_PASSWORD
+server:
+  admin_token_env: RSS2MASTO_TEST_TOKEN
+websub:
+  secret_file: regional.token
+alerts:
+  channels:
+    - type: mastodon
+      token_file: regional.token
+      to: ["@admin"]
+`
+	originalConfigFile := configFile
+	configFile = filepath.Join(dir, "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RSS2MASTO_TEST_TOKEN", "national-secret-token")
+	t.Setenv("RSS2MASTO_TEST_PASSWORD", "bluesky-app-password")
+
+	fm, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.resolveSecrets(); err != nil {
+		t.Fatalf("resolveSecrets() error = %v", err)
+	}
+	feed := fm.Instance.Feeds[0]
+	if feed.accessToken() != "national-secret-token" || feed.Targets[0].accessToken() != "regional-secret-token" {
+		t.Errorf("tokens = %q, %q", feed.accessToken(), feed.Targets[0].accessToken())
+	}
+	if fm.Server.adminToken != "national-secret-token" || fm.WebSub.secret != "regional-secret-token" ||
+		fm.Alerts.Channels[0].token != "regional-secret-token" || fm.Publishers[1].secret != "bluesky-app-password" {
+		t.Errorf("secrets = %q, %q, %q, %q", fm.Server.adminToken, fm.WebSub.secret, fm.Alerts.Channels[0].token, fm.Publishers[1].secret)
+	}
+	fm.Parser = NewParser(nil)
+	if err := fm.initPublishers(); err != nil {
+		t.Fatal(err)
+	}
+	if b := fm.publishers["bsky"].(*BlueskyPublisher); b.Password != "bluesky-app-password" {
+		t.Errorf("bluesky password = %q", b.Password)
+	}
+
+	if err := fm.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"national-secret-token", "regional-secret-token", "bluesky-app-password"} {
+		if strings.Contains(string(saved), secret) {
+			t.Errorf("saved configuration contains %q:\n%s", secret, saved)
+		}
+	}
+	if !strings.Contains(string(saved), "${RSS2MASTO_TEST_TOKEN}") || !strings.Contains(string(saved), "token_file: regional.token") {
+		t.Errorf("secret references not preserved:\n%s", saved)
+	}
+
+	os.Unsetenv("RSS2MASTO_TEST_PASSWORD")
+	if err := fm.resolveSecrets(); err == nil || !strings.Contains(err.Error(), "publisher bsky") {
+		t.Errorf("resolveSecrets() with missing variable error = %v", err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	registerSecret("super-secret-value")
+	registerSecret("abc") // too short to redact
+
+	if got := redact("Authorization: Bearer super-secret-value, abc"); got != "Authorization: Bearer [REDACTED], abc" {
+		t.Errorf("redact() = %q", got)
+	}
+
+	he := &httpError{Status: fasthttp.StatusUnauthorized, Body: `{"error":"invalid token super-secret-value"}`}
+	if strings.Contains(he.Error(), "super-secret-value") {
+		t.Errorf("httpError not redacted: %s", he.Error())
+	}
+
+	err := redactError(errors.Join(he, errors.New("token super-secret-value rejected")))
+	if strings.Contains(err.Error(), "super-secret-value") {
+		t.Errorf("error not redacted: %s", err)
+	}
+	var target *httpError
+	if !errors.As(err, &target) {
+		t.Error("redacted error doesn't unwrap")
+	}
+	if redactError(nil) != nil {
+		t.Error("redactError(nil) != nil")
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	registerSecret("logged-secret-token")
+	var buf bytes.Buffer
+	log := redactLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	if redactLogger(log) != log {
+		t.Error("logger redacted twice")
+	}
+
+	u, _ := url.Parse("https://hub.example/?token=logged-secret-token")
+	log.With("token", "logged-secret-token").WithGroup("req").Error("request with logged-secret-token failed",
+		"url", u, "error", errors.New("401 for logged-secret-token"), slog.Group("auth", "header", "Bearer logged-secret-token"))
+	if out := buf.String(); strings.Contains(out, "logged-secret-token") || strings.Count(out, "[REDACTED]") != 5 {
+		t.Errorf("log = %q", out)
+	}
+}
@@ -0,0 +1,67 @@
+package rss2masto
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ServerConfig holds the settings of the embedded HTTP server
+type ServerConfig struct {
+	Listen     string        `yaml:"listen,omitempty"`         // address to listen on, e.g. ":8080"
+	Metrics    bool          `yaml:"metrics,omitempty"`        // serve Prometheus metrics on /metrics
+	AdminToken string        `yaml:"admin_token,omitempty"`    // bearer token of the admin API on /api, disabled if empty; ${VAR} references are expanded
+	Dashboard  bool          `yaml:"dashboard,omitempty"`      // serve the HTML dashboard on /dashboard, protected by AdminToken if set
+	StaleAfter time.Duration `yaml:"stale_after,omitempty"`    // /healthz fails when no run finished for this long, 3 ticks if zero
+	Fallback   bool          `yaml:"cache_fallback,omitempty"` // /readyz accepts an offline Redis, with deduplication by the local cache only
+
+	AdminTokenFile string `yaml:"admin_token_file,omitempty"` // file containing the admin token, overrides AdminToken
+	AdminTokenEnv  string `yaml:"admin_token_env,omitempty"`  // environment variable containing the admin token, overrides AdminToken
+
+	adminToken string // resolved admin token, see FeedsMonitor.resolveSecrets
+}
+
+// Handler returns the fasthttp handler serving the HTTP endpoints of the monitor:
+// - /healthz, /readyz: liveness and readiness probes
+// - /websub/<id>: WebSub subscription callbacks
+// - /metrics: Prometheus metrics, if Server.Metrics is set
+// - /api/...: admin API, if Server.AdminToken is set
+// - /dashboard: HTML dashboard, if Server.Dashboard is set
+func (fm *FeedsMonitor) Handler() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		path := b2s(ctx.Path())
+		switch {
+		case path == "/healthz":
+			fm.healthHandler(ctx, fm.Health())
+		case path == "/readyz":
+			fm.healthHandler(ctx, fm.Ready())
+		case strings.HasPrefix(path, "/websub/"):
+			fm.webSubHandler(ctx)
+		case path == "/metrics" && fm.Server.Metrics:
+			fm.MetricsHandler(ctx)
+		case strings.HasPrefix(path, "/api/") && fm.Server.adminToken != "":
+			fm.adminHandler(ctx)
+		case (path == "/dashboard" || strings.HasPrefix(path, "/dashboard/")) && fm.Server.Dashboard:
+			fm.dashboardHandler(ctx)
+		default:
+			ctx.Error("Not Found", fasthttp.StatusNotFound)
+		}
+	}
+}
+
+// ListenAndServe starts the embedded HTTP server on Server.Listen.
+// It blocks until the server fails, so it is usually run in its own goroutine.
+func (fm *FeedsMonitor) ListenAndServe() error {
+	if fm.Server.Listen == "" {
+		return fmt.Errorf("server listen address not set")
+	}
+	s := &fasthttp.Server{
+		Handler:      fm.Handler(),
+		Name:         "rss2masto",
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	}
+	return s.ListenAndServe(fm.Server.Listen)
+}
@@ -0,0 +1,268 @@
+package rss2masto
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"gopkg.in/yaml.v3"
+)
+
+// StateConfig selects where runtime state is stored.
+// The configuration file is never written by the monitor.
+type StateConfig struct {
+	Store string `yaml:"store,omitempty"` // file (default) or cache
+	Path  string `yaml:"path,omitempty"`  // state file, JSON or YAML by extension; state.json next to the configuration file if empty
+	Key   string `yaml:"key,omitempty"`   // cache key, rss2masto:state if empty
+}
+
+// State stores available in StateConfig.Store
+const (
+	StateStoreFile  = "file"
+	StateStoreCache = "cache"
+)
+
+const (
+	defaultStateFile = "state.json"
+	defaultStateKey  = "rss2masto:state"
+	stateVersion     = 1
+)
+
+// State is the runtime state of the monitor, persisted between runs
+type State struct {
+	Version   int                   `json:"version" yaml:"version"`
+	LastMonit int64                 `json:"last_monit,omitempty" yaml:"last_monit,omitempty"`
+	Feeds     map[string]*FeedState `json:"feeds,omitempty" yaml:"feeds,omitempty"` // by feed name
+}
+
+// FeedState is the runtime state of a feed
+type FeedState struct {
+	LastRun    int64             `json:"last_run,omitempty" yaml:"last_run,omitempty"`       // Unix timestamp of the last processed item
+	URLs       []string          `json:"urls,omitempty" yaml:"urls,omitempty"`               // feed URLs updated by autodiscovery (update_url)
+	ConfigURLs []string          `json:"config_urls,omitempty" yaml:"config_urls,omitempty"` // configured URLs the discovered URLs replace
+	ETag       string            `json:"etag,omitempty" yaml:"etag,omitempty"`               // validator of the last response
+	Failures   int64             `json:"failures,omitempty" yaml:"failures,omitempty"`       // consecutive failed fetches
+	Statuses   map[string]string `json:"statuses,omitempty" yaml:"statuses,omitempty"`       // publisher name -> ID of the last post
+	Namespace  string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`     // namespace of the cache keys, see migrateKeys
+	Paused     bool              `json:"paused,omitempty" yaml:"paused,omitempty"`           // the feed was paused, see Feed.SetPaused
+	Profile    string            `json:"profile,omitempty" yaml:"profile,omitempty"`         // hash of the last synced profile, see syncProfile
+	Scraped    map[string]int64  `json:"scraped,omitempty" yaml:"scraped,omitempty"`         // undated items of the last scraped page by GUID, see stampUndated
+}
+
+// StateStore loads and saves the runtime state
+type StateStore interface {
+	// LoadState returns the saved state, or an empty state if nothing was saved yet
+	LoadState() (*State, error)
+	// SaveState replaces the saved state
+	SaveState(s *State) error
+}
+
+// FileStateStore keeps the state in a JSON or YAML file (.yaml and .yml extensions).
+// The file is replaced atomically, so a crash never leaves a partial state.
+type FileStateStore struct {
+	Path string
+}
+
+func (s *FileStateStore) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(s.Path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadState reads the state file
+func (s *FileStateStore) LoadState() (*State, error) {
+	state := &State{Version: stateVersion}
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.isYAML() {
+		err = yaml.Unmarshal(b, state)
+	} else {
+		err = jsoniter.Unmarshal(b, state)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", s.Path, err)
+	}
+	return state, nil
+}
+
+// SaveState writes the state file atomically
+func (s *FileStateStore) SaveState(state *State) error {
+	var b []byte
+	var err error
+	if s.isYAML() {
+		b, err = yaml.Marshal(state)
+	} else {
+		b, err = jsoniter.ConfigDefault.MarshalIndent(state, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, b, 0600)
+}
+
+// CacheStateStore keeps the state in the cache backend (Redis) under Key
+type CacheStateStore struct {
+	Key string
+}
+
+// LoadState reads the state from the cache
+func (s *CacheStateStore) LoadState() (*State, error) {
+	state := &State{Version: stateVersion}
+	if !Cache.KeyExists(s.Key) {
+		return state, nil
+	}
+	if err := Cache.Load(s.Key, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SaveState stores the state in the cache
+func (s *CacheStateStore) SaveState(state *State) error {
+	return Cache.Save(s.Key, state)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path and renames it over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// stateStore returns the configured state store
+func (fm *FeedsMonitor) stateStore() (StateStore, error) {
+	switch fm.State.Store {
+	case "", StateStoreFile:
+		path := fm.State.Path
+		if path == "" {
+			path = filepath.Join(filepath.Dir(configFile), defaultStateFile)
+		}
+		return &FileStateStore{Path: path}, nil
+	case StateStoreCache:
+		key := fm.State.Key
+		if key == "" {
+			key = defaultStateKey
+		}
+		return &CacheStateStore{Key: key}, nil
+	}
+	return nil, fmt.Errorf("unknown state store %q", fm.State.Store)
+}
+
+// persistState reports whether the state is saved after each run:
+// with instance.save or a configured state block.
+func (fm *FeedsMonitor) persistState() bool {
+	return fm.Instance.Save || fm.State != StateConfig{}
+}
+
+// loadState reads the saved state. The configuration values of last_monit and
+// last_run are kept as a starting point (they were written by older versions).
+func (fm *FeedsMonitor) loadState() (*State, error) {
+	store, err := fm.stateStore()
+	if err != nil {
+		return nil, err
+	}
+	state, err := store.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	if state.LastMonit > fm.Instance.Monit {
+		fm.Instance.Monit = state.LastMonit
+	}
+	return state, nil
+}
+
+// applyState restores the state of the feeds. It must be called after setFeedDefaults.
+func (fm *FeedsMonitor) applyState(state *State) {
+	for _, feed := range fm.Instance.Feeds {
+		fs, ok := state.Feeds[feed.Name]
+		if !ok {
+			continue
+		}
+		feed.LastRun = max(feed.LastRun, fs.LastRun)
+		// discovered URLs are dropped when the configured URLs changed since
+		if feed.UpdateURL && len(fs.URLs) > 0 && slices.Equal(fs.ConfigURLs, feed.configURLs) {
+			feed.URLs = FeedURLs(fs.URLs)
+		}
+		if fs.ETag != "" {
+			feed.SetETag([]byte(fs.ETag))
+		}
+		feed.failures.Store(fs.Failures)
+		feed.statuses = maps.Clone(fs.Statuses)
+		feed.keyPrefix = fs.Namespace
+		feed.paused.Store(fs.Paused)
+		feed.profile = fs.Profile
+		feed.scraped = maps.Clone(fs.Scraped)
+	}
+}
+
+// collectState returns the current state of the monitor
+func (fm *FeedsMonitor) collectState() *State {
+	state := &State{
+		Version:   stateVersion,
+		LastMonit: fm.LastMonit(),
+		Feeds:     make(map[string]*FeedState, len(fm.feeds())),
+	}
+	for _, feed := range fm.feeds() {
+		feed.mu.Lock()
+		fs := &FeedState{
+			LastRun:   feed.LastRun,
+			ETag:      string(feed.ETag()),
+			Failures:  feed.failures.Load(),
+			Statuses:  maps.Clone(feed.statuses),
+			Namespace: feed.keyPrefix,
+			Paused:    feed.Paused(),
+			Profile:   feed.profile,
+			Scraped:   maps.Clone(feed.scraped),
+		}
+		if feed.UpdateURL {
+			fs.URLs = slices.Clone(feed.urlList())
+			fs.ConfigURLs = slices.Clone(feed.configURLs)
+		}
+		feed.mu.Unlock()
+		state.Feeds[feed.Name] = fs
+	}
+	return state
+}
+
+// SaveState saves the runtime state (last run timestamps, validators, failure
+// counts and IDs of the last posts) to the configured state store
+func (fm *FeedsMonitor) SaveState() error {
+	store, err := fm.stateStore()
+	if err != nil {
+		return err
+	}
+	return store.SaveState(fm.collectState())
+}
+
+// Failures returns the number of consecutive failed fetches of the feed
+func (f *Feed) Failures() int64 {
+	return f.failures.Load()
+}
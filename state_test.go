@@ -0,0 +1,223 @@
+package rss2masto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestFileStateStore(t *testing.T) {
+	for _, name := range []string{"state.json", "state.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			store := &FileStateStore{Path: filepath.Join(t.TempDir(), name)}
+
+			empty, err := store.LoadState()
+			if err != nil || len(empty.Feeds) != 0 {
+				t.Fatalf("LoadState() of a missing file = %+v, %v", empty, err)
+			}
+
+			state := &State{
+				Version:   stateVersion,
+				LastMonit: 1700000000,
+				Feeds: map[string]*FeedState{
+					"News": {LastRun: 1700000100, ETag: `W/"abc"`, Failures: 2, Statuses: map[string]string{"mastodon": "110"}},
+				},
+			}
+			if err := store.SaveState(state); err != nil {
+				t.Fatalf("SaveState() error = %v", err)
+			}
+			loaded, err := store.LoadState()
+			if err != nil {
+				t.Fatalf("LoadState() error = %v", err)
+			}
+			fs := loaded.Feeds["News"]
+			if loaded.LastMonit != 1700000000 || fs == nil || fs.LastRun != 1700000100 || fs.ETag != `W/"abc"` ||
+				fs.Failures != 2 || fs.Statuses["mastodon"] != "110" {
+				t.Errorf("loaded state = %+v, feed %+v", loaded, fs)
+			}
+
+			info, err := os.Stat(store.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("state file mode = %v, want 0600", info.Mode().Perm())
+			}
+			entries, _ := os.ReadDir(filepath.Dir(store.Path))
+			if len(entries) != 1 {
+				t.Errorf("temporary files left behind: %v", entries)
+			}
+		})
+	}
+
+	t.Run("invalid file", func(t *testing.T) {
+		store := &FileStateStore{Path: filepath.Join(t.TempDir(), "state.json")}
+		os.WriteFile(store.Path, []byte("{not json"), 0600)
+		if _, err := store.LoadState(); err == nil {
+			t.Error("invalid state file accepted")
+		}
+	})
+}
+
+func TestCacheStateStore(t *testing.T) {
+	store := &CacheStateStore{Key: fmt.Sprintf("test:state:%d", time.Now().UnixNano())}
+
+	empty, err := store.LoadState()
+	if err != nil || len(empty.Feeds) != 0 {
+		t.Fatalf("LoadState() of a missing key = %+v, %v", empty, err)
+	}
+	state := &State{Version: stateVersion, LastMonit: 42, Feeds: map[string]*FeedState{"News": {LastRun: 43}}}
+	if err := store.SaveState(state); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	loaded, err := store.LoadState()
+	if err != nil || loaded.LastMonit != 42 || loaded.Feeds["News"].LastRun != 43 {
+		t.Errorf("LoadState() = %+v, %v", loaded, err)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	config := `# user comments must survive
+instance:
+  url: https://mastodon.example
+  save: true
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: t
+      last_run: 1600000000
+    - name: Blog
+      url: https://blog.example/
+      update_url: true
+`
+	originalConfigFile := configFile
+	configFile = filepath.Join(dir, "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+	if err := os.WriteFile(configFile, []byte(config), 0400); err != nil {
+		t.Fatal(err)
+	}
+
+	fm, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fm.persistState() {
+		t.Fatal("state should be saved with instance.save")
+	}
+	for _, feed := range fm.Instance.Feeds {
+		fm.setFeedDefaults(feed)
+	}
+	news, blog := fm.Instance.Feeds[0], fm.Instance.Feeds[1]
+	news.LastRun = 1700000000
+	news.SetETag([]byte(`"v1"`))
+	news.failures.Store(3)
+	news.statuses = map[string]string{"mastodon": "111"}
+	blog.URLs = FeedURLs{"https://blog.example/feed.xml"}
+	fm.lastMonit.Store(1700000000)
+
+	if err := fm.SaveState(); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+	if b, _ := os.ReadFile(configFile); string(b) != config {
+		t.Errorf("configuration file modified:\n%s", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state.json")); err != nil {
+		t.Fatalf("state file not written next to the configuration: %v", err)
+	}
+
+	restored, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := restored.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Instance.Monit != 1700000000 {
+		t.Errorf("last_monit = %d, want 1700000000", restored.Instance.Monit)
+	}
+	for _, feed := range restored.Instance.Feeds {
+		restored.setFeedDefaults(feed)
+	}
+	restored.applyState(state)
+	news, blog = restored.Instance.Feeds[0], restored.Instance.Feeds[1]
+	if news.LastRun != 1700000000 || string(news.ETag()) != `"v1"` || news.Failures() != 3 || news.statuses["mastodon"] != "111" {
+		t.Errorf("News state not restored: last_run %d, etag %q, failures %d, statuses %v",
+			news.LastRun, news.ETag(), news.Failures(), news.statuses)
+	}
+	if blog.URL() != "https://blog.example/feed.xml" {
+		t.Errorf("discovered URL not restored: %s", blog.URL())
+	}
+
+	// the URL edited in the configuration wins over the URL discovered from the old one
+	edited := strings.Replace(config, "https://blog.example/", "https://blog.example/news/", 1)
+	if err := os.Chmod(configFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	restored, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, feed := range restored.Instance.Feeds {
+		restored.setFeedDefaults(feed)
+	}
+	restored.applyState(state)
+	if blog = restored.Instance.Feeds[1]; blog.URL() != "https://blog.example/news/" {
+		t.Errorf("configured URL replaced by the URL discovered from the previous one: %s", blog.URL())
+	}
+}
+
+func TestStateStore_Config(t *testing.T) {
+	fm := &FeedsMonitor{}
+	if fm.persistState() {
+		t.Error("state saved without instance.save or a state block")
+	}
+
+	fm.State = StateConfig{Store: StateStoreCache}
+	store, err := fm.stateStore()
+	if err != nil || store.(*CacheStateStore).Key != defaultStateKey || !fm.persistState() {
+		t.Errorf("cache store = %+v, %v", store, err)
+	}
+
+	fm.State = StateConfig{Path: "/var/lib/rss2masto/state.yaml"}
+	store, err = fm.stateStore()
+	if err != nil || store.(*FileStateStore).Path != "/var/lib/rss2masto/state.yaml" {
+		t.Errorf("file store = %+v, %v", store, err)
+	}
+
+	fm.State = StateConfig{Store: "sqlite"}
+	if _, err := fm.stateStore(); err == nil || !strings.Contains(err.Error(), "sqlite") {
+		t.Errorf("unknown store error = %v", err)
+	}
+}
+
+func TestFetchAndParse_Failures(t *testing.T) {
+	status := fasthttp.StatusInternalServerError
+	p := NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(status)
+			return nil
+		},
+	})
+	feed := NewTestFeed("te", "https://example.com/feed.xml")
+
+	p.FetchAndParse(feed)
+	p.FetchAndParse(feed)
+	if feed.Failures() != 2 {
+		t.Errorf("Failures() = %d, want 2", feed.Failures())
+	}
+	status = fasthttp.StatusNotModified
+	p.FetchAndParse(feed)
+	if feed.Failures() != 0 {
+		t.Errorf("Failures() after 304 = %d, want 0", feed.Failures())
+	}
+}
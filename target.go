@@ -0,0 +1,129 @@
+package rss2masto
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Target is an additional Mastodon account a feed is cross-posted to.
+// The feed is fetched and parsed once; every target has its own
+// idempotency keys and counters. Empty fields inherit the feed settings.
+type Target struct {
+	Name       string    `yaml:"name"`                 // target identifier used in logs and idempotency keys
+	Token      string    `yaml:"token"`                // Mastodon API access token, ${VAR} references are expanded
+	TokenFile  string    `yaml:"token_file,omitempty"` // file containing the token, overrides Token
+	TokenEnv   string    `yaml:"token_env,omitempty"`  // environment variable containing the token, overrides Token
+	Instance   string    `yaml:"instance,omitempty"`   // name of the Mastodon instance, the feed's instance if empty
+	Visibility string    `yaml:"visibility,omitempty"` // post visibility: public, unlisted, or private
+	Language   string    `yaml:"language,omitempty"`   // post language, overrides profile and feed language
+	Prefix     string    `yaml:"prefix,omitempty"`     // hashtag prefix added to every generated tag
+	HashTag    string    `yaml:"hashtag,omitempty"`    // static hashtag always added to every post
+	Template   string    `yaml:"template,omitempty"`   // message template, see Feed.Template
+	Count      int64     `yaml:"-"`                    // number of items posted in the current run
+	SendTime   time.Time `yaml:"-"`                    // time the last post was sent
+	token      string    // resolved token, see accessToken
+}
+
+// targetPublisherPrefix marks publishers of feed targets in logs and idempotency keys
+const targetPublisherPrefix = "@"
+
+// messageData holds the fields available in message templates
+type messageData struct {
+	Feed        string // feed name
+	Title       string
+	Description string // sanitized, shortened to the post limit
+	Hashtags    string // space separated hashtags, e.g. "#News #Local"
+	Link        string
+	Image       string
+}
+
+// templates caches parsed message templates by their text
+var templates sync.Map
+
+// parseTemplate returns the parsed message template
+func parseTemplate(text string) (*template.Template, error) {
+	if t, ok := templates.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("message").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(text, t)
+	return t, nil
+}
+
+// renderMessage executes a message template; without a template the default layout is used
+func renderMessage(text string, data *messageData) (string, error) {
+	if text == "" {
+		return composeMessage(data.Title, data.Description, data.Hashtags, data.Link), nil
+	}
+	t, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// initTargets validates the targets and message templates of all feeds.
+// It must be called after initInstances.
+func (fm *FeedsMonitor) initTargets() error {
+	for _, feed := range fm.Instance.Feeds {
+		if _, err := parseTemplate(feed.Template); feed.Template != "" && err != nil {
+			return fmt.Errorf("[%s] invalid template: %w", feed.Name, err)
+		}
+		names := make(map[string]bool, len(feed.Targets))
+		for _, t := range feed.Targets {
+			if t.Name == "" || names[t.Name] {
+				return fmt.Errorf("[%s] target names must be unique and not empty: %q", feed.Name, t.Name)
+			}
+			names[t.Name] = true
+			if _, ok := fm.instances[fm.targetInstanceName(feed, t)]; !ok {
+				return fmt.Errorf("[%s] target %s: unknown instance %q", feed.Name, t.Name, t.Instance)
+			}
+			if _, err := parseTemplate(t.Template); t.Template != "" && err != nil {
+				return fmt.Errorf("[%s] target %s: invalid template: %w", feed.Name, t.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// targetInstanceName returns the name of the instance of a target
+func (fm *FeedsMonitor) targetInstanceName(f *Feed, t *Target) string {
+	if t.Instance != "" {
+		return t.Instance
+	}
+	return f.instanceName()
+}
+
+// targetPublishers returns the Mastodon publishers of the feed targets
+func (fm *FeedsMonitor) targetPublishers(f *Feed) []namedPublisher {
+	var list []namedPublisher
+	for _, t := range f.Targets {
+		if t.accessToken() == "" {
+			continue
+		}
+		mi := fm.feedInstance(f)
+		if t.Instance != "" {
+			mi = fm.instances[t.Instance]
+		}
+		if mi == nil {
+			fm.feedLog(f).Error("Unknown instance of target", "target", t.Name, "instance", fm.targetInstanceName(f, t), "error_class", errClassConfig)
+			continue
+		}
+		list = append(list, namedPublisher{
+			name:      targetPublisherPrefix + t.Name,
+			Publisher: mi.publisher(t.accessToken()),
+			target:    t,
+		})
+	}
+	return list
+}
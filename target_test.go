@@ -0,0 +1,166 @@
+package rss2masto
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRenderMessage(t *testing.T) {
+	data := &messageData{
+		Feed:        "News",
+		Title:       "Title",
+		Description: "Description",
+		Hashtags:    "#News",
+		Link:        "https://example.com/a",
+	}
+
+	got, err := renderMessage("", data)
+	if err != nil || got != "Title\n\nDescription\n\n#News\n\nhttps://example.com/a" {
+		t.Errorf("default layout = %q, %v", got, err)
+	}
+
+	got, err = renderMessage("📰 {{.Title}}\n{{.Link}}\n{{.Hashtags}} via {{.Feed}}\n", data)
+	if err != nil || got != "📰 Title\nhttps://example.com/a\n#News via News" {
+		t.Errorf("template = %q, %v", got, err)
+	}
+
+	if _, err := renderMessage("{{.Missing}}", data); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestInitTargets(t *testing.T) {
+	config := func(targets string) string {
+		return "instance:\n  url: https://a.example\n  feed:\n    - name: News\n      targets:\n" + targets
+	}
+	tests := []struct {
+		name    string
+		targets string
+		want    string
+	}{
+		{"valid", "        - {name: regional, token: t, template: '{{.Title}}'}\n        - {name: other, token: t}\n", ""},
+		{"missing name", "        - {token: t}\n", "not empty"},
+		{"duplicate name", "        - {name: a, token: t}\n        - {name: a, token: u}\n", "unique"},
+		{"unknown instance", "        - {name: a, token: t, instance: nope}\n", `unknown instance "nope"`},
+		{"invalid template", "        - {name: a, token: t, template: '{{.Title'}\n", "invalid template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := loadInstancesConfig(t, config(tt.targets))
+			if err := fm.initInstances(); err != nil {
+				t.Fatal(err)
+			}
+			err := fm.initTargets()
+			if tt.want == "" && err != nil {
+				t.Errorf("initTargets() error = %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("initTargets() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFeed_Targets(t *testing.T) {
+	const config = `instance:
+  url: https://national.example
+  lang: pl
+  limit: 500
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: national-token
+      visibility: public
+      hashtag: News
+      targets:
+        - name: regional
+          token: regional-token
+          instance: regional
+          visibility: unlisted
+          hashtag: Lodz
+          language: en
+          template: "{{.Title}} {{.Hashtags}} {{.Link}}"
+instances:
+  - name: regional
+    url: https://regional.example
+    limit: 500
+`
+	fm := loadInstancesConfig(t, config)
+	fm.Parser = NewParser(nil)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initTargets(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	posts := make(map[string]MastodonPost) // host -> post
+	for _, mi := range fm.instances {
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				var post MastodonPost
+				jsoniter.Unmarshal(req.Body(), &post)
+				mu.Lock()
+				posts[string(req.URI().Host())+" "+string(req.Header.Peek("Authorization"))] = post
+				mu.Unlock()
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{"id":"1"}`)
+				return nil
+			},
+		}
+	}
+
+	f := fm.Instance.Feeds[0]
+	fm.setFeedDefaults(f)
+	guid := fmt.Sprintf("target-test-%d", time.Now().UnixNano())
+	now := time.Now()
+	feed := &gofeed.Feed{Items: []*gofeed.Item{{
+		Title:           "Headline",
+		Description:     "Body",
+		Link:            "https://example.com/a",
+		GUID:            guid,
+		PublishedParsed: &now,
+	}}}
+
+	debugMode = false
+	defer func() { debugMode = true }()
+	fm.processFeed(f, feed)
+
+	national, ok := posts["national.example Bearer national-token"]
+	if !ok {
+		t.Fatalf("nothing posted to the feed account: %v", posts)
+	}
+	if national.Visibility != "public" || national.Language != "pl" || !strings.Contains(national.Status, "#News") {
+		t.Errorf("feed account post = %+v", national)
+	}
+	regional, ok := posts["regional.example Bearer regional-token"]
+	if !ok {
+		t.Fatalf("nothing posted to the target account: %v", posts)
+	}
+	if regional.Visibility != "unlisted" || regional.Language != "en" ||
+		regional.Status != "Headline #Lodz https://example.com/a" {
+		t.Errorf("target post = %+v", regional)
+	}
+
+	if f.Count != 1 || f.Targets[0].Count != 1 || f.Targets[0].SendTime.IsZero() {
+		t.Errorf("counters: feed %d, target %d", f.Count, f.Targets[0].Count)
+	}
+	if ns := f.namespace(); !Cache.KeyExists(ns+":"+hashString(guid)) || !Cache.KeyExists(ns+":@regional:"+hashString(guid)) {
+		t.Error("each target needs its own idempotency key")
+	}
+
+	// a second run posts nothing
+	clear(posts)
+	fm.processFeed(f, feed)
+	if len(posts) != 0 {
+		t.Errorf("item posted again: %v", posts)
+	}
+}
@@ -0,0 +1,593 @@
+package rss2masto
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+)
+
+// Severity is the severity of a configuration problem
+type Severity int
+
+const (
+	// SeverityWarning marks a setting that is probably wrong but doesn't prevent the monitor from running
+	SeverityWarning Severity = iota
+	// SeverityError marks an invalid setting, the configuration is rejected
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is a configuration problem found by Validate
+type Problem struct {
+	Line     int      // 1-based line in the configuration file, 0 if unknown
+	Column   int      // 1-based column, 0 if unknown
+	Severity Severity // warning or error
+	Path     string   // key path, e.g. instance.feed[2].visibility
+	Message  string
+}
+
+// String formats the problem as "line:column: severity: path: message"
+func (p Problem) String() string {
+	var b strings.Builder
+	switch {
+	case p.Column > 0:
+		fmt.Fprintf(&b, "%d:%d: ", p.Line, p.Column)
+	case p.Line > 0:
+		fmt.Fprintf(&b, "%d: ", p.Line)
+	}
+	b.WriteString(p.Severity.String())
+	b.WriteString(": ")
+	if p.Path != "" {
+		b.WriteString(p.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidationError is returned when the configuration has problems of SeverityError
+type ValidationError struct {
+	File     string
+	Problems []Problem // errors only
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration %s:", e.File)
+	for _, p := range e.Problems {
+		b.WriteString("\n  ")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// ValidateConfig validates the configuration file, see Validate
+func ValidateConfig() ([]Problem, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(data)
+}
+
+// Validate checks a configuration and returns all problems found, in file order.
+// The error is only set when the document can't be parsed as YAML at all.
+// Checked are:
+// - unknown keys and values of the wrong type
+// - feed, instance and publisher URLs
+// - regular expressions and templates
+// - duplicate feed names and ids, target, instance and publisher names
+// - references to unknown instances and publishers
+// - languages, time zones and visibility values
+// - feeds without any account to publish to
+func Validate(data []byte) ([]Problem, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	v := &validator{}
+	if len(doc.Content) == 0 {
+		v.add(&doc, SeverityError, "", "empty configuration")
+		return v.problems, nil
+	}
+	root := doc.Content[0]
+	v.checkKeys(root, reflect.TypeFor[FeedsMonitor](), "")
+	v.checkTypes(data)
+	v.checkConfig(root)
+	v.sort()
+	return v.problems, nil
+}
+
+// checkConfig validates the configuration file, printing warnings.
+// It returns a ValidationError if there are errors.
+func checkConfig() error {
+	problems, err := ValidateConfig()
+	if err != nil {
+		return err
+	}
+	var errs []Problem
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			errs = append(errs, p)
+			continue
+		}
+		logger().Warn("Configuration problem", "file", filepath.Base(configFile), "line", p.Line, "column", p.Column, "path", p.Path, "problem", p.Message)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{File: configFile, Problems: errs}
+	}
+	return nil
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(n *yaml.Node, severity Severity, path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{
+		Line:     n.Line,
+		Column:   n.Column,
+		Severity: severity,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// sort orders the problems by position, keeping the order of problems at the same position
+func (v *validator) sort() {
+	slices.SortStableFunc(v.problems, func(a, b Problem) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+}
+
+// checkKeys reports keys of mappings that don't correspond to a field of typ
+func (v *validator) checkKeys(n *yaml.Node, typ reflect.Type, path string) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(typ)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				v.add(key, SeverityWarning, joinPath(path, key.Value), "unknown key")
+				continue
+			}
+			v.checkKeys(value, field.Type, joinPath(path, key.Value))
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range n.Content {
+			v.checkKeys(item, typ.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// yamlFields returns the fields of a struct by their YAML key
+func yamlFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, typ.NumField())
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		tag := f.Tag.Get("yaml")
+		name, _, _ := strings.Cut(tag, ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// checkTypes reports values that can't be decoded into their fields, e.g. text for a number
+func (v *validator) checkTypes(data []byte) {
+	var fm FeedsMonitor
+	err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&fm)
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return
+	}
+	for _, msg := range typeErr.Errors {
+		p := Problem{Severity: SeverityError, Message: msg}
+		if m := typeErrorLine.FindStringSubmatch(msg); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = m[2]
+		}
+		v.problems = append(v.problems, p)
+	}
+}
+
+// checkConfig checks the values of the configuration
+func (v *validator) checkConfig(root *yaml.Node) {
+	instance := mapValue(root, "instance")
+	if instance == nil {
+		v.add(root, SeverityError, "instance", "missing instance block")
+		return
+	}
+
+	v.checkLanguage(mapValue(instance, "lang"), "instance.lang")
+	v.checkTimeZone(mapValue(instance, "timezone"), "instance.timezone")
+
+	// instances referenced by feeds and targets, "" is the instance block
+	instances := map[string]bool{}
+	if u := mapValue(instance, "url"); u != nil && u.Value != "" {
+		v.checkURL(u, "instance.url", true)
+		instances[""] = true
+	}
+	for i, mi := range seqItems(mapValue(root, "instances")) {
+		path := fmt.Sprintf("instances[%d]", i)
+		name := v.checkName(mi, path, "instance", instances)
+		if u := mapValue(mi, "url"); u != nil {
+			v.checkURL(u, path+".url", true)
+		} else if name != "" {
+			v.add(mi, SeverityError, path, "missing url")
+		}
+		v.checkLanguage(mapValue(mi, "lang"), path+".lang")
+		v.checkTimeZone(mapValue(mi, "timezone"), path+".timezone")
+	}
+
+	publishers := map[string]bool{}
+	for i, p := range seqItems(mapValue(root, "publishers")) {
+		path := fmt.Sprintf("publishers[%d]", i)
+		name := v.checkName(p, path, "publisher", publishers)
+		if name == mastodonPublisherName || strings.HasPrefix(name, targetPublisherPrefix) {
+			v.add(mapValue(p, "name"), SeverityError, path+".name", "reserved publisher name %q", name)
+		}
+		typ := mapValue(p, "type")
+		switch {
+		case typ == nil:
+			v.add(p, SeverityError, path, "missing type")
+		case typ.Value == PublisherWebhook && mapValue(p, "url") == nil:
+			v.add(p, SeverityError, path, "missing url of the webhook")
+		case typ.Value != PublisherBluesky && typ.Value != PublisherWebhook:
+			v.add(typ, SeverityError, path+".type", "unknown publisher type %q, want %s or %s", typ.Value, PublisherBluesky, PublisherWebhook)
+		}
+		if u := mapValue(p, "url"); u != nil {
+			v.checkURL(u, path+".url", false)
+		}
+	}
+
+	for i, ch := range seqItems(mapValue(mapValue(root, "alerts"), "channels")) {
+		path := fmt.Sprintf("alerts.channels[%d]", i)
+		typ := mapValue(ch, "type")
+		switch {
+		case typ == nil:
+			v.add(ch, SeverityError, path, "missing type")
+		case typ.Value == AlertWebhook && mapValue(ch, "url") == nil:
+			v.add(ch, SeverityError, path, "missing url of the webhook")
+		case typ.Value == AlertSMTP && (mapValue(ch, "host") == nil || mapValue(ch, "to") == nil):
+			v.add(ch, SeverityError, path, "missing host or recipients of the mails")
+		case typ.Value == AlertMastodon && (!hasSecret(ch, "token") || mapValue(ch, "to") == nil):
+			v.add(ch, SeverityError, path, "missing token or mentioned accounts")
+		case typ.Value != AlertWebhook && typ.Value != AlertSMTP && typ.Value != AlertMastodon:
+			v.add(typ, SeverityError, path+".type", "unknown alert channel type %q, want %s, %s or %s", typ.Value, AlertWebhook, AlertSMTP, AlertMastodon)
+		}
+		if u := mapValue(ch, "url"); u != nil {
+			v.checkURL(u, path+".url", false)
+		}
+	}
+
+	feedsNode := mapValue(instance, "feed")
+	feeds := seqItems(feedsNode)
+	if len(feeds) == 0 {
+		v.add(instance, SeverityWarning, "instance.feed", "no feeds configured")
+	}
+	names := map[string]*yaml.Node{}
+	ids := map[string]*yaml.Node{}
+	for i, f := range feeds {
+		v.checkFeed(f, fmt.Sprintf("instance.feed[%d]", i), instances, publishers, names, ids)
+	}
+	v.checkDedup(root, feeds)
+	if len(feeds) > 0 && !instances[""] && len(instances) == 0 {
+		v.add(instance, SeverityError, "instance.url", "missing instance URL")
+	}
+}
+
+// checkFeed checks a feed; names and ids collect the names and ids seen so far
+func (v *validator) checkFeed(f *yaml.Node, path string, instances, publishers map[string]bool, names, ids map[string]*yaml.Node) {
+	if f.Kind != yaml.MappingNode {
+		return
+	}
+
+	urls := seqOrScalar(mapValue(f, "url"))
+	if len(urls) == 0 {
+		v.add(f, SeverityError, path, "missing url")
+	}
+	for i, u := range urls {
+		p := path + ".url"
+		if len(urls) > 1 {
+			p = fmt.Sprintf("%s[%d]", p, i)
+		}
+		v.checkURL(u, p, false)
+	}
+
+	// the effective name, see setFeedDefaults
+	nameNode := mapValue(f, "name")
+	name := ""
+	if nameNode != nil {
+		name = nameNode.Value
+	} else if len(urls) > 0 {
+		if u, err := url.Parse(urls[0].Value); err == nil {
+			name = u.Host
+		}
+		nameNode = f
+	}
+	name = feedNameReplacer.Replace(name)
+	if len(name) == 1 {
+		name += "_"
+	}
+	if prev, ok := names[name]; ok {
+		v.add(nameNode, SeverityError, path+".name", "duplicate feed name %q, first used on line %d", name, prev.Line)
+	} else if name != "" {
+		names[name] = nameNode
+	}
+
+	// feeds with the same id share their deduplication keys
+	if id := mapValue(f, "id"); id != nil {
+		if prev, ok := ids[id.Value]; ok {
+			v.add(id, SeverityError, path+".id", "duplicate feed id %q, first used on line %d", id.Value, prev.Line)
+		} else {
+			ids[id.Value] = id
+		}
+	}
+
+	inst := mapValue(f, "instance")
+	if inst != nil && !instances[inst.Value] {
+		v.add(inst, SeverityError, path+".instance", "unknown instance %q", inst.Value)
+	} else if inst == nil && !instances[""] && len(instances) > 0 {
+		v.add(f, SeverityError, path+".instance", "missing instance, the instance block has no URL")
+	}
+
+	for i, p := range seqItems(mapValue(f, "publish")) {
+		if !publishers[p.Value] {
+			v.add(p, SeverityError, fmt.Sprintf("%s.publish[%d]", path, i), "unknown publisher %q", p.Value)
+		}
+	}
+
+	targets := seqItems(mapValue(f, "targets"))
+	targetNames := map[string]bool{}
+	for i, t := range targets {
+		tpath := fmt.Sprintf("%s.targets[%d]", path, i)
+		v.checkName(t, tpath, "target", targetNames)
+		if !hasSecret(t, "token") {
+			v.add(t, SeverityError, tpath, "missing token, token_file or token_env")
+		}
+		if ti := mapValue(t, "instance"); ti != nil && !instances[ti.Value] {
+			v.add(ti, SeverityError, tpath+".instance", "unknown instance %q", ti.Value)
+		}
+		v.checkVisibility(mapValue(t, "visibility"), tpath+".visibility")
+		v.checkLanguage(mapValue(t, "language"), tpath+".language")
+		v.checkTemplate(mapValue(t, "template"), tpath+".template")
+	}
+
+	if !hasSecret(f, "token") && len(targets) == 0 && mapValue(f, "publish") == nil {
+		v.add(f, SeverityWarning, path, "no token, targets or publishers: the feed is skipped")
+	}
+
+	v.checkVisibility(mapValue(f, "visibility"), path+".visibility")
+	v.checkRegexp(mapValue(f, "hashlink"), path+".hashlink")
+	v.checkRegexp(mapValue(f, "replace_from"), path+".replace_from")
+	v.checkRegexp(mapValue(f, "replace_link"), path+".replace_link")
+	v.checkTemplate(mapValue(f, "template"), path+".template")
+
+	if profile := mapValue(f, "profile"); profile != nil {
+		if !hasSecret(f, "token") {
+			v.add(profile, SeverityWarning, path+".profile", "no token: the profile isn't synced")
+		}
+		if fields := seqItems(mapValue(profile, "fields")); len(fields) > maxProfileFields-1 {
+			v.add(fields[maxProfileFields-1], SeverityWarning, path+".profile.fields", "at most %d fields besides Source, the others are dropped", maxProfileFields-1)
+		}
+	}
+
+	if n := mapValue(f, "interval"); n != nil {
+		if i, err := strconv.ParseInt(n.Value, 10, 64); err == nil && i < 0 {
+			v.add(n, SeverityError, path+".interval", "negative interval")
+		}
+	}
+
+	source := mapValue(f, "source")
+	switch {
+	case source == nil || source.Value == "rss":
+	case source.Value != SourceScrape:
+		v.add(source, SeverityError, path+".source", "unknown source %q, want rss or %s", source.Value, SourceScrape)
+	case mapValue(f, "scrape") == nil:
+		v.add(source, SeverityError, path+".scrape", "missing scrape rules")
+	default:
+		rules := mapValue(f, "scrape")
+		for _, key := range []string{"item", "title"} {
+			if mapValue(rules, key) == nil {
+				v.add(rules, SeverityError, path+".scrape", "missing %s selector", key)
+			}
+		}
+		v.checkTimeZone(mapValue(rules, "timezone"), path+".scrape.timezone")
+	}
+}
+
+// checkDedup checks the dedup settings and reports dedup groups of a single feed
+func (v *validator) checkDedup(root *yaml.Node, feeds []*yaml.Node) {
+	if n := mapValue(mapValue(root, "dedup"), "distance"); n != nil {
+		if d, err := strconv.Atoi(n.Value); err == nil && (d < -1 || d > 64) {
+			v.add(n, SeverityError, "dedup.distance", "distance %d out of range -1..64", d)
+		}
+	}
+	groups := map[string][]*yaml.Node{}
+	var order []string
+	for _, f := range feeds {
+		if g := mapValue(f, "dedup"); g != nil && g.Value != "" {
+			if _, ok := groups[g.Value]; !ok {
+				order = append(order, g.Value)
+			}
+			groups[g.Value] = append(groups[g.Value], g)
+		}
+	}
+	for _, name := range order {
+		if nodes := groups[name]; len(nodes) == 1 {
+			v.add(nodes[0], SeverityWarning, "", "dedup group %q has a single feed", name)
+		}
+	}
+}
+
+// checkName checks that a list item has a unique name and returns it
+func (v *validator) checkName(n *yaml.Node, path, kind string, seen map[string]bool) string {
+	name := mapValue(n, "name")
+	if name == nil || name.Value == "" {
+		v.add(n, SeverityError, path, "missing %s name", kind)
+		return ""
+	}
+	if seen[name.Value] {
+		v.add(name, SeverityError, path+".name", "duplicate %s name %q", kind, name.Value)
+	}
+	seen[name.Value] = true
+	return name.Value
+}
+
+// checkURL checks an absolute http(s) URL; instance URLs must use https
+func (v *validator) checkURL(n *yaml.Node, path string, httpsOnly bool) {
+	u, err := url.Parse(n.Value)
+	switch {
+	case err != nil:
+		v.add(n, SeverityError, path, "invalid URL: %v", err)
+	case u.Scheme != "https" && (httpsOnly || u.Scheme != "http"):
+		if httpsOnly {
+			v.add(n, SeverityError, path, "invalid URL %q: https required", n.Value)
+		} else {
+			v.add(n, SeverityError, path, "invalid URL %q: http or https required", n.Value)
+		}
+	case u.Host == "":
+		v.add(n, SeverityError, path, "invalid URL %q: missing host", n.Value)
+	}
+}
+
+func (v *validator) checkRegexp(n *yaml.Node, path string) {
+	if n == nil {
+		return
+	}
+	if _, err := regexp.Compile(n.Value); err != nil {
+		v.add(n, SeverityError, path, "invalid regular expression: %v", err)
+	}
+}
+
+func (v *validator) checkTemplate(n *yaml.Node, path string) {
+	if n == nil {
+		return
+	}
+	if _, err := parseTemplate(n.Value); err != nil {
+		v.add(n, SeverityError, path, "invalid template: %v", err)
+	}
+}
+
+func (v *validator) checkVisibility(n *yaml.Node, path string) {
+	if n != nil && !visibilityTypes[n.Value] {
+		v.add(n, SeverityError, path, "invalid visibility %q, want public, unlisted or private", n.Value)
+	}
+}
+
+func (v *validator) checkLanguage(n *yaml.Node, path string) {
+	if n == nil || n.Value == "" {
+		return
+	}
+	if _, err := language.Parse(n.Value); err != nil {
+		v.add(n, SeverityError, path, "invalid language %q", n.Value)
+	}
+}
+
+func (v *validator) checkTimeZone(n *yaml.Node, path string) {
+	if n == nil || n.Value == "" {
+		return
+	}
+	if _, err := time.LoadLocation(n.Value); err != nil {
+		v.add(n, SeverityError, path, "invalid time zone %q", n.Value)
+	}
+}
+
+// hasSecret reports whether a mapping sets key, key_file or key_env
+func hasSecret(n *yaml.Node, key string) bool {
+	for _, k := range []string{key, key + "_file", key + "_env"} {
+		if s := mapValue(n, k); s != nil && s.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mapValue returns the value of key in a mapping node, or nil
+func mapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			value := n.Content[i+1]
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			if value.Tag == "!!null" {
+				return nil
+			}
+			return value
+		}
+	}
+	return nil
+}
+
+// seqItems returns the items of a sequence node
+func seqItems(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+// seqOrScalar returns the items of a sequence, or a scalar as a single item (see FeedURLs)
+func seqOrScalar(n *yaml.Node) []*yaml.Node {
+	if n != nil && n.Kind == yaml.ScalarNode {
+		return []*yaml.Node{n}
+	}
+	return seqItems(n)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,284 @@
+package rss2masto
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	config := `instance:
+  url: https://mastodon.example
+  lang: en
+  timezone: Europe/Warsaw
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      token: t
+      visibility: friends
+      hashlink: "(["
+      interval: abc
+      colour: red
+    - name: Newsroom
+      id: news
+      url: example.com/feed
+      token: t
+      publish: [bsky]
+      template: "{{.Title"
+    - name: News
+      url: https://example.com/other.xml
+      id: news
+      instance: regional
+`
+	problems, err := Validate([]byte(config))
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	want := []struct {
+		line, column int
+		severity     Severity
+		path, msg    string
+	}{
+		{9, 19, SeverityError, "instance.feed[0].visibility", `invalid visibility "friends"`},
+		{10, 17, SeverityError, "instance.feed[0].hashlink", "invalid regular expression"},
+		{11, 0, SeverityError, "", "cannot unmarshal"},
+		{12, 7, SeverityWarning, "instance.feed[0].colour", "unknown key"},
+		{15, 12, SeverityError, "instance.feed[1].url", "http or https required"},
+		{17, 17, SeverityError, "instance.feed[1].publish[0]", `unknown publisher "bsky"`},
+		{18, 17, SeverityError, "instance.feed[1].template", "invalid template"},
+		{19, 7, SeverityWarning, "instance.feed[2]", "no token"},
+		{19, 13, SeverityError, "instance.feed[2].name", "duplicate feed name \"News\", first used on line 6"},
+		{21, 11, SeverityError, "instance.feed[2].id", `duplicate feed id "news", first used on line 14`},
+		{22, 17, SeverityError, "instance.feed[2].instance", `unknown instance "regional"`},
+	}
+	if len(problems) != len(want) {
+		for _, p := range problems {
+			t.Log(p)
+		}
+		t.Fatalf("Validate() found %d problems, want %d", len(problems), len(want))
+	}
+	for i, w := range want {
+		p := problems[i]
+		if p.Line != w.line || p.Column != w.column || p.Severity != w.severity || p.Path != w.path || !strings.Contains(p.Message, w.msg) {
+			t.Errorf("problem %d = %s, want %d:%d: %s: %s: %s", i, p, w.line, w.column, w.severity, w.path, w.msg)
+		}
+	}
+}
+
+func TestValidate_Instances(t *testing.T) {
+	config := `instance:
+  lang: english!
+  feed:
+    - url: https://example.com/news.xml
+      token: t
+      instance: regional
+      targets:
+        - name: city
+          instance: city
+          visibility: public
+        - name: city
+          token_env: CITY_TOKEN
+          language: pl
+instances:
+  - name: regional
+    url: http://regional.example
+    timezone: Nowhere/City
+  - name: regional
+    url: https://other.example
+publishers:
+  - name: mastodon
+    type: bluesky
+  - name: hook
+    type: webhook
+`
+	problems, err := Validate([]byte(config))
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`2:9: error: instance.lang: invalid language "english!"`,
+		"8:11: error: instance.feed[0].targets[0]: missing token, token_file or token_env",
+		`9:21: error: instance.feed[0].targets[0].instance: unknown instance "city"`,
+		`11:17: error: instance.feed[0].targets[1].name: duplicate target name "city"`,
+		`16:10: error: instances[0].url: invalid URL "http://regional.example": https required`,
+		`17:15: error: instances[0].timezone: invalid time zone "Nowhere/City"`,
+		`18:11: error: instances[1].name: duplicate instance name "regional"`,
+		`21:11: error: publishers[0].name: reserved publisher name "mastodon"`,
+		"23:5: error: publishers[1]: missing url of the webhook",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidate_Valid(t *testing.T) {
+	config := `instance:
+  url: https://mastodon.example
+  lang: en
+  timezone: UTC
+  feed:
+    - name: News
+      url:
+        - https://example.com/news.xml
+        - https://mirror.example.com/news.xml
+      token_file: news.token
+      visibility: unlisted
+      replace_from: "\\s+"
+      publish: [bsky]
+    - name: Blog
+      url: https://example.com/blog
+      token: t
+      source: scrape
+      scrape:
+        item: article
+        title: h2
+publishers:
+  - name: bsky
+    type: bluesky
+    identifier: bot.example
+server:
+  listen: ":8080"
+`
+	problems, err := Validate([]byte(config))
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Validate() = %v, %v, want no problems", problems, err)
+	}
+
+	if _, err := Validate([]byte("instance: [")); err == nil {
+		t.Error("Validate() of invalid YAML succeeded")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	originalConfigFile := configFile
+	configFile = filepath.Join(t.TempDir(), "feed.yaml")
+	defer func() { configFile = originalConfigFile }()
+
+	config := `instance:
+  url: https://mastodon.example
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      token: t
+      visibility: friends
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := checkConfig()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || !strings.Contains(err.Error(), "7:19: error: instance.feed[0].visibility") {
+		t.Errorf("checkConfig() = %v", err)
+	}
+	if _, err := NewFeedsMonitor(); !errors.As(err, &verr) {
+		t.Errorf("NewFeedsMonitor() error = %v, want a ValidationError", err)
+	}
+}
+
+func TestValidate_Dedup(t *testing.T) {
+	config := `instance:
+  url: https://mastodon.example
+  feed:
+    - name: Wire
+      url: https://wire.example/rss
+      token: t
+      dedup: wire
+    - name: Local
+      url: https://local.example/rss
+      token: t
+      dedup: wires
+dedup:
+  window: 12h
+  distance: 80
+`
+	problems, err := Validate([]byte(config))
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`7:14: warning: dedup group "wire" has a single feed`,
+		`11:14: warning: dedup group "wires" has a single feed`,
+		"14:13: error: dedup.distance: distance 80 out of range -1..64",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidate_Alerts(t *testing.T) {
+	config := `instance:
+  url: https://mastodon.example
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      token: t
+alerts:
+  channels:
+    - type: webhook
+      url: https://hooks.example/alerts
+    - type: smtp
+      host: mail.example:25
+    - type: pager
+`
+	problems, err := Validate([]byte(config))
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"11:7: error: alerts.channels[1]: missing host or recipients of the mails",
+		`13:13: error: alerts.channels[2].type: unknown alert channel type "pager", want webhook, smtp or mastodon`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidate_Profile(t *testing.T) {
+	config := `instance:
+  url: https://mastodon.example
+  feed:
+    - name: News
+      url: https://example.com/news.xml
+      publish: [hook]
+      profile:
+        fields:
+          - {name: A, value: "1"}
+          - {name: B, value: "2"}
+          - {name: C, value: "3"}
+          - {name: D, value: "4"}
+publishers:
+  - name: hook
+    type: webhook
+    url: https://hooks.example/news
+`
+	problems, err := Validate([]byte(config))
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"8:9: warning: instance.feed[0].profile: no token: the profile isn't synced",
+		"12:13: warning: instance.feed[0].profile.fields: at most 3 fields besides Source, the others are dropped",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
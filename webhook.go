@@ -0,0 +1,135 @@
+package rss2masto
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+// Webhook events sent in webhookPayload.Event
+const (
+	webhookPublish = "publish"
+	webhookEdit    = "edit"
+	webhookDelete  = "delete"
+)
+
+// WebhookPublisher sends posts as JSON to an HTTP endpoint, e.g. a bridge to
+// Misskey or a Matrix room. When a secret is configured, the body is signed
+// with HMAC-SHA256 and the signature sent as "X-Signature: sha256=<hex>".
+// The endpoint may answer with {"id": ..., "url": ...} to enable Edit and Delete.
+type WebhookPublisher struct {
+	URL     string
+	Secret  string
+	Headers map[string]string
+	Limit   int
+	client  httpClient
+}
+
+type webhookPayload struct {
+	Event          string `json:"event"`
+	ID             string `json:"id,omitempty"`
+	Text           string `json:"text,omitempty"`
+	Title          string `json:"title,omitempty"`
+	Description    string `json:"description,omitempty"`
+	Hashtags       string `json:"hashtags,omitempty"`
+	Link           string `json:"link,omitempty"`
+	Image          string `json:"image,omitempty"`
+	Language       string `json:"language,omitempty"`
+	Visibility     string `json:"visibility,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// NewWebhookPublisher creates a webhook publisher from its configuration
+func NewWebhookPublisher(cfg *PublisherConfig, client httpClient) *WebhookPublisher {
+	headers := cfg.headers
+	if headers == nil {
+		headers = cfg.Headers
+	}
+	return &WebhookPublisher{
+		URL:     cfg.URL,
+		Secret:  cmp.Or(cfg.secret, cfg.Secret),
+		Headers: headers,
+		Limit:   cfg.Limit,
+		client:  client,
+	}
+}
+
+// Publish sends a publish event with all fields of the post
+func (w *WebhookPublisher) Publish(p *Post) (*Published, error) {
+	body, err := w.send(w.payload(webhookPublish, "", p))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return &Published{}, nil
+	}
+	return &Published{
+		ID:  jsoniter.Get(body, "id").ToString(),
+		URL: jsoniter.Get(body, "url").ToString(),
+	}, nil
+}
+
+// Edit sends an edit event with the post ID returned by the endpoint
+func (w *WebhookPublisher) Edit(id string, p *Post) error {
+	_, err := w.send(w.payload(webhookEdit, id, p))
+	return err
+}
+
+// Delete sends a delete event with the post ID returned by the endpoint
+func (w *WebhookPublisher) Delete(id string) error {
+	_, err := w.send(&webhookPayload{Event: webhookDelete, ID: id})
+	return err
+}
+
+// Capabilities returns the configured length limit (unlimited by default).
+// The image URL is part of the payload.
+func (w *WebhookPublisher) Capabilities() Capabilities {
+	return Capabilities{
+		MaxLength: w.Limit,
+		Media:     true,
+		Edit:      true,
+		Delete:    true,
+	}
+}
+
+func (w *WebhookPublisher) payload(event, id string, p *Post) *webhookPayload {
+	return &webhookPayload{
+		Event:          event,
+		ID:             id,
+		Text:           p.Text,
+		Title:          p.Title,
+		Description:    p.Description,
+		Hashtags:       p.Hashtags,
+		Link:           p.Link,
+		Image:          p.Image,
+		Language:       p.Language,
+		Visibility:     p.Visibility,
+		IdempotencyKey: p.IdempotencyKey,
+	}
+}
+
+func (w *WebhookPublisher) send(payload *webhookPayload) ([]byte, error) {
+	body, err := jsoniter.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]string, 0, 2*len(w.Headers)+2)
+	for k, v := range w.Headers {
+		headers = append(headers, k, v)
+	}
+	if w.Secret != "" {
+		headers = append(headers, "X-Signature", webhookSignature(w.Secret, body))
+	}
+	return sendJSON(w.client, fasthttp.MethodPost, w.URL, body, headers...)
+}
+
+// webhookSignature returns the X-Signature header of a body: sha256=<hex HMAC-SHA256>
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,421 @@
+package rss2masto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/valyala/fasthttp"
+)
+
+// WebSubConfig holds the settings for WebSub (PubSubHubbub) push subscriptions.
+// Subscriptions are only made when Callback is set and a feed advertises a hub.
+type WebSubConfig struct {
+	Callback   string        `yaml:"callback,omitempty"`    // public URL of the /websub endpoint, e.g. https://bot.example/websub
+	Secret     string        `yaml:"secret,omitempty"`      // secret used to derive per-subscription HMAC keys, ${VAR} references are expanded; random if empty
+	Lease      time.Duration `yaml:"lease,omitempty"`       // requested lease duration
+	PollFactor int64         `yaml:"poll_factor,omitempty"` // polling interval multiplier for feeds with an active subscription
+
+	SecretFile string `yaml:"secret_file,omitempty"` // file containing the secret, overrides Secret
+	SecretEnv  string `yaml:"secret_env,omitempty"`  // environment variable containing the secret, overrides Secret
+
+	secret string // resolved secret, see FeedsMonitor.resolveSecrets
+}
+
+const (
+	defaultWebSubLease      = 24 * time.Hour
+	defaultWebSubPollFactor = 6
+	webSubRetryDelay        = time.Hour // delay before repeating an unanswered or denied request
+)
+
+// hubRef holds the hub and topic (self) URLs advertised by a feed
+type hubRef struct {
+	hub   string
+	topic string
+}
+
+// subscription holds the state of the WebSub subscription of a single feed
+type subscription struct {
+	feed     *Feed
+	hub      string
+	topic    string
+	callback string
+	secret   string
+
+	mu        sync.Mutex
+	mode      string        // mode of the last request awaiting verification
+	requested time.Time     // time of the last request sent to the hub
+	lease     time.Duration // lease granted by the hub
+	expires   time.Time     // zero if not subscribed
+}
+
+var (
+	reHubLinkTag  = regexp.MustCompile(`(?is)<(?:[a-z0-9]+:)?link\b[^>]*>`)
+	reHubLinkAttr = regexp.MustCompile(`(?is)\b(rel|href)\s*=\s*["']([^"']*)["']`)
+	webSubKey     []byte
+	webSubKeyOnce sync.Once
+)
+
+func (c *WebSubConfig) lease() time.Duration {
+	if c.Lease > 0 {
+		return c.Lease
+	}
+	return defaultWebSubLease
+}
+
+func (c *WebSubConfig) pollFactor() int64 {
+	if c.PollFactor > 0 {
+		return c.PollFactor
+	}
+	return defaultWebSubPollFactor
+}
+
+// key returns the secret used to derive subscription secrets.
+// Without a configured secret, a random one is generated once per process.
+func (c *WebSubConfig) key() []byte {
+	if c.secret != "" {
+		return []byte(c.secret)
+	}
+	if c.Secret != "" {
+		return []byte(c.Secret)
+	}
+	webSubKeyOnce.Do(func() {
+		webSubKey = make([]byte, 32)
+		_, _ = rand.Read(webSubKey)
+	})
+	return webSubKey
+}
+
+// setHub stores the hub advertised by the feed; the topic defaults to the fetched URL
+func (f *Feed) setHub(hub, topic string) {
+	if old := f.hub.Load(); old != nil && old.hub == hub && old.topic == topic {
+		return
+	}
+	f.hub.Store(&hubRef{hub: hub, topic: topic})
+}
+
+// findHubLinks returns the hub and self URLs advertised by a feed,
+// either in HTTP Link headers or in <link> / <atom:link> elements of the body.
+func findHubLinks(headers [][]byte, body []byte) (hub, self string) {
+	for _, h := range headers {
+		for link := range strings.SplitSeq(string(h), ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			for param := range strings.SplitSeq(params, ";") {
+				key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(key, "rel") {
+					continue
+				}
+				for rel := range strings.FieldsSeq(strings.ToLower(strings.Trim(val, `"`))) {
+					if rel == "hub" && hub == "" {
+						hub = target
+					} else if rel == "self" && self == "" {
+						self = target
+					}
+				}
+			}
+		}
+	}
+	if hub != "" || !bytes.Contains(body, []byte("hub")) {
+		return
+	}
+
+	for _, tag := range reHubLinkTag.FindAll(body, -1) {
+		var rel, href string
+		for _, attr := range reHubLinkAttr.FindAllSubmatch(tag, -1) {
+			if strings.EqualFold(string(attr[1]), "rel") {
+				rel = strings.ToLower(string(attr[2]))
+			} else {
+				href = string(attr[2])
+			}
+		}
+		if rel == "hub" && hub == "" {
+			hub = href
+		} else if rel == "self" && self == "" {
+			self = href
+		}
+	}
+	return
+}
+
+// needsSubscription reports whether the feed's WebSub subscription must be
+// requested or renewed. Leases are renewed when less than a fifth is left.
+func (fm *FeedsMonitor) needsSubscription(f *Feed) bool {
+	if fm.WebSub.Callback == "" {
+		return false
+	}
+	link := f.hub.Load()
+	if link == nil {
+		return false
+	}
+	sub := fm.subscription(f, link)
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	now := time.Now()
+	if sub.mode != "" || sub.expires.IsZero() {
+		return now.Sub(sub.requested) > webSubRetryDelay
+	}
+	return now.After(sub.expires.Add(-sub.lease / 5))
+}
+
+// subscription returns the subscription for the feed's current hub and topic,
+// registering a new one if the feed advertises a different hub.
+func (fm *FeedsMonitor) subscription(f *Feed, link *hubRef) *subscription {
+	id := hashString(f.label() + "\n" + link.hub + "\n" + link.topic)
+	if v, ok := fm.subs.Load(id); ok {
+		return v.(*subscription)
+	}
+	mac := hmac.New(sha256.New, fm.WebSub.key())
+	mac.Write([]byte(id))
+
+	v, _ := fm.subs.LoadOrStore(id, &subscription{
+		feed:     f,
+		hub:      link.hub,
+		topic:    link.topic,
+		callback: strings.TrimSuffix(fm.WebSub.Callback, "/") + "/" + id,
+		secret:   hex.EncodeToString(mac.Sum(nil)),
+	})
+	return v.(*subscription)
+}
+
+// isSubscribed reports whether the feed has an active WebSub subscription
+func (fm *FeedsMonitor) isSubscribed(f *Feed) bool {
+	link := f.hub.Load()
+	if link == nil || fm.WebSub.Callback == "" {
+		return false
+	}
+	sub := fm.subscription(f, link)
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return time.Now().Before(sub.expires)
+}
+
+// subscribeFeed requests or renews the WebSub subscription of a feed
+func (fm *FeedsMonitor) subscribeFeed(f *Feed) {
+	link := f.hub.Load()
+	if link == nil {
+		return
+	}
+	if err := fm.webSubRequest(fm.subscription(f, link), "subscribe"); err != nil {
+		fm.feedLog(f).Warn("WebSub subscribe error", errAttrs(err, "hub", link.hub)...)
+	}
+}
+
+// webSubRequest sends a subscribe or unsubscribe request to the hub.
+// The hub confirms the request asynchronously by calling the callback.
+func (fm *FeedsMonitor) webSubRequest(sub *subscription, mode string) error {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	args.Set("hub.mode", mode)
+	args.Set("hub.topic", sub.topic)
+	args.Set("hub.callback", sub.callback)
+	if mode == "subscribe" {
+		args.Set("hub.lease_seconds", strconv.FormatInt(int64(fm.WebSub.lease().Seconds()), 10))
+		args.Set("hub.secret", sub.secret)
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(sub.hub)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.SetBody(args.QueryString())
+
+	sub.mu.Lock()
+	sub.mode = mode
+	sub.requested = time.Now()
+	sub.mu.Unlock()
+
+	if err := fm.Parser.Client.Do(req, resp); err != nil {
+		return err
+	}
+	if resp.StatusCode() != fasthttp.StatusAccepted && resp.StatusCode() != fasthttp.StatusNoContent &&
+		resp.StatusCode() != fasthttp.StatusOK {
+		return fmt.Errorf("hub returned status: %d [%s]", resp.StatusCode(), resp.Body())
+	}
+	return nil
+}
+
+// webSubHandler serves the WebSub callback endpoint /websub/<id>.
+// GET requests are verifications of intent, POST requests deliver content.
+func (fm *FeedsMonitor) webSubHandler(ctx *fasthttp.RequestCtx) {
+	v, ok := fm.subs.Load(path.Base(b2s(ctx.Path())))
+	if !ok {
+		ctx.Error("Not Found", fasthttp.StatusNotFound)
+		return
+	}
+	sub := v.(*subscription)
+
+	switch {
+	case ctx.IsGet():
+		fm.webSubVerify(ctx, sub)
+	case ctx.IsPost():
+		fm.webSubPush(ctx, sub)
+	default:
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+	}
+}
+
+// webSubVerify answers the hub's verification of intent by echoing the challenge,
+// but only for the topic and mode that were requested.
+func (fm *FeedsMonitor) webSubVerify(ctx *fasthttp.RequestCtx, sub *subscription) {
+	args := ctx.QueryArgs()
+	mode := string(args.Peek("hub.mode"))
+	if string(args.Peek("hub.topic")) != sub.topic {
+		ctx.Error("Not Found", fasthttp.StatusNotFound)
+		return
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if mode == "denied" {
+		fm.feedLog(sub.feed).Warn("WebSub subscription denied", "hub", sub.hub, "reason", string(args.Peek("hub.reason")))
+		sub.mode = ""
+		sub.expires = time.Time{}
+		return
+	}
+	if mode == "" || mode != sub.mode {
+		ctx.Error("Not Found", fasthttp.StatusNotFound)
+		return
+	}
+
+	sub.mode = ""
+	sub.expires = time.Time{}
+	if mode == "subscribe" {
+		sub.lease = time.Duration(args.GetUintOrZero("hub.lease_seconds")) * time.Second
+		if sub.lease <= 0 {
+			sub.lease = fm.WebSub.lease()
+		}
+		sub.expires = time.Now().Add(sub.lease)
+	}
+	ctx.SetContentType("text/plain")
+	ctx.SetBody(args.Peek("hub.challenge"))
+}
+
+// webSubPush handles content distributed by the hub.
+// Content with a missing or invalid signature is acknowledged but ignored.
+func (fm *FeedsMonitor) webSubPush(ctx *fasthttp.RequestCtx, sub *subscription) {
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+
+	body := ctx.PostBody()
+	if !verifySignature(sub.secret, body, b2s(ctx.Request.Header.Peek("X-Hub-Signature"))) {
+		fm.feedLog(sub.feed).Warn("WebSub content with invalid signature ignored", "hub", sub.hub)
+		return
+	}
+
+	if sub.feed.Paused() {
+		return
+	}
+
+	feed, err := fm.Parser.parse(body)
+	if err != nil {
+		fm.feedLog(sub.feed).Error("Error parsing WebSub content", classAttrs(errClassParse, err, "hub", sub.hub)...)
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		return
+	}
+	// a feed is processed once at a time: the content of a push arriving meanwhile
+	// is kept and processed when the running fetch or push ends, see endFetch
+	f := sub.feed
+	if !f.fetching.CompareAndSwap(false, true) {
+		f.queuePush(feed)
+		fm.feedLog(f).Debug("WebSub content queued, feed being processed", "hub", sub.hub)
+		return
+	}
+	go func() {
+		release := fm.acquireWorker()
+		defer release()
+		defer fm.endFetch(f)
+		fm.processFeed(f, feed)
+	}()
+}
+
+// queuePush keeps pushed content until the feed is processed again; the items
+// of a push already waiting are kept too
+func (f *Feed) queuePush(feed *gofeed.Feed) {
+	f.pushMu.Lock()
+	defer f.pushMu.Unlock()
+	if f.pushed != nil {
+		feed.Items = append(f.pushed.Items, feed.Items...)
+	}
+	f.pushed = feed
+}
+
+// takePush returns and clears the content waiting for the feed, nil if there is none
+func (f *Feed) takePush() *gofeed.Feed {
+	f.pushMu.Lock()
+	defer f.pushMu.Unlock()
+	feed := f.pushed
+	f.pushed = nil
+	return feed
+}
+
+// endFetch processes the content pushed while the feed was fetched or processed,
+// then clears its fetching mark. The caller holds a worker, if any.
+func (fm *FeedsMonitor) endFetch(f *Feed) {
+	for {
+		for feed := f.takePush(); feed != nil; feed = f.takePush() {
+			fm.processFeed(f, feed)
+		}
+		f.fetching.Store(false)
+		// a push queued after the last take is processed here, unless another fetch took the feed
+		f.pushMu.Lock()
+		waiting := f.pushed != nil
+		f.pushMu.Unlock()
+		if !waiting || !f.fetching.CompareAndSwap(false, true) {
+			return
+		}
+	}
+}
+
+// verifySignature checks an X-Hub-Signature header ("method=hexdigest") against body
+func verifySignature(secret string, body []byte, signature string) bool {
+	method, digest, ok := strings.Cut(signature, "=")
+	if !ok {
+		return false
+	}
+	var h func() hash.Hash
+	switch strings.ToLower(method) {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+	want, err := hex.DecodeString(digest)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
@@ -0,0 +1,388 @@
+package rss2masto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+func TestFindHubLinks(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   []string
+		body      string
+		wantHub   string
+		wantTopic string
+	}{
+		{
+			name:      "link header",
+			headers:   []string{`<https://hub.example.com/>; rel="hub", <https://example.com/feed>; rel="self"`},
+			wantHub:   "https://hub.example.com/",
+			wantTopic: "https://example.com/feed",
+		},
+		{
+			name: "atom link in rss",
+			body: `<rss><channel><atom:link href="https://example.com/rss" rel="self" type="application/rss+xml"/>
+<atom:link rel="hub" href="https://pubsubhubbub.appspot.com/"/></channel></rss>`,
+			wantHub:   "https://pubsubhubbub.appspot.com/",
+			wantTopic: "https://example.com/rss",
+		},
+		{
+			name:    "atom feed without self",
+			body:    `<feed xmlns="http://www.w3.org/2005/Atom"><link rel='hub' href='https://hub.example.org'/></feed>`,
+			wantHub: "https://hub.example.org",
+		},
+		{
+			name: "no hub",
+			body: `<rss><channel><atom:link href="https://example.com/rss" rel="self"/></channel></rss>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers [][]byte
+			for _, h := range tt.headers {
+				headers = append(headers, []byte(h))
+			}
+			hub, topic := findHubLinks(headers, []byte(tt.body))
+			if hub != tt.wantHub || (tt.wantTopic != "" && topic != tt.wantTopic) {
+				t.Errorf("findHubLinks() = %q, %q, want %q, %q", hub, topic, tt.wantHub, tt.wantTopic)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte("<feed/>")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !verifySignature("secret", body, valid) {
+		t.Error("valid signature rejected")
+	}
+	if verifySignature("other", body, valid) {
+		t.Error("signature with wrong secret accepted")
+	}
+	if verifySignature("secret", []byte("<feed>tampered</feed>"), valid) {
+		t.Error("signature of tampered body accepted")
+	}
+	for _, sig := range []string{"", "sha256", "md5=abc", "sha256=zz"} {
+		if verifySignature("secret", body, sig) {
+			t.Errorf("malformed signature %q accepted", sig)
+		}
+	}
+}
+
+func TestWebSubSubscription(t *testing.T) {
+	var form *fasthttp.Args
+	fm := &FeedsMonitor{}
+	fm.WebSub = WebSubConfig{Callback: "https://bot.example/websub/", Secret: "s3cret", Lease: time.Hour}
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			form = &fasthttp.Args{}
+			form.ParseBytes(req.Body())
+			resp.SetStatusCode(fasthttp.StatusAccepted)
+			return nil
+		},
+	})
+	feed := NewTestFeed("te", "https://example.com/feed.xml")
+
+	if fm.needsSubscription(feed) {
+		t.Fatal("feed without hub should not be subscribed")
+	}
+	feed.setHub("https://hub.example.com/", "https://example.com/feed.xml")
+	if !fm.needsSubscription(feed) {
+		t.Fatal("feed with hub should be subscribed")
+	}
+
+	fm.subscribeFeed(feed)
+
+	if form == nil {
+		t.Fatal("no request sent to hub")
+	}
+	if string(form.Peek("hub.mode")) != "subscribe" || string(form.Peek("hub.lease_seconds")) != "3600" {
+		t.Errorf("unexpected subscription request: %s", form.QueryString())
+	}
+	callback := string(form.Peek("hub.callback"))
+	if !strings.HasPrefix(callback, "https://bot.example/websub/") {
+		t.Errorf("hub.callback = %q", callback)
+	}
+	if fm.needsSubscription(feed) {
+		t.Error("pending subscription should not be repeated immediately")
+	}
+	if fm.isSubscribed(feed) {
+		t.Error("subscription active before verification")
+	}
+
+	handler := fm.Handler()
+	cbPath := strings.TrimPrefix(callback, "https://bot.example")
+
+	verify := func(mode, topic string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		q := url.Values{
+			"hub.mode":          {mode},
+			"hub.topic":         {topic},
+			"hub.challenge":     {"challenge-123"},
+			"hub.lease_seconds": {"7200"},
+		}
+		ctx.Request.SetRequestURI(cbPath + "?" + q.Encode())
+		handler(ctx)
+		return ctx
+	}
+
+	t.Run("wrong topic is rejected", func(t *testing.T) {
+		ctx := verify("subscribe", "https://evil.example/feed")
+		if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+			t.Errorf("status = %d, want 404", ctx.Response.StatusCode())
+		}
+	})
+
+	t.Run("unrequested mode is rejected", func(t *testing.T) {
+		ctx := verify("unsubscribe", "https://example.com/feed.xml")
+		if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+			t.Errorf("status = %d, want 404", ctx.Response.StatusCode())
+		}
+	})
+
+	t.Run("challenge is echoed", func(t *testing.T) {
+		ctx := verify("subscribe", "https://example.com/feed.xml")
+		if ctx.Response.StatusCode() != fasthttp.StatusOK || string(ctx.Response.Body()) != "challenge-123" {
+			t.Errorf("status = %d, body = %q", ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+		if !fm.isSubscribed(feed) {
+			t.Error("subscription should be active after verification")
+		}
+		if fm.needsSubscription(feed) {
+			t.Error("fresh lease should not be renewed")
+		}
+	})
+
+	t.Run("lease renewed near expiry", func(t *testing.T) {
+		sub := fm.subscription(feed, feed.hub.Load())
+		sub.mu.Lock()
+		sub.expires = time.Now().Add(sub.lease / 10)
+		sub.mu.Unlock()
+		if !fm.needsSubscription(feed) {
+			t.Error("expiring lease should be renewed")
+		}
+	})
+
+	t.Run("push with invalid signature is ignored", func(t *testing.T) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI(cbPath)
+		ctx.Request.Header.Set("X-Hub-Signature", "sha256=00")
+		ctx.Request.SetBodyString("not a feed")
+		handler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+			t.Errorf("status = %d, want 202", ctx.Response.StatusCode())
+		}
+	})
+
+	t.Run("signed push with invalid content", func(t *testing.T) {
+		body := []byte("not a feed")
+		sub := fm.subscription(feed, feed.hub.Load())
+		mac := hmac.New(sha256.New, []byte(sub.secret))
+		mac.Write(body)
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI(cbPath)
+		ctx.Request.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		ctx.Request.SetBody(body)
+		handler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+			t.Errorf("status = %d, want 400", ctx.Response.StatusCode())
+		}
+	})
+
+	t.Run("unknown callback", func(t *testing.T) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/websub/unknown")
+		handler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+			t.Errorf("status = %d, want 404", ctx.Response.StatusCode())
+		}
+	})
+}
+
+func TestFetchAndParse_DetectsHub(t *testing.T) {
+	p := NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			resp.SetStatusCode(fasthttp.StatusOK)
+			resp.Header.Set("Link", `<https://hub.example.com/>; rel="hub"`)
+			resp.SetBodyString(`<rss version="2.0"><channel><title>T</title></channel></rss>`)
+			return nil
+		},
+	})
+	feed := NewTestFeed("te", "https://example.com/feed.xml")
+
+	if p.FetchAndParse(feed) == nil {
+		t.Fatal("expected parsed feed")
+	}
+	link := feed.hub.Load()
+	if link == nil || link.hub != "https://hub.example.com/" || link.topic != "https://example.com/feed.xml" {
+		t.Errorf("hub = %+v", link)
+	}
+}
+
+func TestWebSubPush_Workers(t *testing.T) {
+	var callback string
+	fm := &FeedsMonitor{}
+	fm.WebSub = WebSubConfig{Callback: "https://bot.example/websub/", Secret: "s3cret"}
+	fm.Scheduler.Workers = 1
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			var form fasthttp.Args
+			form.ParseBytes(req.Body())
+			callback = string(form.Peek("hub.callback"))
+			resp.SetStatusCode(fasthttp.StatusAccepted)
+			return nil
+		},
+	})
+	feed := NewTestFeed("te", "https://example.com/feed.xml")
+	feed.setHub("https://hub.example.com/", "https://example.com/feed.xml")
+	fm.subscribeFeed(feed)
+	sub := fm.subscription(feed, feed.hub.Load())
+
+	handler := fm.Handler()
+	push := func() {
+		body := []byte(`<rss version="2.0"><channel><title>T</title><item><title>Old</title><guid>1</guid><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate></item></channel></rss>`)
+		mac := hmac.New(sha256.New, []byte(sub.secret))
+		mac.Write(body)
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI(strings.TrimPrefix(callback, "https://bot.example"))
+		ctx.Request.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		ctx.Request.SetBody(body)
+		handler(ctx)
+		if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+			t.Errorf("status = %d, want 202", ctx.Response.StatusCode())
+		}
+	}
+
+	seen := func() uint64 {
+		feed.stats.mu.Lock()
+		defer feed.stats.mu.Unlock()
+		return feed.stats.seen
+	}
+
+	// pushes wait for a worker, and a feed is processed once at a time:
+	// the second push waits for the first one
+	release := fm.acquireWorker()
+	push()
+	push()
+	time.Sleep(20 * time.Millisecond)
+	if !feed.fetching.Load() || seen() != 0 {
+		t.Fatal("push processed without a worker")
+	}
+	release()
+	for feed.fetching.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if n := seen(); n != 2 {
+		t.Errorf("%d items processed, want the items of both pushes", n)
+	}
+}
+
+func TestWebSubPush_DuringFetch(t *testing.T) {
+	fm := loadInstancesConfig(t, `instance:
+  url: https://mastodon.example
+  feed:
+    - name: News
+      url: https://example.com/feed.xml
+      token: news-token
+websub:
+  callback: https://bot.example/websub/
+  secret: s3cret
+`)
+	if err := fm.initInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.initTargets(); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var posted []string
+	for _, mi := range fm.instances {
+		mi.client = &mockHostClient{
+			handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+				mu.Lock()
+				posted = append(posted, jsoniter.Get(req.Body(), "status").ToString())
+				mu.Unlock()
+				resp.SetStatusCode(fasthttp.StatusOK)
+				resp.SetBodyString(`{"id":"1"}`)
+				return nil
+			},
+		}
+	}
+	fetching, unblock := make(chan struct{}), make(chan struct{})
+	var callback string
+	fm.Parser = NewParser(&mockHostClient{
+		handler: func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			if string(req.Header.Method()) == fasthttp.MethodPost {
+				// subscription request to the hub
+				var form fasthttp.Args
+				form.ParseBytes(req.Body())
+				callback = string(form.Peek("hub.callback"))
+				resp.SetStatusCode(fasthttp.StatusAccepted)
+				return nil
+			}
+			close(fetching)
+			<-unblock
+			resp.SetStatusCode(fasthttp.StatusNotModified)
+			return nil
+		},
+	})
+	f := fm.Instance.Feeds[0]
+	fm.setFeedDefaults(f)
+	f.setHub("https://hub.example.com/", f.URL())
+	fm.subscribeFeed(f)
+	sub := fm.subscription(f, f.hub.Load())
+
+	done := make(chan struct{})
+	go func() {
+		fm.GetFeed(f)
+		close(done)
+	}()
+	<-fetching
+
+	guid := fmt.Sprintf("push-during-fetch-%d", time.Now().UnixNano())
+	body := []byte(`<rss version="2.0"><channel><title>T</title><item><title>Pushed</title><link>https://example.com/pushed</link><guid>` +
+		guid + `</guid><pubDate>` + time.Now().UTC().Format(time.RFC1123Z) + `</pubDate></item></channel></rss>`)
+	mac := hmac.New(sha256.New, []byte(sub.secret))
+	mac.Write(body)
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+	ctx.Request.SetRequestURI(strings.TrimPrefix(callback, "https://bot.example"))
+	ctx.Request.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	ctx.Request.SetBody(body)
+	fm.Handler()(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("status = %d, want 202", ctx.Response.StatusCode())
+	}
+
+	debugMode = false
+	defer func() { debugMode = true }()
+	close(unblock)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 1 || !strings.HasPrefix(posted[0], "Pushed") {
+		t.Errorf("posts = %q, want the pushed item", posted)
+	}
+	if f.fetching.Load() {
+		t.Error("feed still marked as being fetched")
+	}
+}